@@ -0,0 +1,84 @@
+package main
+
+// отдельная команда для миграций, когда сервис стартует с -auto-migrate=false
+// go run ./cmd/migrate -d "postgres://..." up|down [steps]|version
+// вместо -d можно передать -storage postgres://...
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+
+	"github.com/mkukarin01/snort/internal/config"
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+func main() {
+	cfg := config.NewConfig()
+	dsn := cfg.DatabaseDSN
+	if strings.HasPrefix(cfg.Storage, "postgres://") || strings.HasPrefix(cfg.Storage, "postgresql://") {
+		dsn = cfg.Storage
+	}
+	if dsn == "" {
+		log.Fatal("DATABASE_DSN / -d or postgres:// STORAGE_URL / -storage is required")
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				log.Fatalf("Invalid steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read version: %v", err)
+		}
+		fmt.Printf("db version %d, binary version %d\n", version, migrator.Latest())
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate [-d dsn] up | down [steps] | version")
+	os.Exit(2)
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"log"
+
 	"github.com/mkukarin01/snort/internal/app"
 )
 
 func main() {
-	app.Run()
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,206 @@
+package main
+
+// перенос ссылок между хранилищами и через NDJSON архив
+// go run ./cmd/snortctl migrate -from file://./storage.json -to postgres://...
+// go run ./cmd/snortctl export -from postgres://... -o links.ndjson
+// go run ./cmd/snortctl import -i links.ndjson -to file://./storage.json
+// упавший перенос продолжается с -checkpoint, если запустить с теми же адресами
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mkukarin01/snort/internal/config"
+	"github.com/mkukarin01/snort/internal/storage"
+	"github.com/mkukarin01/snort/internal/transfer"
+)
+
+const defaultCheckpoint = "snortctl.checkpoint"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "migrate":
+		err = runMigrate(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  snortctl migrate -from <storage> -to <storage> [-checkpoint file] [-batch n]
+  snortctl export  -from <storage> -o <archive.ndjson> [-checkpoint file] [-batch n]
+  snortctl import  -i <archive.ndjson> -to <storage> [-checkpoint file] [-batch n]
+storage: mem://, file:///path/to/storage.json, postgres://...`)
+	os.Exit(2)
+}
+
+// commonFlags флаги, общие для всех команд
+type commonFlags struct {
+	checkpoint string
+	batch      int
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := &commonFlags{}
+	fs.StringVar(&c.checkpoint, "checkpoint", defaultCheckpoint, "File to resume an interrupted run from, empty to disable")
+	fs.IntVar(&c.batch, "batch", transfer.DefaultBatchSize, "Records per write")
+	return fs, c
+}
+
+func runMigrate(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("migrate")
+	from := fs.String("from", "", "Source storage")
+	to := fs.String("to", "", "Destination storage")
+	fs.Parse(args)
+	if *from == "" || *to == "" || *from == *to {
+		return fmt.Errorf("migrate: -from and -to are required and must differ")
+	}
+
+	src, srcClose, err := openExporter(*from)
+	if err != nil {
+		return err
+	}
+	defer srcClose()
+	dst, dstClose, err := openImporter(*to)
+	if err != nil {
+		return err
+	}
+	defer dstClose()
+
+	return run(ctx, src, dst, *from, *to, common)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("export")
+	from := fs.String("from", "", "Source storage")
+	out := fs.String("o", "", "Archive file")
+	fs.Parse(args)
+	if *from == "" || *out == "" {
+		return fmt.Errorf("export: -from and -o are required")
+	}
+
+	src, srcClose, err := openExporter(*from)
+	if err != nil {
+		return err
+	}
+	defer srcClose()
+
+	// архив продолжаем только вместе с checkpoint, иначе пишем заново
+	var after string
+	if common.checkpoint != "" {
+		if after, err = transfer.ResumeAfter(common.checkpoint); err != nil {
+			return err
+		}
+	}
+	archive, err := transfer.CreateArchive(*out, after)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return run(ctx, src, archive, *from, *out, common)
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("import")
+	in := fs.String("i", "", "Archive file")
+	to := fs.String("to", "", "Destination storage")
+	fs.Parse(args)
+	if *in == "" || *to == "" {
+		return fmt.Errorf("import: -i and -to are required")
+	}
+
+	archive, err := transfer.OpenArchive(*in)
+	if err != nil {
+		return err
+	}
+	dst, dstClose, err := openImporter(*to)
+	if err != nil {
+		return err
+	}
+	defer dstClose()
+
+	return run(ctx, archive, dst, *in, *to, common)
+}
+
+func run(ctx context.Context, src storage.Exporter, dst storage.Importer, from, to string, common *commonFlags) error {
+	stats, err := transfer.Copy(ctx, src, dst, transfer.Options{
+		BatchSize:  common.batch,
+		Checkpoint: common.checkpoint,
+		Source:     from,
+		Dest:       to,
+		Progress: func(s transfer.Stats) {
+			log.Printf("copied %d records", s.Copied)
+		},
+	})
+	if stats.ResumedAfter != "" {
+		log.Printf("resumed after %q", stats.ResumedAfter)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("copied %d records, source has %d, destination has %d\n", stats.Copied, stats.SourceCount, stats.DestCount)
+	return nil
+}
+
+// openBackend бэкенд без кэша и таймаутов, схему в бд накатываем если надо
+func openBackend(location string) (storage.Storager, error) {
+	return storage.Open(location, &config.Config{AutoMigrate: true})
+}
+
+func openExporter(location string) (storage.Exporter, func(), error) {
+	store, err := openBackend(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, ok := store.(storage.Exporter)
+	if !ok {
+		store.Close()
+		return nil, nil, fmt.Errorf("%s: storage does not support export", location)
+	}
+	return src, closer(store), nil
+}
+
+func openImporter(location string) (storage.Importer, func(), error) {
+	store, err := openBackend(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, ok := store.(storage.Importer)
+	if !ok {
+		store.Close()
+		return nil, nil, fmt.Errorf("%s: storage does not support import", location)
+	}
+	return dst, closer(store), nil
+}
+
+func closer(c io.Closer) func() {
+	return func() {
+		if err := c.Close(); err != nil {
+			log.Printf("close: %v", err)
+		}
+	}
+}
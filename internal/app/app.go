@@ -1,16 +1,30 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/mkukarin01/snort/internal/bots"
 	"github.com/mkukarin01/snort/internal/config"
+	"github.com/mkukarin01/snort/internal/geoip"
 	"github.com/mkukarin01/snort/internal/router"
 	"github.com/mkukarin01/snort/internal/service"
 	"github.com/mkukarin01/snort/internal/storage"
 )
 
-func Run() {
+// shutdownTimeout сколько ждем текущие запросы при остановке
+const shutdownTimeout = 10 * time.Second
+
+// Run поднимаем сервис и работаем до SIGINT/SIGTERM; выходим обычным return,
+// чтобы отложенные Stop сбросили накопленное, а store.Close свернул журнал
+func Run() error {
 	cfg := config.NewConfig()
 
 	if err := cfg.Validate(); err != nil {
@@ -23,12 +37,76 @@ func Run() {
 	}
 	defer store.Close()
 
-	// fanIn
+	// fanIn, Stop отложен после store.Close - очередь удалений сбросится до закрытия хранилища
 	deleter := service.NewURLDeleter(store)
-	// воркер-горутина
-	go deleter.Run()
+	deleter.Start()
+	defer deleter.Stop()
+
+	// фанин переходов для статистики, накопленное сбросит при остановке
+	classifier, err := bots.New(bots.Config{
+		PatternsFile: cfg.BotPatternsFile,
+		RepeatWindow: cfg.BotRepeatWindow,
+		RepeatLimit:  cfg.BotRepeatLimit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize bot filter: %w", err)
+	}
+	// гео по IP из локальной базы, без нее страны переходов не знаем; базу разбираем
+	// один раз здесь: битый файл - не стартуем, а не молча пишем переходы без стран
+	var geo *geoip.Resolver
+	if cfg.GeoIPPath != "" {
+		if geo, err = geoip.Open(cfg.GeoIPPath, cfg.GeoIPReload); err != nil {
+			return fmt.Errorf("failed to load GeoIP database: %w", err)
+		}
+		geo.Start()
+		defer geo.Stop()
+	}
+	clicks := service.NewClickRecorder(store, cfg.SecretKey, classifier, geo)
+	clicks.Start()
+	defer clicks.Stop()
+
+	// чистильщик удаленных ссылок
+	if cfg.PurgeInterval > 0 {
+		purger := service.NewURLPurger(store, cfg.DeletedRetention, cfg.PurgeInterval)
+		purger.Start()
+		defer purger.Stop()
+	}
+
+	// свертка старых переходов в суточные агрегаты
+	if cfg.ClickRetention > 0 && cfg.RollupInterval > 0 {
+		compactor := service.NewClickCompactor(store, cfg.ClickRetention, cfg.RollupInterval)
+		compactor.Start()
+		defer compactor.Stop()
+	}
+
+	r := router.NewRouter(cfg, store, deleter, clicks)
+	srv := &http.Server{Addr: cfg.Address, Handler: r}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on http://%s\n", cfg.Address)
+		errCh <- srv.ListenAndServe()
+	}()
 
-	r := router.NewRouter(cfg, store, deleter)
-	log.Printf("Starting server on http://%s\n", cfg.Address)
-	log.Fatal(http.ListenAndServe(cfg.Address, r))
+	select {
+	case err := <-errCh:
+		// не поднялись (порт занят и т.п.) - все равно выходим через defer
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// новые запросы не принимаем, текущие дожидаемся - после них переходов больше не будет
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
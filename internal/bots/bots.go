@@ -0,0 +1,203 @@
+// Package bots отличаем ботов и краулеров от людей на редиректе: превью ссылок
+// из мессенджеров и поисковики дергают GET /{id} постоянно и съели бы всю статистику
+package bots
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reason почему переход посчитан ботом, пустая строка - человек
+type Reason string
+
+const (
+	ReasonNone      Reason = ""
+	ReasonUserAgent Reason = "user_agent"
+	ReasonHead      Reason = "head"
+	ReasonPrefetch  Reason = "prefetch"
+	ReasonRepeat    Reason = "repeat"
+)
+
+// DefaultPatterns регулярки по User-Agent без учета регистра, если файл не задан
+var DefaultPatterns = []string{
+	`bot\b`, `crawl`, `spider`, `slurp`,
+	// превью ссылок
+	`facebookexternalhit`, `whatsapp`, `skypeuripreview`, `embedly`, `vkshare`, `preview`,
+	// утилиты и headless браузеры
+	`^curl/`, `^wget/`, `python-requests`, `go-http-client`, `headlesschrome`, `lighthouse`,
+	// без User-Agent ходят только скрипты
+	`^$`,
+}
+
+// maxClients больше клиентов для повторов не помним: map с каждого адреса - легкий способ
+// съесть память, при переполнении просто начинаем заново
+const maxClients = 100_000
+
+// Config RepeatLimit переходов с одного клиента по одной ссылке за RepeatWindow
+// еще люди, дальше - бот; 0 - повторы не проверяем
+type Config struct {
+	// PatternsFile по регулярке на строку, # - комментарий; заменяет DefaultPatterns
+	PatternsFile string
+	RepeatWindow time.Duration
+	RepeatLimit  int
+}
+
+// Classifier потокобезопасен, nil - все переходы человеческие
+type Classifier struct {
+	ua     *regexp.Regexp
+	window time.Duration
+	limit  int
+
+	mu        sync.Mutex
+	hits      map[string]*hits
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type hits struct {
+	start time.Time
+	n     int
+}
+
+func New(cfg Config) (*Classifier, error) {
+	patterns := DefaultPatterns
+	if cfg.PatternsFile != "" {
+		var err error
+		if patterns, err = LoadPatterns(cfg.PatternsFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RepeatLimit < 0 || cfg.RepeatWindow < 0 {
+		return nil, fmt.Errorf("bot repeat limit and window cannot be negative")
+	}
+	if cfg.RepeatLimit > 0 && cfg.RepeatWindow == 0 {
+		return nil, fmt.Errorf("bot repeat window must be positive when repeat limit is set")
+	}
+
+	// одной регуляркой: на каждом редиректе прогонять десятки по очереди дороже
+	parts := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid bot pattern %q: %w", p, err)
+		}
+		parts = append(parts, "(?:"+p+")")
+	}
+	var ua *regexp.Regexp
+	if len(parts) > 0 {
+		ua = regexp.MustCompile("(?i)" + strings.Join(parts, "|"))
+	}
+
+	return &Classifier{
+		ua:     ua,
+		window: cfg.RepeatWindow,
+		limit:  cfg.RepeatLimit,
+		hits:   make(map[string]*hits),
+		now:    time.Now,
+	}, nil
+}
+
+// LoadPatterns читаем регулярки из файла, пустые строки и комментарии пропускаем
+func LoadPatterns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bot patterns: %w", err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bot patterns: %w", err)
+	}
+	return patterns, nil
+}
+
+// Hit что знаем о переходе; Client - кто пришел (адрес или его хэш), пустой - повторы не считаем
+type Hit struct {
+	Link      string
+	Method    string
+	UserAgent string
+	Prefetch  bool
+	Client    string
+}
+
+// IsPrefetch браузер или мессенджер грузит ссылку заранее, а не по клику
+func IsPrefetch(h http.Header) bool {
+	for _, name := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		v := strings.ToLower(h.Get(name))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "preview") {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify сначала дешевые признаки запроса, последним - повторы: их считаем только
+// для похожих на людей, чтобы боты не вытесняли клиентов из памяти
+func (c *Classifier) Classify(h Hit) Reason {
+	if c == nil {
+		return ReasonNone
+	}
+	switch {
+	case c.ua != nil && c.ua.MatchString(h.UserAgent):
+		return ReasonUserAgent
+	case h.Method == http.MethodHead:
+		return ReasonHead
+	case h.Prefetch:
+		return ReasonPrefetch
+	case c.repeated(h):
+		return ReasonRepeat
+	}
+	return ReasonNone
+}
+
+// repeated окно фиксированное с первого перехода: проще скользящего, а для отсечения
+// долбящих скриптов хватает
+func (c *Classifier) repeated(h Hit) bool {
+	if c.limit <= 0 || h.Client == "" {
+		return false
+	}
+	key := h.Client + "\x00" + h.UserAgent + "\x00" + h.Link
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+
+	entry, ok := c.hits[key]
+	if !ok || now.Sub(entry.start) >= c.window {
+		if len(c.hits) >= maxClients {
+			c.hits = make(map[string]*hits)
+		}
+		c.hits[key] = &hits{start: now, n: 1}
+		return false
+	}
+	entry.n++
+	return entry.n > c.limit
+}
+
+// sweep раз в окно выкидываем истекшие, вызывать под mu
+func (c *Classifier) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.window {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.hits {
+		if now.Sub(entry.start) >= c.window {
+			delete(c.hits, key)
+		}
+	}
+}
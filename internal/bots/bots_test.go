@@ -0,0 +1,99 @@
+package bots
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const browserUA = "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+
+func TestClassifier_Classify(t *testing.T) {
+	c, err := New(Config{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		hit  Hit
+		want Reason
+	}{
+		{"browser", Hit{Method: http.MethodGet, UserAgent: browserUA}, ReasonNone},
+		{"telegram preview", Hit{Method: http.MethodGet, UserAgent: "TelegramBot (like TwitterBot)"}, ReasonUserAgent},
+		{"facebook", Hit{Method: http.MethodGet, UserAgent: "facebookexternalhit/1.1"}, ReasonUserAgent},
+		{"googlebot", Hit{Method: http.MethodGet, UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1)"}, ReasonUserAgent},
+		{"curl", Hit{Method: http.MethodGet, UserAgent: "curl/8.5.0"}, ReasonUserAgent},
+		{"no user agent", Hit{Method: http.MethodGet}, ReasonUserAgent},
+		{"head", Hit{Method: http.MethodHead, UserAgent: browserUA}, ReasonHead},
+		{"prefetch", Hit{Method: http.MethodGet, UserAgent: browserUA, Prefetch: true}, ReasonPrefetch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.Classify(tt.hit))
+		})
+	}
+
+	var nilClassifier *Classifier
+	assert.Equal(t, ReasonNone, nilClassifier.Classify(Hit{UserAgent: "curl/8.5.0"}))
+}
+
+func TestClassifier_Repeat(t *testing.T) {
+	c, err := New(Config{RepeatWindow: 10 * time.Second, RepeatLimit: 2})
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	hit := Hit{Link: "id1", Method: http.MethodGet, UserAgent: browserUA, Client: "c1"}
+	assert.Equal(t, ReasonNone, c.Classify(hit))
+	assert.Equal(t, ReasonNone, c.Classify(hit))
+	assert.Equal(t, ReasonRepeat, c.Classify(hit))
+	// другая ссылка и другой клиент считаются отдельно
+	assert.Equal(t, ReasonNone, c.Classify(Hit{Link: "id2", Method: http.MethodGet, UserAgent: browserUA, Client: "c1"}))
+	assert.Equal(t, ReasonNone, c.Classify(Hit{Link: "id1", Method: http.MethodGet, UserAgent: browserUA, Client: "c2"}))
+	// без адреса повторы не считаем
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, ReasonNone, c.Classify(Hit{Link: "id1", Method: http.MethodGet, UserAgent: browserUA}))
+	}
+
+	// окно прошло - снова человек, старые записи вычищены
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, ReasonNone, c.Classify(hit))
+	assert.Len(t, c.hits, 1)
+}
+
+func TestIsPrefetch(t *testing.T) {
+	for header, value := range map[string]string{
+		"Purpose":     "prefetch",
+		"Sec-Purpose": "prefetch;prerender",
+		"X-Moz":       "prefetch",
+		"X-Purpose":   "preview",
+	} {
+		h := http.Header{}
+		h.Set(header, value)
+		assert.True(t, IsPrefetch(h), header)
+	}
+	assert.False(t, IsPrefetch(http.Header{"Accept": {"text/html"}}))
+}
+
+func TestNew_PatternsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# только свои\n\nmonitoring-agent\n"), 0644))
+
+	c, err := New(Config{PatternsFile: path})
+	require.NoError(t, err)
+	assert.Equal(t, ReasonUserAgent, c.Classify(Hit{UserAgent: "Monitoring-Agent/1.0"}))
+	// список из файла заменяет встроенный
+	assert.Equal(t, ReasonNone, c.Classify(Hit{UserAgent: "curl/8.5.0"}))
+
+	require.NoError(t, os.WriteFile(path, []byte("broken(\n"), 0644))
+	_, err = New(Config{PatternsFile: path})
+	assert.Error(t, err)
+	_, err = New(Config{PatternsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+	_, err = New(Config{RepeatLimit: 3})
+	assert.Error(t, err)
+}
@@ -1,12 +1,18 @@
 package config
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/idgen"
 )
 
 // Config структурка данных для конфига
@@ -18,9 +24,64 @@ type Config struct {
 	BaseURL         string
 	FileStoragePath string
 	DatabaseDSN     string
-	SecretKey       string
+	// Storage адрес хранилища mem://, file:///path, postgres://..., важнее -f и -d
+	Storage     string
+	AutoMigrate bool
+	// DatabaseReplicas DSN реплик через запятую, с них читаем редиректы
+	DatabaseReplicas string
+	DedupScope       string
+	SecretKey        string
+	// дедлайны на операции с хранилищем, 0 - только контекст запроса
+	StorageReadTimeout  time.Duration
+	StorageWriteTimeout time.Duration
+	// кэш редиректов, 0 - выключен
+	CacheSize        int
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	// очистка удаленных ссылок: сколько храним после удаления и как часто чистим, 0 - не чистим
+	DeletedRetention time.Duration
+	PurgeInterval    time.Duration
+	RestoreGrace     time.Duration
+	// перебор паролей ссылок: после PasswordMaxAttempts неудач со всех адресов ссылка закрыта
+	// для всех на PasswordLockout, после PasswordClientMaxAttempts с одного адреса - для него; 0 - без ограничения
+	PasswordMaxAttempts       int
+	PasswordClientMaxAttempts int
+	PasswordLockout           time.Duration
+	// пользовательские алиасы: класс символов регулярки (как внутри [...]), длина
+	// и зарезервированные слова через запятую в добавок к путям самого сервиса
+	AliasCharset  string
+	AliasMinLen   int
+	AliasMaxLen   int
+	AliasReserved string
+	// генерация short_id: random, counter, hash или words и их настройки
+	IDStrategy string
+	IDLength   int
+	IDAlphabet string
+	IDWords    int
+	// IDMaxAttempts сколько раз перегенерируем занятый short_id, потом 503
+	IDMaxAttempts int
+	// IDGrowThreshold доля коллизий, после которой id удлиняется на знак, 0 - никогда
+	IDGrowThreshold float64
+	// TrustProxy адрес клиента берем из X-Forwarded-For/X-Real-IP
+	TrustProxy bool
+	// боты на редиректе: свой список регулярок User-Agent вместо встроенного и
+	// сколько переходов с одного клиента за окно еще считаем людьми, 0 - не считаем
+	BotPatternsFile string
+	BotRepeatWindow time.Duration
+	BotRepeatLimit  int
+	// сырые переходы старше ClickRetention раз в RollupInterval сворачиваем в суточные
+	// агрегаты, 0 в любом из них - храним сырые вечно
+	ClickRetention time.Duration
+	RollupInterval time.Duration
+	// GeoIPPath .mmdb база MaxMind (GeoLite2/GeoIP2 Country или City) для страны и региона
+	// переходов, пустой - без гео; файл перечитываем раз в GeoIPReload, если он поменялся
+	GeoIPPath   string
+	GeoIPReload time.Duration
 }
 
+// maxShortIDLen столбец short_id в бд - VARCHAR(128)
+const maxShortIDLen = 128
+
 // NewConfig запускаем конфигурацию, наполняем структурку, данными из командой строки
 func NewConfig() *Config {
 	cfg := &Config{
@@ -32,12 +93,78 @@ func NewConfig() *Config {
 	envBasePath := os.Getenv("BASE_URL")
 	envFileStoragePath := os.Getenv("FILE_STORAGE_PATH")
 	envDatabaseDSN := os.Getenv("DATABASE_DSN")
+	envStorage := os.Getenv("STORAGE_URL")
+	envAutoMigrate := os.Getenv("AUTO_MIGRATE")
+	envDatabaseReplicas := os.Getenv("DATABASE_REPLICA_DSNS")
+	envDedupScope := os.Getenv("DEDUP_SCOPE")
+	envStorageReadTimeout := os.Getenv("STORAGE_READ_TIMEOUT")
+	envStorageWriteTimeout := os.Getenv("STORAGE_WRITE_TIMEOUT")
+	envCacheSize := os.Getenv("CACHE_SIZE")
+	envCacheTTL := os.Getenv("CACHE_TTL")
+	envCacheNegativeTTL := os.Getenv("CACHE_NEGATIVE_TTL")
+	envDeletedRetention := os.Getenv("DELETED_RETENTION")
+	envPurgeInterval := os.Getenv("PURGE_INTERVAL")
+	envRestoreGrace := os.Getenv("RESTORE_GRACE")
+	envPasswordMaxAttempts := os.Getenv("PASSWORD_MAX_ATTEMPTS")
+	envPasswordClientMaxAttempts := os.Getenv("PASSWORD_CLIENT_MAX_ATTEMPTS")
+	envPasswordLockout := os.Getenv("PASSWORD_LOCKOUT")
+	envAliasCharset := os.Getenv("ALIAS_CHARSET")
+	envAliasMinLen := os.Getenv("ALIAS_MIN_LEN")
+	envAliasMaxLen := os.Getenv("ALIAS_MAX_LEN")
+	envAliasReserved := os.Getenv("ALIAS_RESERVED")
+	envIDStrategy := os.Getenv("ID_STRATEGY")
+	envIDLength := os.Getenv("ID_LENGTH")
+	envIDAlphabet := os.Getenv("ID_ALPHABET")
+	envIDWords := os.Getenv("ID_WORDS")
+	envIDMaxAttempts := os.Getenv("ID_MAX_ATTEMPTS")
+	envIDGrowThreshold := os.Getenv("ID_GROW_THRESHOLD")
+	envTrustProxy := os.Getenv("TRUST_PROXY")
+	envBotPatternsFile := os.Getenv("BOT_PATTERNS_FILE")
+	envBotRepeatWindow := os.Getenv("BOT_REPEAT_WINDOW")
+	envBotRepeatLimit := os.Getenv("BOT_REPEAT_LIMIT")
+	envClickRetention := os.Getenv("CLICK_RETENTION")
+	envRollupInterval := os.Getenv("ROLLUP_INTERVAL")
+	envGeoIPPath := os.Getenv("GEOIP_DB")
+	envGeoIPReload := os.Getenv("GEOIP_RELOAD")
 
 	// аргументы/флаги/etc
 	flag.StringVar(&cfg.Port, "a", "8080", "Port for HTTP server")
 	flag.StringVar(&cfg.BasePath, "b", "", "Base path for shortened links")
 	flag.StringVar(&cfg.FileStoragePath, "f", "./storage.json", "Path to file storage for shortened links")
 	flag.StringVar(&cfg.DatabaseDSN, "d", "", "Database connection string (PostgreSQL)")
+	flag.StringVar(&cfg.Storage, "storage", "", "Storage location: mem://, file:///path/to/storage.json or postgres://... (overrides -f and -d)")
+	flag.StringVar(&cfg.DatabaseReplicas, "db-replicas", "", "Comma separated read replica DSNs (PostgreSQL only)")
+	flag.BoolVar(&cfg.AutoMigrate, "auto-migrate", true, "Apply pending database migrations on startup")
+	flag.StringVar(&cfg.DedupScope, "dedup", "global", "Original URL dedup scope: global, per-user or none")
+	flag.DurationVar(&cfg.StorageReadTimeout, "storage-read-timeout", 2*time.Second, "Timeout for storage reads, 0 to disable")
+	flag.DurationVar(&cfg.StorageWriteTimeout, "storage-write-timeout", 5*time.Second, "Timeout for storage writes, 0 to disable")
+	flag.IntVar(&cfg.CacheSize, "cache-size", 0, "Max number of redirect lookups kept in LRU cache, 0 to disable")
+	flag.DurationVar(&cfg.CacheTTL, "cache-ttl", 5*time.Minute, "How long a cached redirect lives")
+	flag.DurationVar(&cfg.CacheNegativeTTL, "cache-negative-ttl", 10*time.Second, "How long not found/deleted ids are cached, 0 to disable")
+	flag.DurationVar(&cfg.DeletedRetention, "deleted-retention", 30*24*time.Hour, "How long deleted links are kept before purge")
+	flag.DurationVar(&cfg.PurgeInterval, "purge-interval", time.Hour, "How often deleted links are purged, 0 to disable")
+	flag.DurationVar(&cfg.RestoreGrace, "restore-grace", 24*time.Hour, "How long after deletion a link can be restored")
+	flag.IntVar(&cfg.PasswordMaxAttempts, "password-max-attempts", 10, "Failed password attempts from all clients before a protected link is locked for everyone, 0 to disable")
+	flag.IntVar(&cfg.PasswordClientMaxAttempts, "password-client-max-attempts", 5, "Failed password attempts from one client before a protected link is locked for it, 0 to disable")
+	flag.DurationVar(&cfg.PasswordLockout, "password-lockout", 15*time.Minute, "How long a protected link stays locked after too many failed attempts")
+	flag.StringVar(&cfg.AliasCharset, "alias-charset", "A-Za-z0-9_-", "Characters allowed in custom aliases, as a regexp character class body")
+	flag.IntVar(&cfg.AliasMinLen, "alias-min-len", 3, "Minimum custom alias length")
+	flag.IntVar(&cfg.AliasMaxLen, "alias-max-len", 64, "Maximum custom alias length")
+	flag.StringVar(&cfg.AliasReserved, "alias-reserved", "admin,static,health,metrics", "Comma separated words that can't be used as aliases, in addition to api and ping")
+	flag.StringVar(&cfg.IDStrategy, "id-strategy", idgen.StrategyRandom, "Short ID generator: random, counter, hash or words")
+	flag.IntVar(&cfg.IDLength, "id-length", idgen.DefaultLength, "Short ID length for random and hash, minimum length for counter")
+	flag.StringVar(&cfg.IDAlphabet, "id-alphabet", idgen.Base62, "Characters used by random, counter and hash IDs")
+	flag.IntVar(&cfg.IDWords, "id-words", idgen.DefaultWords, "Number of words in words IDs")
+	flag.IntVar(&cfg.IDMaxAttempts, "id-max-attempts", 10, "How many times a colliding short ID is regenerated before giving up")
+	flag.Float64Var(&cfg.IDGrowThreshold, "id-grow-threshold", 0.1, "Collision rate after which generated IDs get one character longer, 0 to disable")
+	flag.BoolVar(&cfg.TrustProxy, "trust-proxy", false, "Take client IP from X-Forwarded-For/X-Real-IP (only behind a reverse proxy)")
+	flag.StringVar(&cfg.BotPatternsFile, "bot-patterns", "", "File with bot User-Agent regexps, one per line, replacing the built-in list")
+	flag.DurationVar(&cfg.BotRepeatWindow, "bot-repeat-window", 10*time.Second, "Window for counting repeated hits from the same client")
+	flag.IntVar(&cfg.BotRepeatLimit, "bot-repeat-limit", 5, "Hits on one link from the same client per window before the rest count as bot, 0 to disable")
+	flag.DurationVar(&cfg.ClickRetention, "click-retention", 90*24*time.Hour, "How long raw clicks are kept before being rolled up into daily aggregates, 0 to keep forever")
+	flag.DurationVar(&cfg.RollupInterval, "rollup-interval", time.Hour, "How often old raw clicks are rolled up, 0 to disable")
+	flag.StringVar(&cfg.GeoIPPath, "geoip-db", "", "Path to a MaxMind .mmdb database for click country and region, empty to disable")
+	flag.DurationVar(&cfg.GeoIPReload, "geoip-reload", time.Minute, "How often the GeoIP database file is checked for changes, 0 to disable")
 
 	flag.Parse()
 
@@ -54,6 +181,105 @@ func NewConfig() *Config {
 	if envDatabaseDSN != "" {
 		cfg.DatabaseDSN = envDatabaseDSN
 	}
+	if envStorage != "" {
+		cfg.Storage = envStorage
+	}
+	if envDatabaseReplicas != "" {
+		cfg.DatabaseReplicas = envDatabaseReplicas
+	}
+	if envAutoMigrate != "" {
+		cfg.AutoMigrate = parseBool("AUTO_MIGRATE", envAutoMigrate, cfg.AutoMigrate)
+	}
+	if envDedupScope != "" {
+		cfg.DedupScope = envDedupScope
+	}
+	if envStorageReadTimeout != "" {
+		cfg.StorageReadTimeout = parseDuration("STORAGE_READ_TIMEOUT", envStorageReadTimeout, cfg.StorageReadTimeout)
+	}
+	if envStorageWriteTimeout != "" {
+		cfg.StorageWriteTimeout = parseDuration("STORAGE_WRITE_TIMEOUT", envStorageWriteTimeout, cfg.StorageWriteTimeout)
+	}
+	if envCacheSize != "" {
+		cfg.CacheSize = parseInt("CACHE_SIZE", envCacheSize, cfg.CacheSize)
+	}
+	if envCacheTTL != "" {
+		cfg.CacheTTL = parseDuration("CACHE_TTL", envCacheTTL, cfg.CacheTTL)
+	}
+	if envCacheNegativeTTL != "" {
+		cfg.CacheNegativeTTL = parseDuration("CACHE_NEGATIVE_TTL", envCacheNegativeTTL, cfg.CacheNegativeTTL)
+	}
+	if envDeletedRetention != "" {
+		cfg.DeletedRetention = parseDuration("DELETED_RETENTION", envDeletedRetention, cfg.DeletedRetention)
+	}
+	if envPurgeInterval != "" {
+		cfg.PurgeInterval = parseDuration("PURGE_INTERVAL", envPurgeInterval, cfg.PurgeInterval)
+	}
+	if envRestoreGrace != "" {
+		cfg.RestoreGrace = parseDuration("RESTORE_GRACE", envRestoreGrace, cfg.RestoreGrace)
+	}
+	if envPasswordMaxAttempts != "" {
+		cfg.PasswordMaxAttempts = parseInt("PASSWORD_MAX_ATTEMPTS", envPasswordMaxAttempts, cfg.PasswordMaxAttempts)
+	}
+	if envPasswordClientMaxAttempts != "" {
+		cfg.PasswordClientMaxAttempts = parseInt("PASSWORD_CLIENT_MAX_ATTEMPTS", envPasswordClientMaxAttempts, cfg.PasswordClientMaxAttempts)
+	}
+	if envPasswordLockout != "" {
+		cfg.PasswordLockout = parseDuration("PASSWORD_LOCKOUT", envPasswordLockout, cfg.PasswordLockout)
+	}
+	if envAliasCharset != "" {
+		cfg.AliasCharset = envAliasCharset
+	}
+	if envAliasMinLen != "" {
+		cfg.AliasMinLen = parseInt("ALIAS_MIN_LEN", envAliasMinLen, cfg.AliasMinLen)
+	}
+	if envAliasMaxLen != "" {
+		cfg.AliasMaxLen = parseInt("ALIAS_MAX_LEN", envAliasMaxLen, cfg.AliasMaxLen)
+	}
+	if envAliasReserved != "" {
+		cfg.AliasReserved = envAliasReserved
+	}
+	if envIDStrategy != "" {
+		cfg.IDStrategy = envIDStrategy
+	}
+	if envIDLength != "" {
+		cfg.IDLength = parseInt("ID_LENGTH", envIDLength, cfg.IDLength)
+	}
+	if envIDAlphabet != "" {
+		cfg.IDAlphabet = envIDAlphabet
+	}
+	if envIDWords != "" {
+		cfg.IDWords = parseInt("ID_WORDS", envIDWords, cfg.IDWords)
+	}
+	if envIDMaxAttempts != "" {
+		cfg.IDMaxAttempts = parseInt("ID_MAX_ATTEMPTS", envIDMaxAttempts, cfg.IDMaxAttempts)
+	}
+	if envIDGrowThreshold != "" {
+		cfg.IDGrowThreshold = parseFloat("ID_GROW_THRESHOLD", envIDGrowThreshold, cfg.IDGrowThreshold)
+	}
+	if envTrustProxy != "" {
+		cfg.TrustProxy = parseBool("TRUST_PROXY", envTrustProxy, cfg.TrustProxy)
+	}
+	if envBotPatternsFile != "" {
+		cfg.BotPatternsFile = envBotPatternsFile
+	}
+	if envBotRepeatWindow != "" {
+		cfg.BotRepeatWindow = parseDuration("BOT_REPEAT_WINDOW", envBotRepeatWindow, cfg.BotRepeatWindow)
+	}
+	if envBotRepeatLimit != "" {
+		cfg.BotRepeatLimit = parseInt("BOT_REPEAT_LIMIT", envBotRepeatLimit, cfg.BotRepeatLimit)
+	}
+	if envClickRetention != "" {
+		cfg.ClickRetention = parseDuration("CLICK_RETENTION", envClickRetention, cfg.ClickRetention)
+	}
+	if envRollupInterval != "" {
+		cfg.RollupInterval = parseDuration("ROLLUP_INTERVAL", envRollupInterval, cfg.RollupInterval)
+	}
+	if envGeoIPPath != "" {
+		cfg.GeoIPPath = envGeoIPPath
+	}
+	if envGeoIPReload != "" {
+		cfg.GeoIPReload = parseDuration("GEOIP_RELOAD", envGeoIPReload, cfg.GeoIPReload)
+	}
 	// настроим секретный ключ, вдруг попросят его передавать
 	cfg.SecretKey = "supersecretkey"
 
@@ -96,6 +322,46 @@ func NewConfig() *Config {
 	return cfg
 }
 
+// parseDuration разбираем длительность из окружения, при ошибке оставляем значение флага
+func parseDuration(name, value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Invalid %s value, fallback to %v: %v\n", name, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+// parseInt разбираем число из окружения, при ошибке оставляем значение флага
+func parseInt(name, value string, fallback int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Invalid %s value, fallback to %d: %v\n", name, fallback, err)
+		return fallback
+	}
+	return n
+}
+
+// parseFloat разбираем дробное из окружения, при ошибке оставляем значение флага
+func parseFloat(name, value string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fmt.Printf("Invalid %s value, fallback to %v: %v\n", name, fallback, err)
+		return fallback
+	}
+	return f
+}
+
+// parseBool разбираем флаг из окружения, при ошибке оставляем значение флага
+func parseBool(name, value string, fallback bool) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Invalid %s value, fallback to %v: %v\n", name, fallback, err)
+		return fallback
+	}
+	return b
+}
+
 // Validate свалидируем конфиг
 func (c *Config) Validate() error {
 	if c.Port == "" {
@@ -104,5 +370,99 @@ func (c *Config) Validate() error {
 	if c.BaseDomain == "" {
 		return fmt.Errorf("base domain cannot be empty")
 	}
+	if c.Storage != "" && !strings.Contains(c.Storage, "://") {
+		return fmt.Errorf("storage location %q must look like scheme://...", c.Storage)
+	}
+	switch c.DedupScope {
+	case "", "global", "per-user", "none":
+	default:
+		return fmt.Errorf("unknown dedup scope %q: want global, per-user or none", c.DedupScope)
+	}
+	if c.StorageReadTimeout < 0 || c.StorageWriteTimeout < 0 {
+		return fmt.Errorf("storage timeouts cannot be negative")
+	}
+	if c.DeletedRetention < 0 || c.PurgeInterval < 0 {
+		return fmt.Errorf("deleted retention and purge interval cannot be negative")
+	}
+	if c.RestoreGrace < 0 {
+		return fmt.Errorf("restore grace cannot be negative")
+	}
+	if (c.PasswordMaxAttempts > 0 || c.PasswordClientMaxAttempts > 0) && c.PasswordLockout <= 0 {
+		return fmt.Errorf("password lockout must be positive when attempts are limited")
+	}
+	if c.AliasMinLen < 0 || c.AliasMaxLen < 0 || (c.AliasMaxLen > 0 && c.AliasMinLen > c.AliasMaxLen) {
+		return fmt.Errorf("alias length limits must be non-negative and min must not exceed max")
+	}
+	if _, err := regexp.Compile("^[" + c.AliasCharset + "]+$"); c.AliasCharset != "" && err != nil {
+		return fmt.Errorf("invalid alias charset %q: %w", c.AliasCharset, err)
+	}
+	if c.AliasMaxLen > maxShortIDLen {
+		return fmt.Errorf("alias max length cannot exceed %d", maxShortIDLen)
+	}
+	// генератор собираем вхолостую, счетчику последовательность тут не нужна
+	noSequence := func(context.Context) (int64, error) { return 0, nil }
+	if _, err := idgen.New(idgen.Config{
+		Strategy: c.IDStrategy,
+		Length:   c.IDLength,
+		Alphabet: c.IDAlphabet,
+		Words:    c.IDWords,
+	}, noSequence); err != nil {
+		return fmt.Errorf("invalid short id settings: %w", err)
+	}
+	// hash выдает одному url один id: без дедупликации повторное сокращение
+	// перезаписало бы прошлую ссылку вместе с ее лимитами, сроком и паролем
+	if c.IDStrategy == idgen.StrategyHash && c.DedupScope == "none" {
+		return fmt.Errorf("id strategy %q needs dedup scope global or per-user", idgen.StrategyHash)
+	}
+	if c.IDMaxAttempts < 0 {
+		return fmt.Errorf("id max attempts cannot be negative")
+	}
+	if c.IDGrowThreshold < 0 || c.IDGrowThreshold > 1 {
+		return fmt.Errorf("id grow threshold must be between 0 and 1")
+	}
+	// классификатор с регулярками собирает app, тут только проверим настройки и файл
+	if c.BotRepeatLimit < 0 || c.BotRepeatWindow < 0 {
+		return fmt.Errorf("bot repeat limit and window cannot be negative")
+	}
+	if c.BotRepeatLimit > 0 && c.BotRepeatWindow == 0 {
+		return fmt.Errorf("bot repeat window must be positive when repeat limit is set")
+	}
+	if c.BotPatternsFile != "" {
+		if err := checkReadable(c.BotPatternsFile); err != nil {
+			return fmt.Errorf("invalid bot patterns file: %w", err)
+		}
+	}
+	if c.ClickRetention < 0 || c.RollupInterval < 0 {
+		return fmt.Errorf("click retention and rollup interval cannot be negative")
+	}
+	if c.GeoIPReload < 0 {
+		return fmt.Errorf("geoip reload interval cannot be negative")
+	}
+	// саму базу разбирает app при старте, тут только проверим, что файл есть
+	if c.GeoIPPath != "" {
+		if err := checkReadable(c.GeoIPPath); err != nil {
+			return fmt.Errorf("invalid geoip database: %w", err)
+		}
+	}
+	if c.CacheSize > 0 && c.CacheTTL <= 0 {
+		return fmt.Errorf("cache ttl must be positive when cache is enabled")
+	}
+	return nil
+}
+
+// checkReadable файл существует, это не каталог и его можно открыть на чтение
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
 	return nil
 }
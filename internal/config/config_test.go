@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,4 +74,96 @@ func TestConfig_Validate_Error(t *testing.T) {
 	}
 
 	assert.Error(t, cfg.Validate(), "base domain cannot be empty")
+
+	cfg = &Config{
+		Port:       "8080",
+		BaseDomain: "localhost",
+		DedupScope: "per-team",
+	}
+
+	assert.Error(t, cfg.Validate(), "unknown dedup scope")
+
+	cfg = &Config{
+		Port:         "8080",
+		BaseDomain:   "localhost",
+		AliasCharset: "z-a",
+	}
+
+	assert.Error(t, cfg.Validate(), "invalid alias charset")
+
+	cfg = &Config{
+		Port:        "8080",
+		BaseDomain:  "localhost",
+		AliasMinLen: 10,
+		AliasMaxLen: 5,
+	}
+
+	assert.Error(t, cfg.Validate(), "alias min length over max")
+
+	cfg = &Config{
+		Port:       "8080",
+		BaseDomain: "localhost",
+		IDStrategy: "uuid",
+	}
+
+	assert.Error(t, cfg.Validate(), "unknown id strategy")
+
+	cfg = &Config{
+		Port:       "8080",
+		BaseDomain: "localhost",
+		IDStrategy: "random",
+		IDLength:   8,
+		IDAlphabet: "ab/c",
+	}
+
+	assert.Error(t, cfg.Validate(), "id alphabet not safe in a path")
+
+	cfg = &Config{
+		Port:       "8080",
+		BaseDomain: "localhost",
+		IDStrategy: "hash",
+		DedupScope: "none",
+	}
+
+	assert.Error(t, cfg.Validate(), "hash ids without dedup")
+
+	cfg = &Config{
+		Port:            "8080",
+		BaseDomain:      "localhost",
+		IDGrowThreshold: 1.5,
+	}
+
+	assert.Error(t, cfg.Validate(), "id grow threshold over 1")
+
+	cfg = &Config{
+		Port:            "8080",
+		BaseDomain:      "localhost",
+		BotPatternsFile: "/nonexistent/bots.txt",
+	}
+
+	assert.Error(t, cfg.Validate(), "missing bot patterns file")
+
+	cfg = &Config{
+		Port:           "8080",
+		BaseDomain:     "localhost",
+		BotRepeatLimit: 10,
+	}
+
+	assert.Error(t, cfg.Validate(), "bot repeat limit without window")
+
+	cfg = &Config{
+		Port:           "8080",
+		BaseDomain:     "localhost",
+		ClickRetention: -time.Hour,
+	}
+
+	assert.Error(t, cfg.Validate(), "negative click retention")
+
+	cfg = &Config{
+		Port:       "8080",
+		BaseDomain: "localhost",
+		GeoIPPath:  "/nonexistent/geo.mmdb",
+	}
+
+	assert.Error(t, cfg.Validate(), "missing geoip database")
 }
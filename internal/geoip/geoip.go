@@ -0,0 +1,154 @@
+// Package geoip страна и регион клиента по локальной базе в формате MaxMind (.mmdb):
+// файл разбираем сами, во внешние API не ходим, при замене файла подхватываем новый
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Location что нашли по адресу, пустые поля - не знаем
+type Location struct {
+	// Country ISO 3166-1 alpha-2, например RU
+	Country string
+	// Region код первого субъекта из ISO 3166-2 без страны, например MOW
+	Region string
+}
+
+// Locate страна и регион из записи GeoIP2/GeoLite2 Country или City
+func (db *Database) Locate(addr netip.Addr) (Location, error) {
+	v, err := db.Lookup(addr)
+	if err != nil || v == nil {
+		return Location{}, err
+	}
+	rec, _ := v.(map[string]any)
+	loc := Location{Country: isoCode(rec["country"])}
+	if loc.Country == "" {
+		// анонимные прокси и спутник без страны - берем страну регистрации сети
+		loc.Country = isoCode(rec["registered_country"])
+	}
+	if subs, ok := rec["subdivisions"].([]any); ok && len(subs) > 0 {
+		loc.Region = isoCode(subs[0])
+	}
+	return loc, nil
+}
+
+func isoCode(v any) string {
+	m, _ := v.(map[string]any)
+	code, _ := m["iso_code"].(string)
+	return code
+}
+
+// Load читаем файл в память целиком: City база - десятки мегабайт, mmap ради нее не нужен
+func Load(path string) (*Database, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geoip database: %w", err)
+	}
+	db, err := Parse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Resolver база с подгрузкой: раз в interval смотрим время изменения и размер файла.
+// Поллинг, а не inotify - без зависимостей и переживает замену файла через rename.
+// nil-резолвер ничего не находит
+type Resolver struct {
+	path     string
+	interval time.Duration
+	db       atomic.Pointer[Database]
+
+	// modTime/size последней загруженной версии, трогает только reload
+	modTime time.Time
+	size    int64
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open загружаем базу сразу: с битым файлом лучше не стартовать, чем молча писать
+// переходы без стран; interval 0 - без подгрузки
+func Open(path string, interval time.Duration) (*Resolver, error) {
+	r := &Resolver{path: path, interval: interval, stopCh: make(chan struct{})}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start запускаем слежение за файлом
+func (r *Resolver) Start() {
+	if r.interval <= 0 {
+		return
+	}
+	r.wg.Add(1)
+	go r.run()
+}
+
+func (r *Resolver) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			// файл могут дописывать прямо сейчас - оставляем старую базу и пробуем на следующем тике
+			if reloaded, err := r.reload(); err != nil {
+				log.Printf("ERROR: geoip reload %s, keeping previous database: %v", r.path, err)
+			} else if reloaded {
+				log.Printf("GeoIP database %s reloaded", r.path)
+			}
+		}
+	}
+}
+
+// Stop - остановить
+func (r *Resolver) Stop() {
+	close(r.stopCh)
+	r.wg.Wait()
+}
+
+// reload перечитываем файл, если он поменялся; true - подменили базу
+func (r *Resolver) reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat geoip database: %w", err)
+	}
+	if r.db.Load() != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+	db, err := Load(r.path)
+	if err != nil {
+		return false, err
+	}
+	r.db.Store(db)
+	r.modTime, r.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// Locate по строке адреса; кривой адрес или ошибка базы - пустое место, редирект важнее
+func (r *Resolver) Locate(ip string) Location {
+	if r == nil || ip == "" {
+		return Location{}
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}
+	}
+	loc, err := r.db.Load().Locate(addr)
+	if err != nil {
+		return Location{}
+	}
+	return loc
+}
@@ -0,0 +1,188 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode минимальный кодировщик секции данных MaxMind DB: строки, map, массивы и uint32
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append([]byte{typeString<<5 | byte(len(v))}, v...)
+	case uint32:
+		return binary.BigEndian.AppendUint32([]byte{typeUint32<<5 | 4}, v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := []byte{typeMap<<5 | byte(len(v))}
+		for _, k := range keys {
+			out = append(out, encode(k)...)
+			out = append(out, encode(v[k])...)
+		}
+		return out
+	case []any:
+		out := []byte{byte(len(v)), typeArray - 7}
+		for _, e := range v {
+			out = append(out, encode(e)...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+// buildDB IPv4 база с 24-битными записями; сети не должны пересекаться
+func buildDB(nets map[string]any) []byte {
+	const empty = -1
+	type node [2]int
+	nodes := []node{{empty, empty}}
+	// leaf записи в дереве - смещение в секции данных со знаком минус, чтобы отличить от узлов
+	var data []byte
+	for prefix, rec := range nets {
+		p := netip.MustParsePrefix(prefix)
+		addr := p.Addr().As4()
+		off := len(data)
+		data = append(data, encode(rec)...)
+
+		n := 0
+		for i := 0; i < p.Bits(); i++ {
+			bit := addr[i/8] >> (7 - i%8) & 1
+			if i == p.Bits()-1 {
+				nodes[n][bit] = -2 - off
+				break
+			}
+			if nodes[n][bit] == empty {
+				nodes = append(nodes, node{empty, empty})
+				nodes[n][bit] = len(nodes) - 1
+			}
+			n = nodes[n][bit]
+		}
+	}
+
+	count := len(nodes)
+	var buf []byte
+	for _, nd := range nodes {
+		for _, r := range nd {
+			v := r
+			switch {
+			case r == empty:
+				v = count
+			case r < empty:
+				v = count + dataSeparator + (-2 - r)
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, dataSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	return append(buf, encode(map[string]any{
+		"node_count":    uint32(count),
+		"record_size":   uint32(24),
+		"ip_version":    uint32(4),
+		"database_type": "Test-City",
+	})...)
+}
+
+func city(country, region string) map[string]any {
+	rec := map[string]any{"country": map[string]any{"iso_code": country}}
+	if region != "" {
+		rec["subdivisions"] = []any{map[string]any{"iso_code": region}}
+	}
+	return rec
+}
+
+func TestDatabase_Locate(t *testing.T) {
+	db, err := Parse(buildDB(map[string]any{
+		"10.0.0.0/8":     city("RU", "MOW"),
+		"192.168.1.0/24": city("DE", ""),
+		"172.16.0.0/12": map[string]any{
+			"registered_country": map[string]any{"iso_code": "NL"},
+		},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "Test-City", db.Type)
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{"10.1.2.3", Location{Country: "RU", Region: "MOW"}},
+		{"192.168.1.200", Location{Country: "DE"}},
+		{"172.20.0.1", Location{Country: "NL"}},
+		{"::ffff:10.0.0.1", Location{Country: "RU", Region: "MOW"}},
+		{"192.168.2.1", Location{}},
+		{"8.8.8.8", Location{}},
+		{"2001:db8::1", Location{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			loc, err := db.Locate(netip.MustParseAddr(tt.ip))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, loc)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	valid := buildDB(map[string]any{"10.0.0.0/8": city("RU", "")})
+
+	for name, buf := range map[string][]byte{
+		"empty":     nil,
+		"no marker": []byte("definitely not a database"),
+		"truncated": valid[len(valid)/2:],
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(buf)
+			assert.ErrorIs(t, err, ErrInvalidDatabase)
+		})
+	}
+}
+
+func TestResolver_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	require.NoError(t, os.WriteFile(path, buildDB(map[string]any{"10.0.0.0/8": city("RU", "MOW")}), 0o644))
+
+	r, err := Open(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, Location{Country: "RU", Region: "MOW"}, r.Locate("10.0.0.1"))
+	assert.Equal(t, Location{}, r.Locate("not an ip"))
+
+	// без изменений файл не перечитываем
+	reloaded, err := r.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// битый файл - остаемся на старой базе
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+	_, err = r.reload()
+	assert.Error(t, err)
+	assert.Equal(t, "RU", r.Locate("10.0.0.1").Country)
+
+	require.NoError(t, os.WriteFile(path, buildDB(map[string]any{"10.0.0.0/8": city("FR", "IDF")}), 0o644))
+	// mtime на части файловых систем грубый - сдвигаем явно
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	reloaded, err = r.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, Location{Country: "FR", Region: "IDF"}, r.Locate("10.0.0.1"))
+
+	var nilResolver *Resolver
+	assert.Equal(t, Location{}, nilResolver.Locate("10.0.0.1"))
+}
+
+func TestOpen_Missing(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"), time.Minute)
+	assert.Error(t, err)
+}
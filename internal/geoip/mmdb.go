@@ -0,0 +1,336 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker метаданные лежат в конце файла сразу после этой метки
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSeparator между деревом поиска и секцией данных 16 нулевых байт
+const dataSeparator = 16
+
+// maxDecodeDepth вложенность map/array глубже этого - битый файл, а не данные
+const maxDecodeDepth = 32
+
+// типы полей секции данных
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+// ErrInvalidDatabase файл не разобрался как MMDB
+var ErrInvalidDatabase = errors.New("invalid MaxMind database")
+
+// Database разобранный в память .mmdb файл: дерево поиска по битам адреса и секция
+// данных в формате MaxMind DB; читается конкурентно без блокировок
+type Database struct {
+	buf        []byte
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	// Type database_type из метаданных, например GeoLite2-City
+	Type string
+}
+
+// Parse разбираем содержимое файла; buf дальше не копируется и не должен меняться
+func Parse(buf []byte) (*Database, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+	meta := buf[idx+len(metadataMarker):]
+	raw, _, err := (&decoder{data: meta}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %w", ErrInvalidDatabase, err)
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	db := &Database{buf: buf}
+	db.nodeCount = uintField(m, "node_count")
+	db.recordSize = uintField(m, "record_size")
+	db.ipVersion = uintField(m, "ip_version")
+	db.Type, _ = m["database_type"].(string)
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported ip version %d", ErrInvalidDatabase, db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if db.nodeCount == 0 || treeSize+dataSeparator > uint(idx) {
+		return nil, fmt.Errorf("%w: search tree does not fit the file", ErrInvalidDatabase)
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+dataSeparator : idx]
+
+	// в IPv6 базе IPv4 лежат в ::/96 - находим этот узел один раз
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func uintField(m map[string]any, key string) uint {
+	switch v := m[key].(type) {
+	case uint64:
+		return uint(v)
+	case uint32:
+		return uint(v)
+	case uint16:
+		return uint(v)
+	}
+	return 0
+}
+
+// record левая (bit 0) или правая (bit 1) запись узла
+func (db *Database) record(node uint, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node * 6
+		if bit == 1 {
+			off += 3
+		}
+		b := db.tree[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node * 8
+		if bit == 1 {
+			off += 4
+		}
+		return uint(binary.BigEndian.Uint32(db.tree[off : off+4]))
+	}
+}
+
+// Lookup запись для адреса, nil без ошибки - адреса в базе нет
+func (db *Database) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+	var (
+		bits []byte
+		node uint
+	)
+	switch {
+	case addr.Is4() && db.ipVersion == 6:
+		a := addr.As4()
+		bits, node = a[:], db.ipv4Start
+	case addr.Is4():
+		a := addr.As4()
+		bits = a[:]
+	case addr.Is6() && db.ipVersion == 6:
+		a := addr.As16()
+		bits = a[:]
+	default:
+		// IPv6 в IPv4 базе не найти
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, fmt.Errorf("%w: search tree is deeper than the address", ErrInvalidDatabase)
+	}
+	offset := node - db.nodeCount - dataSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+	v, _, err := (&decoder{data: db.data}).decode(offset, 0)
+	return v, err
+}
+
+// decoder секция данных MaxMind DB; указатели считаются от ее начала
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) bytes(off, n uint) ([]byte, error) {
+	if off+n > uint(len(d.data)) || off+n < off {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	return d.data[off : off+n], nil
+}
+
+// decode значение по смещению off и смещение сразу за ним
+func (d *decoder) decode(off uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w: data is nested too deep", ErrInvalidDatabase)
+	}
+	ctrl, err := d.bytes(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	off++
+	typ := uint(ctrl[0] >> 5)
+
+	if typ == typePointer {
+		ptr, next, err := d.pointer(ctrl[0], off)
+		if err != nil {
+			return nil, 0, err
+		}
+		// указатель на указатель формат запрещает, так что рекурсия тут неглубокая
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		ext, err := d.bytes(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		off++
+		typ = 7 + uint(ext[0])
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		switch size {
+		case 29:
+			size = 29 + uint(b[0])
+		case 30:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", ErrInvalidDatabase)
+			}
+			v, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case typeArray:
+		// размер из файла - емкость ограничиваем, чтобы битый файл не выделил гигабайты
+		a := make([]any, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case typeBool:
+		return size != 0, off, nil
+	}
+
+	b, err := d.bytes(off, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	off += size
+	switch typ {
+	case typeString:
+		return string(b), off, nil
+	case typeBytes:
+		return append([]byte(nil), b...), off, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of %d bytes", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of %d bytes", ErrInvalidDatabase, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), off, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w: integer of %d bytes", ErrInvalidDatabase, size)
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int32(uint32(n)), off, nil
+		}
+		return n, off, nil
+	case typeUint128:
+		// в geo полях не встречается, отдаем как есть
+		return append([]byte(nil), b...), off, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown data type %d", ErrInvalidDatabase, typ)
+}
+
+// pointer адрес из указателя: длина в битах 3-4 управляющего байта, старшие биты - в 0-2
+func (d *decoder) pointer(ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3&0x3) + 1
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	vvv := uint(ctrl & 0x7)
+	var ptr uint
+	switch n {
+	case 1:
+		ptr = vvv<<8 | uint(b[0])
+	case 2:
+		ptr = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		ptr = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		ptr = uint(binary.BigEndian.Uint32(b))
+	}
+	return ptr, off + n, nil
+}
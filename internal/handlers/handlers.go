@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mkukarin01/snort/internal/middleware"
@@ -13,9 +15,57 @@ import (
 	"github.com/mkukarin01/snort/internal/storage"
 )
 
+// LinkOptionsRequest - необязательные свойства ссылки: срок жизни датой (RFC 3339)
+// или ttl ("72h"), что-то одно, лимит переходов max_clicks, пароль и свой алиас
+// (алиас проверяет сервис, правила у него в конфиге)
+type LinkOptionsRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+	MaxClicks *int64     `json:"max_clicks,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	Alias     string     `json:"alias,omitempty"`
+}
+
+// maxLinkPasswordLen bcrypt смотрит только на первые 72 байта
+const maxLinkPasswordLen = 72
+
+// linkOptions проверяем свойства и переводим ttl в дату
+func (e LinkOptionsRequest) linkOptions(now time.Time) (service.LinkOptions, error) {
+	var opts service.LinkOptions
+	if e.MaxClicks != nil {
+		if *e.MaxClicks <= 0 {
+			return opts, errors.New("max_clicks must be positive")
+		}
+		opts.MaxClicks = e.MaxClicks
+	}
+	if len(e.Password) > maxLinkPasswordLen {
+		return opts, errors.New("password must be at most 72 bytes")
+	}
+	opts.Password = e.Password
+	opts.Alias = e.Alias
+	switch {
+	case e.ExpiresAt != nil && e.TTL != "":
+		return opts, errors.New("expires_at and ttl are mutually exclusive")
+	case e.TTL != "":
+		ttl, err := time.ParseDuration(e.TTL)
+		if err != nil || ttl <= 0 {
+			return opts, errors.New("ttl must be a positive duration")
+		}
+		expiresAt := now.Add(ttl)
+		opts.ExpiresAt = &expiresAt
+	case e.ExpiresAt != nil:
+		if !e.ExpiresAt.After(now) {
+			return opts, errors.New("expires_at must be in the future")
+		}
+		opts.ExpiresAt = e.ExpiresAt
+	}
+	return opts, nil
+}
+
 // URLRequest - структура запроса для JSON-POST
 type URLRequest struct {
 	URL string `json:"url"`
+	LinkOptionsRequest
 }
 
 // URLResponse - структура ответа для JSON-POST
@@ -23,6 +73,19 @@ type URLResponse struct {
 	Result string `json:"result"`
 }
 
+// storageErrorStatus статус для ошибок доступности хранилища, общих для всех ручек:
+// дедлайн - 504, отмена/нет связи с бд/не нашли свободный short_id - 503
+func storageErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, context.Canceled), errors.Is(err, storage.ErrDBConnection),
+		errors.Is(err, service.ErrIDSpaceExhausted):
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
+
 // HandleShorten - обработчик для POST /
 func HandleShorten(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string) {
 	bodyBytes, err := io.ReadAll(r.Body)
@@ -46,10 +109,14 @@ func HandleShorten(w http.ResponseWriter, r *http.Request, shortener *service.UR
 	// достанем userID из контекста, если он там есть
 	userID := middleware.GetUserIDFromContext(r.Context())
 
-	id, shortErr := shortener.Shorten(urlStr, userID)
+	id, shortErr := shortener.Shorten(r.Context(), urlStr, userID)
 	shortURL := baseURL + "/" + id
 
 	if shortErr != nil {
+		if status, ok := storageErrorStatus(shortErr); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
 		// конфликт - возвращаем 409
 		if errors.Is(shortErr, storage.ErrURLConflict) {
 			w.WriteHeader(http.StatusConflict)
@@ -84,11 +151,25 @@ func HandleShortenJSON(w http.ResponseWriter, r *http.Request, shortener *servic
 		return
 	}
 
+	opts, err := req.linkOptions(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	userID := middleware.GetUserIDFromContext(r.Context())
 
-	id, shortErr := shortener.Shorten(req.URL, userID)
+	id, shortErr := shortener.ShortenLink(r.Context(), req.URL, userID, opts)
 	shortURL := baseURL + "/" + id
 
+	if status, ok := storageErrorStatus(shortErr); ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if writeAliasError(w, shortErr) {
+		return
+	}
+
 	resp := URLResponse{Result: shortURL}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -115,10 +196,25 @@ func HandleShortenJSON(w http.ResponseWriter, r *http.Request, shortener *servic
 	json.NewEncoder(w).Encode(resp)
 }
 
+// writeAliasError кривой алиас - 400, занятый - 409 с самим алиасом в тексте;
+// false - ошибка не про алиас
+func writeAliasError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrInvalidAlias):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrAliasTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
 // BatchRequest - структурка запроса
 type BatchRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	LinkOptionsRequest
 }
 
 // BatchResponse - структурка ответа
@@ -143,18 +239,35 @@ func HandleShortenBatch(w http.ResponseWriter, r *http.Request, shortener *servi
 	}
 
 	// каждая ссылка в батче была валидной
-	urls := make(map[string]string)
+	now := time.Now()
+	urls := make(map[string]service.BatchURL)
 	for _, item := range req {
 		parsedURL, err := url.ParseRequestURI(item.OriginalURL)
 		if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
 			http.Error(w, "Invalid URL in batch", http.StatusBadRequest)
 			return
 		}
-		urls[item.CorrelationID] = item.OriginalURL
+		opts, err := item.linkOptions(now)
+		if err != nil {
+			http.Error(w, "Invalid link options in batch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		urls[item.CorrelationID] = service.BatchURL{OriginalURL: item.OriginalURL, LinkOptions: opts}
 	}
 
 	userID := middleware.GetUserIDFromContext(r.Context())
-	shortened := shortener.ShortenBatch(urls, userID)
+	shortened, err := shortener.ShortenBatchLinks(r.Context(), urls, userID)
+	if err != nil {
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if writeAliasError(w, err) {
+			return
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
 	var res []BatchResponse
 	for correlationID, shortID := range shortened {
@@ -169,29 +282,66 @@ func HandleShortenBatch(w http.ResponseWriter, r *http.Request, shortener *servi
 	json.NewEncoder(w).Encode(res)
 }
 
-// HandleRedirect - обработчик для GET /{id}
-func HandleRedirect(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener) {
+// HandleRedirect - обработчик для GET /{id} и POST /{id} (форма пароля)
+// пароль защищенной ссылки берем из заголовка X-Link-Password или из формы,
+// удачный переход отдаем в clicks (nil - статистику не пишем)
+func HandleRedirect(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, clicks *service.ClickRecorder) {
 	id := chi.URLParam(r, "id")
-	originalURL, err := shortener.Retrieve(id)
+	password, fromHeader := linkPassword(w, r)
+	retrieve := shortener.RetrieveWithPassword
+	if r.Method == http.MethodHead {
+		// HEAD безопасный - лимит переходов им не тратим
+		retrieve = shortener.PeekWithPassword
+	}
+	originalURL, err := retrieve(r.Context(), id, password, clientIP(r))
 	if err != nil {
-		// Если получаем ошибку "удалено", возвращаем 410
-		if errors.Is(err, storage.ErrURLDeleted) {
-			http.Error(w, "URL is deleted", http.StatusGone)
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
-		// нет - возвращаем 404
-		if errors.Is(err, storage.ErrURLNotFound) {
-			http.Error(w, "URL not found", http.StatusNotFound)
+		if writePasswordError(w, err, fromHeader) {
 			return
 		}
-		// Если "не найдено" или любая другая - 400
+		if writeLinkError(w, err) {
+			return
+		}
+		// любая другая - 400
 		http.Error(w, "URL problem", http.StatusBadRequest)
 		return
 	}
 
+	clicks.Record(id, clickInfo(r))
+
+	// после формы уводим GET-ом, 307 повторил бы POST с паролем на чужой сайт
+	if r.Method == http.MethodPost {
+		http.Redirect(w, r, originalURL, http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
 }
 
+// writeLinkError недоступная ссылка: удаленная, просроченная и исчерпанная - 410,
+// несуществующая - 404; false - ошибка не про это
+func writeLinkError(w http.ResponseWriter, err error) bool {
+	switch {
+	// Если получаем ошибку "удалено", возвращаем 410
+	case errors.Is(err, storage.ErrURLDeleted):
+		http.Error(w, "URL is deleted", http.StatusGone)
+	// срок жизни вышел - тоже 410
+	case errors.Is(err, storage.ErrURLExpired):
+		http.Error(w, "URL is expired", http.StatusGone)
+	// переходы кончились - и это 410
+	case errors.Is(err, storage.ErrURLExhausted):
+		http.Error(w, "URL click limit is exhausted", http.StatusGone)
+	// нет - возвращаем 404
+	case errors.Is(err, storage.ErrURLNotFound):
+		http.Error(w, "URL not found", http.StatusNotFound)
+	default:
+		return false
+	}
+	return true
+}
+
 // HandlePing - обработчик для GET /ping
 func HandlePing(w http.ResponseWriter, r *http.Request, db storage.Storager) {
 	// db не инициализируем - 500 и работаем дальше
@@ -201,7 +351,7 @@ func HandlePing(w http.ResponseWriter, r *http.Request, db storage.Storager) {
 	}
 
 	// db не пингуется - 500 и все равно работаем дальше
-	if err := db.Ping(); err != nil {
+	if err := db.Ping(r.Context()); err != nil {
 		http.Error(w, "Database connection failed", http.StatusInternalServerError)
 		return
 	}
@@ -210,6 +360,12 @@ func HandlePing(w http.ResponseWriter, r *http.Request, db storage.Storager) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// HandleIDStats - обработчик для GET /api/stats/ids, счетчики коллизий для мониторинга
+func HandleIDStats(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shortener.IDStats())
+}
+
 // HandleUserURLs - обработчик для GET /api/user/urls
 func HandleUserURLs(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string) {
 	userID := middleware.GetUserIDFromContext(r.Context())
@@ -218,8 +374,12 @@ func HandleUserURLs(w http.ResponseWriter, r *http.Request, shortener *service.U
 		return
 	}
 
-	userURLs, err := shortener.UserURLs(userID, baseURL)
+	userURLs, err := shortener.UserURLs(r.Context(), userID, baseURL)
 	if err != nil {
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -247,8 +407,40 @@ func HandleDeleteUserURLs(w http.ResponseWriter, r *http.Request, deleter *servi
 	}
 
 	// Вызываем асинхронное удаление (fanIn)
-	deleter.Submit(userID, shortIDs)
+	if err := deleter.Submit(r.Context(), userID, shortIDs); err != nil {
+		http.Error(w, "Delete queue is busy", http.StatusServiceUnavailable)
+		return
+	}
 
 	// Возвращаем 202 Accepted
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// HandleRestoreUserURLs - обработчик для POST /api/user/urls/restore
+// отвечаем статусом по каждому id, 200 даже если ничего не восстановилось
+func HandleRestoreUserURLs(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, grace time.Duration) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var shortIDs []string
+	if err := json.NewDecoder(r.Body).Decode(&shortIDs); err != nil || len(shortIDs) == 0 {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	results, err := shortener.Restore(r.Context(), userID, shortIDs, grace)
+	if err != nil {
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
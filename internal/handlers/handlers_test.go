@@ -2,18 +2,23 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	gomock "github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/mkukarin01/snort/internal/bots"
 	"github.com/mkukarin01/snort/internal/config"
+	"github.com/mkukarin01/snort/internal/middleware"
 	"github.com/mkukarin01/snort/internal/service"
 	"github.com/mkukarin01/snort/internal/storage"
 )
@@ -55,7 +60,19 @@ func createRouter(s *service.URLShortener, cfg *config.Config) http.Handler {
 	})
 
 	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-		HandleRedirect(w, r, s)
+		HandleRedirect(w, r, s, nil)
+	})
+	r.Head("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleRedirect(w, r, s, nil)
+	})
+	r.Post("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleRedirect(w, r, s, nil)
+	})
+	r.Get("/{id}/qr", func(w http.ResponseWriter, r *http.Request) {
+		HandleQR(w, r, s, cfg.BaseURL)
+	})
+	r.Post("/api/qr", func(w http.ResponseWriter, r *http.Request) {
+		HandleQRJSON(w, r, s, cfg.BaseURL)
 	})
 
 	return r
@@ -107,7 +124,7 @@ func TestHandler_GetShortURL(t *testing.T) {
 	ms := storage.NewMemoryStorage()
 	shortener := service.NewURLShortener(ms)
 	uid := "foo"
-	id, _ := shortener.Shorten("https://ya.ru", uid)
+	id, _ := shortener.Shorten(context.Background(), "https://ya.ru", uid)
 	url := "/" + id
 
 	r := createTestRouter(shortener)
@@ -130,7 +147,7 @@ func TestHandlePing_Success(t *testing.T) {
 
 	// мокаем бд
 	mockDB := storage.NewMockStorager(ctrl)
-	mockDB.EXPECT().Ping().Return(nil)
+	mockDB.EXPECT().Ping(gomock.Any()).Return(nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
 	rec := httptest.NewRecorder()
@@ -160,7 +177,7 @@ func TestHandlePing_FailedPing(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockDB := storage.NewMockStorager(ctrl)
-	mockDB.EXPECT().Ping().Return(assert.AnError)
+	mockDB.EXPECT().Ping(gomock.Any()).Return(assert.AnError)
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
 	rec := httptest.NewRecorder()
@@ -171,3 +188,357 @@ func TestHandlePing_FailedPing(t *testing.T) {
 		t.Errorf("expected status 500, got %d", rec.Code)
 	}
 }
+
+// Проверка HandleRedirect - дедлайн хранилища => 504, отмена => 503
+func TestHandleRedirect_StorageUnavailable(t *testing.T) {
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{context.Canceled, http.StatusServiceUnavailable},
+		{storage.ErrDBConnection, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		ctrl := gomock.NewController(t)
+		mockDB := storage.NewMockStorager(ctrl)
+		mockDB.EXPECT().LoadLink(gomock.Any(), "someID").Return(storage.Link{}, tc.err)
+
+		r := createTestRouter(service.NewURLShortener(mockDB))
+
+		req := httptest.NewRequest(http.MethodGet, "/someID", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Code, "error %v", tc.err)
+		ctrl.Finish()
+	}
+}
+
+// TestHandleRestoreUserURLs - статусы по каждому id в порядке запроса
+func TestHandleRestoreUserURLs(t *testing.T) {
+	cfg := &config.Config{SecretKey: "test-secret"}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := storage.NewMockStorager(ctrl)
+	mockDB.EXPECT().
+		RestoreUserURLs(gomock.Any(), "u1", []string{"id1", "id2"}, gomock.Any()).
+		Return(map[string]storage.RestoreStatus{"id1": storage.RestoreOK, "id2": storage.RestoreExpired}, nil)
+
+	shortener := service.NewURLShortener(mockDB)
+	r := chi.NewRouter()
+	r.Use(middleware.UserAuthMiddleware(cfg))
+	r.Post("/api/user/urls/restore", func(w http.ResponseWriter, r *http.Request) {
+		HandleRestoreUserURLs(w, r, shortener, time.Hour)
+	})
+
+	token, err := middleware.GenerateJWT("u1", "snort-service", "snort-users", cfg.SecretKey)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(`["id1","id2"]`))
+	req.AddCookie(&http.Cookie{Name: "SNORT_AUTH", Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id":"id1","status":"restored"},{"id":"id2","status":"expired"}]`, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(`[]`))
+	req.AddCookie(&http.Cookie{Name: "SNORT_AUTH", Value: token})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestHandler_ShortenExpiry - срок жизни через ttl/expires_at и 410 после него
+func TestHandler_ShortenExpiry(t *testing.T) {
+	ms := storage.NewMemoryStorage()
+	r := createTestRouter(service.NewURLShortener(ms))
+
+	testCases := []struct {
+		body   string
+		status int
+	}{
+		{`{"url":"https://ya.ru/ttl","ttl":"1h"}`, http.StatusCreated},
+		{`{"url":"https://ya.ru/at","expires_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`, http.StatusCreated},
+		{`{"url":"https://ya.ru/past","expires_at":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{`{"url":"https://ya.ru/both","ttl":"1h","expires_at":"2099-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{`{"url":"https://ya.ru/neg","ttl":"-1h"}`, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, tc.body)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	require.NoError(t, ms.SaveLink(context.Background(), "u1", storage.Link{
+		ShortURL: "old", OriginalURL: "https://ya.ru/old", ExpiresAt: &expired,
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/old", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+// TestHandler_MaxClicks - одноразовая ссылка: первый переход редирект, дальше 410
+func TestHandler_MaxClicks(t *testing.T) {
+	r := createTestRouter(service.NewURLShortener(storage.NewMemoryStorage()))
+
+	for _, body := range []string{
+		`{"url":"https://ya.ru/zero","max_clicks":0}`,
+		`{"url":"https://ya.ru/neg","max_clicks":-2}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://ya.ru/once","max_clicks":1}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	path := strings.TrimPrefix(resp.Result, "http://localhost:8080")
+
+	// HEAD от превьюшки единственный переход не тратит
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, path, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	for _, want := range []int{http.StatusTemporaryRedirect, http.StatusGone, http.StatusGone} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, want, w.Code)
+	}
+}
+
+// TestHandler_PasswordLink - форма без пароля, заголовок для API, после формы 303
+func TestHandler_PasswordLink(t *testing.T) {
+	shortener := service.NewURLShortener(storage.NewMemoryStorage(), service.WithPasswordThrottle(4, 2, time.Minute))
+	r := createTestRouter(shortener)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://docs.ru/private","password":"s3cret"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	path := strings.TrimPrefix(resp.Result, "http://localhost:8080")
+
+	// браузер без пароля - форма
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `name="password"`)
+
+	// API клиент с паролем в заголовке
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(PasswordHeader, "s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://docs.ru/private", w.Header().Get("Location"))
+
+	// форма с верным паролем - уводим GET-ом
+	req = httptest.NewRequest(http.MethodPost, path, strings.NewReader("password=s3cret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://docs.ru/private", w.Header().Get("Location"))
+
+	// неверный пароль: форма с ошибкой, потом текст, потом ссылка закрыта
+	req = httptest.NewRequest(http.MethodPost, path, strings.NewReader("password=nope"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Wrong password")
+
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(PasswordHeader, "nope")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(PasswordHeader, "s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// чужие неверные пароли владельца с другого адреса не закрывают
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "198.51.100.7:4321"
+	req.Header.Set(PasswordHeader, "s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+}
+
+// TestHandler_Alias - свой алиас: 201, занятый - 409, кривой - 400
+func TestHandler_Alias(t *testing.T) {
+	r := createTestRouter(nil)
+
+	testCases := []struct {
+		body   string
+		status int
+		result string
+	}{
+		{`{"url":"https://shop.ru/sale","alias":"spring-sale"}`, http.StatusCreated, "http://localhost:8080/spring-sale"},
+		{`{"url":"https://shop.ru/other","alias":"spring-sale"}`, http.StatusConflict, ""},
+		{`{"url":"https://shop.ru/other","alias":"ping"}`, http.StatusBadRequest, ""},
+		{`{"url":"https://shop.ru/other","alias":"no way"}`, http.StatusBadRequest, ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, tc.body)
+		if tc.result != "" {
+			var resp URLResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tc.result, resp.Result)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://shop.ru/other","alias":"spring-sale"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "spring-sale")
+}
+
+// TestHandler_LinkStats - редиректы попадают в статистику, которую видит только владелец
+func TestHandler_LinkStats(t *testing.T) {
+	cfg := &config.Config{SecretKey: "test-secret", BaseURL: "http://localhost:8080"}
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveUserURL(context.Background(), "u1", "id1", "https://ya.ru"))
+	shortener := service.NewURLShortener(store)
+	classifier, err := bots.New(bots.Config{})
+	require.NoError(t, err)
+	clicks := service.NewClickRecorder(store, cfg.SecretKey, classifier, nil)
+	clicks.Start()
+
+	r := chi.NewRouter()
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		HandleRedirect(w, r, shortener, clicks)
+	}
+	r.Get("/{id}", redirect)
+	r.Head("/{id}", redirect)
+	r.Group(func(private chi.Router) {
+		private.Use(middleware.UserAuthMiddleware(cfg))
+		private.Get("/api/user/urls/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+			HandleLinkStats(w, r, shortener, cfg.BaseURL)
+		})
+	})
+
+	visit := func(method, userAgent string) {
+		req := httptest.NewRequest(method, "/id1", nil)
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		visit(http.MethodGet, "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0")
+	}
+	// превью из мессенджера: редирект тот же, но в переходы людей не идет
+	visit(http.MethodGet, "TelegramBot (like TwitterBot)")
+	visit(http.MethodHead, "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0")
+	// несуществующая ссылка переходом не считается
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+	clicks.Stop()
+
+	get := func(userID, query string) *httptest.ResponseRecorder {
+		token, err := middleware.GenerateJWT(userID, "snort-service", "snort-users", cfg.SecretKey)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/id1/stats"+query, nil)
+		req.AddCookie(&http.Cookie{Name: "SNORT_AUTH", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("u1", "?bucket=hour")
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats service.LinkStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, "http://localhost:8080/id1", stats.ShortURL)
+	assert.EqualValues(t, 2, stats.TotalClicks)
+	assert.EqualValues(t, 2, stats.BotClicks)
+	assert.Equal(t, "hour", stats.Bucket)
+	var inBuckets int64
+	for _, b := range stats.Clicks {
+		inBuckets += b.Clicks
+	}
+	assert.EqualValues(t, 2, inBuckets)
+	// оба перехода с одного адреса
+	assert.EqualValues(t, 1, stats.UniqueVisitors)
+
+	assert.Equal(t, http.StatusOK, get("u1", "?bucket=week").Code)
+	assert.Equal(t, http.StatusNotFound, get("u2", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("u1", "?bucket=year").Code)
+	assert.Equal(t, http.StatusBadRequest, get("u1", "?from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, get("u1", "?from=2026-03-02T00:00:00Z&to=2026-03-01T00:00:00Z").Code)
+}
+
+// TestHandler_QR - PNG и SVG по query и JSON, статусы недоступной ссылки как у редиректа
+func TestHandler_QR(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveUserURL(ctx, "u1", "live", "https://ya.ru"))
+	require.NoError(t, store.SaveUserURL(ctx, "u1", "gone", "https://ya.ru/gone"))
+	require.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"gone"}))
+	r := createTestRouter(service.NewURLShortener(store))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live/qr", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live/qr?format=svg&size=500&level=h&margin=0", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	// http://localhost:8080/live на уровне H - версия 4, 33 модуля без тихой зоны
+	assert.Contains(t, w.Body.String(), `width="500" height="500" viewBox="0 0 33 33"`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/qr", strings.NewReader(`{"id":"live","format":"png","size":100,"margin":2}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	img, err = png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/missing/qr", "", http.StatusNotFound},
+		{http.MethodGet, "/gone/qr", "", http.StatusGone},
+		{http.MethodPost, "/api/qr", `{"id":"gone"}`, http.StatusGone},
+		{http.MethodPost, "/api/qr", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/qr", `{"id":`, http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?format=gif", "", http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?size=abc", "", http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?size=100000", "", http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?size=20", "", http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?level=X", "", http.StatusBadRequest},
+		{http.MethodGet, "/live/qr?margin=-1", "", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		assert.Equal(t, tt.want, w.Code, "%s %s %s", tt.method, tt.path, tt.body)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mkukarin01/snort/internal/service"
+)
+
+// PasswordHeader пароль ссылки для API клиентов, чтобы не постить форму
+const PasswordHeader = "X-Link-Password"
+
+// maxPasswordFormSize форма из одного поля, больше не читаем
+const maxPasswordFormSize = 4 << 10
+
+// passwordForm форма постит сама на себя, так что base path не важен
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .}}<p style="color:#b00">{{.}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// linkPassword пароль из заголовка или из формы; fromHeader - отвечаем текстом, а не формой
+func linkPassword(w http.ResponseWriter, r *http.Request) (password string, fromHeader bool) {
+	if password := r.Header.Get(PasswordHeader); password != "" {
+		return password, true
+	}
+	if r.Method != http.MethodPost {
+		return "", false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordFormSize)
+	return r.PostFormValue("password"), false
+}
+
+// writePasswordError ответ на ошибки пароля, false - ошибка не про пароль
+func writePasswordError(w http.ResponseWriter, err error, fromHeader bool) bool {
+	var throttled *service.TooManyAttemptsError
+	switch {
+	case errors.As(err, &throttled):
+		retry := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		http.Error(w, "Too many password attempts", http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrPasswordRequired):
+		renderPasswordForm(w, http.StatusUnauthorized, "")
+	case errors.Is(err, service.ErrPasswordInvalid):
+		if fromHeader {
+			http.Error(w, "Invalid password", http.StatusForbidden)
+			return true
+		}
+		renderPasswordForm(w, http.StatusForbidden, "Wrong password, try again.")
+	default:
+		return false
+	}
+	return true
+}
+
+func renderPasswordForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// форму с ошибкой кэшировать нельзя, иначе после верного пароля покажем старую
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := passwordForm.Execute(w, message); err != nil {
+		log.Printf("password form: %v", err)
+	}
+}
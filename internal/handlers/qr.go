@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mkukarin01/snort/internal/qr"
+	"github.com/mkukarin01/snort/internal/service"
+)
+
+const (
+	// defaultQRSize сторона картинки в пикселях
+	defaultQRSize = 256
+	// maxQRSize больше не рисуем: PNG 4096x4096 - это уже мегабайты на запрос
+	maxQRSize = 2048
+	// maxQRMargin тихая зона в модулях, больше стандартных 4 смысла мало
+	maxQRMargin = 16
+)
+
+// QRRequest - параметры QR кода: в GET /{id}/qr приходят query-параметрами,
+// в POST /api/qr - JSON; format png (по умолчанию) или svg, size - сторона в пикселях,
+// level - коррекция L, M (по умолчанию), Q или H, margin - тихая зона в модулях
+type QRRequest struct {
+	ID     string `json:"id"`
+	Format string `json:"format,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	Level  string `json:"level,omitempty"`
+	Margin *int   `json:"margin,omitempty"`
+}
+
+// qrRequestQuery ?format=&size=&level=&margin=
+func qrRequestQuery(id string, values url.Values) (QRRequest, error) {
+	req := QRRequest{ID: id, Format: values.Get("format"), Level: values.Get("level")}
+	if raw := values.Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil {
+			return QRRequest{}, errors.New("size must be an integer")
+		}
+		req.Size = size
+	}
+	if raw := values.Get("margin"); raw != "" {
+		margin, err := strconv.Atoi(raw)
+		if err != nil {
+			return QRRequest{}, errors.New("margin must be an integer")
+		}
+		req.Margin = &margin
+	}
+	return req, nil
+}
+
+// HandleQR - обработчик для GET /{id}/qr
+func HandleQR(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string) {
+	req, err := qrRequestQuery(chi.URLParam(r, "id"), r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	renderQR(w, r, shortener, baseURL, req)
+}
+
+// HandleQRJSON - обработчик для POST /api/qr
+func HandleQRJSON(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string) {
+	var req QRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	renderQR(w, r, shortener, baseURL, req)
+}
+
+// renderQR сначала проверяем параметры, потом ссылку: статусы для недоступной
+// ссылки те же, что у редиректа
+func renderQR(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string, req QRRequest) {
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, fmt.Sprintf("unknown format %q: want png or svg", req.Format), http.StatusBadRequest)
+		return
+	}
+	size := req.Size
+	if size == 0 {
+		size = defaultQRSize
+	}
+	if size < 0 || size > maxQRSize {
+		http.Error(w, fmt.Sprintf("size must be between 1 and %d", maxQRSize), http.StatusBadRequest)
+		return
+	}
+	level, err := qr.ParseLevel(req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	margin := qr.DefaultQuietZone
+	if req.Margin != nil {
+		margin = *req.Margin
+	}
+	if margin < 0 || margin > maxQRMargin {
+		http.Error(w, fmt.Sprintf("margin must be between 0 and %d", maxQRMargin), http.StatusBadRequest)
+		return
+	}
+
+	shortURL, err := shortener.ShortLink(r.Context(), req.ID, baseURL)
+	if err != nil {
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if writeLinkError(w, err) {
+			return
+		}
+		http.Error(w, "URL problem", http.StatusBadRequest)
+		return
+	}
+
+	code, err := qr.Encode(shortURL, level)
+	if err != nil {
+		// короткий адрес в версию 40 влезает всегда, сюда попадем только с кривым base url
+		log.Printf("ERROR: QR encode %s, err=%v", shortURL, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		body        []byte
+		contentType string
+	)
+	if format == "svg" {
+		body, err = code.SVG(size, margin)
+		contentType = "image/svg+xml"
+	} else {
+		body, err = code.PNG(size, margin)
+		contentType = "image/png"
+	}
+	if err != nil {
+		// картинка меньше символа с тихой зоной
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mkukarin01/snort/internal/bots"
+	"github.com/mkukarin01/snort/internal/middleware"
+	"github.com/mkukarin01/snort/internal/service"
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// defaultStatsSpan без from отдаем сутки по часам, месяц по дням, квартал по неделям
+// или год по месяцам
+var defaultStatsSpan = map[storage.BucketUnit]time.Duration{
+	storage.BucketHour:  24 * time.Hour,
+	storage.BucketDay:   30 * 24 * time.Hour,
+	storage.BucketWeek:  13 * 7 * 24 * time.Hour,
+	storage.BucketMonth: 365 * 24 * time.Hour,
+}
+
+// clientIP адрес клиента из RemoteAddr; за прокси его подменяет chi RealIP (-trust-proxy)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clickInfo что пишем о переходе и по чему узнаем ботов
+func clickInfo(r *http.Request) service.ClickInfo {
+	return service.ClickInfo{
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		Method:    r.Method,
+		Prefetch:  bots.IsPrefetch(r.Header),
+	}
+}
+
+// statsQuery ?bucket=hour|day|week|month&from=...&to=... (RFC 3339), по умолчанию до текущего момента
+func statsQuery(values url.Values, now time.Time) (storage.ClickQuery, error) {
+	bucket, err := storage.ParseBucketUnit(values.Get("bucket"))
+	if err != nil {
+		return storage.ClickQuery{}, err
+	}
+	q := storage.ClickQuery{Bucket: bucket, To: now}
+	if raw := values.Get("to"); raw != "" {
+		if q.To, err = time.Parse(time.RFC3339, raw); err != nil {
+			return storage.ClickQuery{}, errors.New("to must be an RFC 3339 timestamp")
+		}
+	}
+	q.From = q.To.Add(-defaultStatsSpan[bucket])
+	if raw := values.Get("from"); raw != "" {
+		if q.From, err = time.Parse(time.RFC3339, raw); err != nil {
+			return storage.ClickQuery{}, errors.New("from must be an RFC 3339 timestamp")
+		}
+	}
+	return q, nil
+}
+
+// HandleLinkStats - обработчик для GET /api/user/urls/{id}/stats, только для владельца ссылки
+func HandleLinkStats(w http.ResponseWriter, r *http.Request, shortener *service.URLShortener, baseURL string) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q, err := statsQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := shortener.LinkStats(r.Context(), userID, chi.URLParam(r, "id"), baseURL, q)
+	if err != nil {
+		if status, ok := storageErrorStatus(err); ok {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrInvalidStatsRange):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrURLNotFound):
+			// чужая ссылка для нас тоже не найдена
+			http.Error(w, "URL not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,230 @@
+// Package hll HyperLogLog: оценка числа уникальных значений в фиксированной памяти.
+// Скетчи складываются (Merge) без потерь, так что дневные собираются в недели и месяцы
+package hll
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// точность - log2 числа регистров
+const (
+	// DefaultPrecision 4096 регистров (4 КБ), стандартная ошибка ~1.6%
+	DefaultPrecision = 12
+	MinPrecision     = 4
+	MaxPrecision     = 16
+)
+
+// форматы MarshalBinary: плотный - все регистры подряд, разреженный - только ненулевые
+const (
+	formatDense  = 1
+	formatSparse = 2
+)
+
+// ErrPrecisionMismatch складывать можно только скетчи одной точности
+var ErrPrecisionMismatch = errors.New("hll: precision mismatch")
+
+// Sketch скетч HyperLogLog, не потокобезопасен - защищает владелец
+type Sketch struct {
+	p    uint8
+	regs []uint8
+}
+
+// New скетч точности DefaultPrecision
+func New() *Sketch {
+	s, _ := NewWithPrecision(DefaultPrecision)
+	return s
+}
+
+func NewWithPrecision(p uint8) (*Sketch, error) {
+	if p < MinPrecision || p > MaxPrecision {
+		return nil, fmt.Errorf("hll: precision must be between %d and %d", MinPrecision, MaxPrecision)
+	}
+	return &Sketch{p: p, regs: make([]uint8, 1<<p)}, nil
+}
+
+// Precision log2 числа регистров
+func (s *Sketch) Precision() uint8 {
+	return s.p
+}
+
+// Add учитываем значение, хэш считаем сами
+func (s *Sketch) Add(data []byte) {
+	s.AddHash(hash64(data))
+}
+
+// AddHash учитываем уже посчитанный равномерный 64-битный хэш
+func (s *Sketch) AddHash(x uint64) {
+	idx := x >> (64 - s.p)
+	// сторожевой бит, чтобы ранг не вылез за 64-p+1 на нулевом остатке
+	w := x<<s.p | 1<<(s.p-1)
+	rank := uint8(bits.LeadingZeros64(w) + 1)
+	if rank > s.regs[idx] {
+		s.regs[idx] = rank
+	}
+}
+
+// Merge добавляем в скетч все значения other
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.p != s.p {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.regs {
+		if r > s.regs[i] {
+			s.regs[i] = r
+		}
+	}
+	return nil
+}
+
+// Clone независимая копия
+func (s *Sketch) Clone() *Sketch {
+	return &Sketch{p: s.p, regs: append([]uint8(nil), s.regs...)}
+}
+
+// Count оценка числа уникальных значений; на малых числах - линейный счет
+// по пустым регистрам, он там точнее
+func (s *Sketch) Count() uint64 {
+	m := float64(len(s.regs))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range s.regs {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(s.regs)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// MarshalBinary формат, точность и регистры; у редких ссылок почти все регистры
+// пустые, тогда пишем только ненулевые парами (сдвиг индекса, значение)
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	var sparse []byte
+	prev := 0
+	for i, r := range s.regs {
+		if r == 0 {
+			continue
+		}
+		sparse = binary.AppendUvarint(sparse, uint64(i-prev))
+		sparse = append(sparse, r)
+		prev = i
+		if len(sparse) >= len(s.regs) {
+			// плотный уже не длиннее - дальше не считаем
+			sparse = nil
+			break
+		}
+	}
+	if sparse != nil || allZero(s.regs) {
+		return append([]byte{formatSparse, s.p}, sparse...), nil
+	}
+	return append([]byte{formatDense, s.p}, s.regs...), nil
+}
+
+func allZero(regs []uint8) bool {
+	for _, r := range regs {
+		if r != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("hll: sketch is too short")
+	}
+	p := data[1]
+	if p < MinPrecision || p > MaxPrecision {
+		return fmt.Errorf("hll: invalid precision %d", p)
+	}
+	regs := make([]uint8, 1<<p)
+	body := data[2:]
+	maxRank := uint8(64 - p + 1)
+
+	switch data[0] {
+	case formatDense:
+		if len(body) != len(regs) {
+			return fmt.Errorf("hll: dense sketch has %d registers, want %d", len(body), len(regs))
+		}
+		copy(regs, body)
+	case formatSparse:
+		idx := 0
+		for len(body) > 0 {
+			delta, n := binary.Uvarint(body)
+			if n <= 0 || len(body) < n+1 {
+				return errors.New("hll: truncated sparse sketch")
+			}
+			idx += int(delta)
+			if idx >= len(regs) {
+				return errors.New("hll: sparse register index out of range")
+			}
+			regs[idx] = body[n]
+			body = body[n+1:]
+		}
+	default:
+		return fmt.Errorf("hll: unknown sketch format %d", data[0])
+	}
+	for _, r := range regs {
+		if r > maxRank {
+			return fmt.Errorf("hll: register value %d is out of range", r)
+		}
+	}
+	s.p, s.regs = p, regs
+	return nil
+}
+
+// MarshalText base64 от MarshalBinary, чтобы скетч ложился в JSON строкой
+func (s *Sketch) MarshalText() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (s *Sketch) UnmarshalText(text []byte) error {
+	data, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("hll: %w", err)
+	}
+	return s.UnmarshalBinary(data)
+}
+
+// hash64 FNV-1a и финальное перемешивание из murmur3: сам FNV на коротких
+// похожих строках дает плохо распределенные старшие биты, а по ним мы выбираем регистр
+func hash64(data []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, b := range data {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,98 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketch_Count(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100_000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			s := New()
+			for i := 0; i < n; i++ {
+				s.Add([]byte(fmt.Sprintf("visitor-%d", i)))
+				// повторы не считаются
+				s.Add([]byte(fmt.Sprintf("visitor-%d", i)))
+			}
+			got := float64(s.Count())
+			// на таких числах ошибка заметно меньше 5%
+			assert.InDelta(t, float64(n), got, math.Max(1, 0.05*float64(n)))
+		})
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 6000; i++ {
+		a.Add([]byte(fmt.Sprint(i)))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.Add([]byte(fmt.Sprint(i)))
+	}
+	before := a.Count()
+
+	merged := a.Clone()
+	require.NoError(t, merged.Merge(b))
+	assert.InDelta(t, 10000, float64(merged.Count()), 500)
+	// Clone не делит регистры с оригиналом
+	assert.Equal(t, before, a.Count())
+
+	other, err := NewWithPrecision(10)
+	require.NoError(t, err)
+	assert.ErrorIs(t, a.Merge(other), ErrPrecisionMismatch)
+
+	_, err = NewWithPrecision(MaxPrecision + 1)
+	assert.Error(t, err)
+}
+
+func TestSketch_MarshalBinary(t *testing.T) {
+	for _, n := range []int{0, 3, 50_000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			s := New()
+			for i := 0; i < n; i++ {
+				s.Add([]byte(fmt.Sprint(i)))
+			}
+			data, err := s.MarshalBinary()
+			require.NoError(t, err)
+			// разреженный формат - пока он короче плотного
+			if n < 100 {
+				assert.Less(t, len(data), 100)
+			} else {
+				assert.Equal(t, 2+len(s.regs), len(data))
+			}
+
+			var got Sketch
+			require.NoError(t, got.UnmarshalBinary(data))
+			assert.Equal(t, s.regs, got.regs)
+			assert.Equal(t, s.Count(), got.Count())
+
+			text, err := s.MarshalText()
+			require.NoError(t, err)
+			var fromText Sketch
+			require.NoError(t, fromText.UnmarshalText(text))
+			assert.Equal(t, s.regs, fromText.regs)
+		})
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":           nil,
+		"bad precision":   {formatDense, 30},
+		"short dense":     {formatDense, 4, 1, 2},
+		"unknown format":  {9, 4},
+		"index overflow":  {formatSparse, 4, 16, 1},
+		"truncated pair":  {formatSparse, 4, 1},
+		"rank over limit": append([]byte{formatDense, 4}, make([]byte, 15)...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if name == "rank over limit" {
+				data = append(data, 62)
+			}
+			var s Sketch
+			assert.Error(t, s.UnmarshalBinary(data))
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Package idgen генераторы short_id: случайные, на счетчике, хэш url и из слов
+package idgen
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// стратегии генерации, значения для конфига
+const (
+	StrategyRandom  = "random"
+	StrategyCounter = "counter"
+	StrategyHash    = "hash"
+	StrategyWords   = "words"
+)
+
+// Base62 алфавит по умолчанию, как у старого generateID
+const Base62 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// значения по умолчанию
+const (
+	DefaultLength = 8
+	DefaultWords  = 3
+	// MaxLength больше не даем: sha512 хватает на ~86 знаков base62, а столбец в бд - 128
+	MaxLength = 64
+	// MaxWords слова до 8 букв + дефисы, в те же 64 знака
+	MaxWords = 7
+)
+
+// Generator выдает очередной short_id для ссылки. attempt - номер попытки с 0:
+// при коллизии сервис зовет еще раз с attempt+1, детерминированные генераторы
+// подмешивают его, чтобы не выдать тот же id
+type Generator interface {
+	Generate(ctx context.Context, originalURL string, attempt int) (string, error)
+}
+
+// Growable генератор умеет удлинять id на ходу, когда короткие кончаются
+type Growable interface {
+	Generator
+	// Grow +1 знак (для words +1 слово), false - уже максимум
+	Grow() bool
+	// Size текущая длина id (для words - число слов)
+	Size() int
+}
+
+// size длина id, которую наращиваем из разных горутин
+type size struct {
+	n   atomic.Int64
+	max int64
+}
+
+func (s *size) init(n, max int) {
+	s.max = int64(max)
+	s.n.Store(int64(n))
+}
+
+func (s *size) Size() int {
+	return int(s.n.Load())
+}
+
+func (s *size) Grow() bool {
+	for {
+		n := s.n.Load()
+		if n >= s.max {
+			return false
+		}
+		if s.n.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Sequence источник номеров для счетчика, номера не повторяются
+type Sequence func(ctx context.Context) (int64, error)
+
+// Config выбор стратегии, пустые поля - по умолчанию
+type Config struct {
+	Strategy string
+	// Length длина id для random и hash, минимальная (добиваем слева) для counter
+	Length int
+	// Alphabet символы id для random, counter и hash
+	Alphabet string
+	// Words сколько слов в id для words
+	Words int
+}
+
+// New генератор по конфигу, seq нужен только счетчику
+func New(cfg Config, seq Sequence) (Generator, error) {
+	if cfg.Length == 0 {
+		cfg.Length = DefaultLength
+	}
+	if cfg.Alphabet == "" {
+		cfg.Alphabet = Base62
+	}
+	if cfg.Words == 0 {
+		cfg.Words = DefaultWords
+	}
+
+	switch cfg.Strategy {
+	case "", StrategyRandom:
+		return NewRandom(cfg.Length, cfg.Alphabet)
+	case StrategyCounter:
+		if seq == nil {
+			return nil, errors.New("counter strategy needs a sequence")
+		}
+		return NewCounter(seq, cfg.Length, cfg.Alphabet)
+	case StrategyHash:
+		return NewHash(cfg.Length, cfg.Alphabet)
+	case StrategyWords:
+		return NewWords(cfg.Words)
+	}
+	return nil, fmt.Errorf("unknown id strategy %q: want random, counter, hash or words", cfg.Strategy)
+}
+
+// checkAlphabet символы без повторов и такие, что id ложится в путь как есть
+func checkAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("alphabet must have at least 2 characters")
+	}
+	seen := make(map[byte]bool, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if seen[c] {
+			return fmt.Errorf("alphabet has duplicate character %q", c)
+		}
+		seen[c] = true
+		if c >= 0x80 || c == '.' || url.PathEscape(string(c)) != string(c) {
+			return fmt.Errorf("alphabet character %q is not allowed in a path", c)
+		}
+	}
+	return nil
+}
+
+func checkLength(length int) error {
+	if length <= 0 || length > MaxLength {
+		return fmt.Errorf("id length must be between 1 and %d", MaxLength)
+	}
+	return nil
+}
+
+// Random криптостойкий случайный id, предсказать следующий нельзя
+type Random struct {
+	size
+	alphabet string
+}
+
+func NewRandom(length int, alphabet string) (*Random, error) {
+	if err := checkLength(length); err != nil {
+		return nil, err
+	}
+	if err := checkAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	g := &Random{alphabet: alphabet}
+	g.init(length, MaxLength)
+	return g, nil
+}
+
+func (g *Random) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return randomString(g.Size(), g.alphabet)
+}
+
+// randomString без перекоса: байты за последним полным кругом алфавита выкидываем
+func randomString(length int, alphabet string) (string, error) {
+	n := len(alphabet)
+	limit := 256 - 256%n
+	id := make([]byte, 0, length)
+	buf := make([]byte, length+length/2)
+	for len(id) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			id = append(id, alphabet[int(b)%n])
+			if len(id) == length {
+				break
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// Counter номер из последовательности хранилища в системе счисления алфавита:
+// самые короткие id, но они идут подряд и перебираются
+type Counter struct {
+	size
+	seq      Sequence
+	alphabet string
+}
+
+func NewCounter(seq Sequence, minLength int, alphabet string) (*Counter, error) {
+	if err := checkLength(minLength); err != nil {
+		return nil, err
+	}
+	if err := checkAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	g := &Counter{seq: seq, alphabet: alphabet}
+	g.init(minLength, MaxLength)
+	return g, nil
+}
+
+func (g *Counter) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	n, err := g.seq(ctx)
+	if err != nil {
+		return "", fmt.Errorf("next sequence value: %w", err)
+	}
+	id := encode(big.NewInt(n), g.alphabet, 0)
+	if pad := g.Size() - len(id); pad > 0 {
+		id = strings.Repeat(g.alphabet[:1], pad) + id
+	}
+	return id, nil
+}
+
+// Hash id из sha512 url: одна и та же ссылка без коллизий всегда получает один id,
+// на повторах к url дописываем номер попытки
+type Hash struct {
+	size
+	alphabet string
+}
+
+func NewHash(length int, alphabet string) (*Hash, error) {
+	if err := checkLength(length); err != nil {
+		return nil, err
+	}
+	if err := checkAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	g := &Hash{alphabet: alphabet}
+	g.init(length, MaxLength)
+	return g, nil
+}
+
+func (g *Hash) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	data := originalURL
+	if attempt > 0 {
+		data += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha512.Sum512([]byte(data))
+	return encode(new(big.Int).SetBytes(sum[:]), g.alphabet, g.Size()), nil
+}
+
+// encode число в системе счисления алфавита, младшие разряды справа;
+// digits > 0 - ровно столько младших разрядов
+func encode(n *big.Int, alphabet string, digits int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+	n = new(big.Int).Set(n)
+
+	var out []byte
+	for (digits == 0 && n.Sign() > 0) || len(out) < digits {
+		n.DivMod(n, base, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+	if len(out) == 0 {
+		out = append(out, alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// Words id из случайных слов через дефис ("brave-otter-lamp"), удобно диктовать
+type Words struct {
+	size
+}
+
+func NewWords(count int) (*Words, error) {
+	if count <= 0 || count > MaxWords {
+		return nil, fmt.Errorf("word count must be between 1 and %d", MaxWords)
+	}
+	g := &Words{}
+	g.init(count, MaxWords)
+	return g, nil
+}
+
+func (g *Words) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	words := make([]string, g.Size())
+	max := big.NewInt(int64(len(wordList)))
+	for i := range words {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		words[i] = wordList[n.Int64()]
+	}
+	return strings.Join(words, "-"), nil
+}
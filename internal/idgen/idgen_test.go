@@ -0,0 +1,143 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandom(t *testing.T) {
+	g, err := NewRandom(10, "abc")
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := g.Generate(context.Background(), "https://ya.ru", 0)
+		require.NoError(t, err)
+		assert.Regexp(t, `^[abc]{10}$`, id)
+		seen[id] = true
+	}
+	// 3^10 вариантов, на сотне повторов почти не бывает
+	assert.Greater(t, len(seen), 90)
+}
+
+func TestCounter(t *testing.T) {
+	var n int64 = 60
+	seq := func(context.Context) (int64, error) {
+		n++
+		return n, nil
+	}
+	g, err := NewCounter(seq, 3, Base62)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, want := range []string{"aa9", "aba", "abb"} {
+		id, err := g.Generate(ctx, "", 0)
+		require.NoError(t, err)
+		assert.Equal(t, want, id)
+	}
+
+	// длиннее минимальной длины не обрезаем
+	n = 62*62*62 - 1
+	id, err := g.Generate(ctx, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "baaa", id)
+
+	failing, err := NewCounter(func(context.Context) (int64, error) {
+		return 0, errors.New("db is down")
+	}, 3, Base62)
+	require.NoError(t, err)
+	_, err = failing.Generate(ctx, "", 0)
+	assert.ErrorContains(t, err, "db is down")
+}
+
+func TestHash(t *testing.T) {
+	g, err := NewHash(8, Base62)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	first, _ := g.Generate(ctx, "https://ya.ru", 0)
+	again, _ := g.Generate(ctx, "https://ya.ru", 0)
+	retry, _ := g.Generate(ctx, "https://ya.ru", 1)
+	other, _ := g.Generate(ctx, "https://yandex.ru", 0)
+
+	assert.Len(t, first, 8)
+	assert.Equal(t, first, again)
+	assert.NotEqual(t, first, retry)
+	assert.NotEqual(t, first, other)
+
+	long, err := NewHash(MaxLength, Base62)
+	require.NoError(t, err)
+	id, _ := long.Generate(ctx, "https://ya.ru", 0)
+	assert.Len(t, id, MaxLength)
+}
+
+func TestWords(t *testing.T) {
+	g, err := NewWords(3)
+	require.NoError(t, err)
+
+	id, err := g.Generate(context.Background(), "", 0)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z]+-[a-z]+-[a-z]+$`), id)
+	for _, word := range strings.Split(id, "-") {
+		assert.Contains(t, wordList, word)
+	}
+}
+
+func TestNew(t *testing.T) {
+	seq := func(context.Context) (int64, error) { return 1, nil }
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		seq     Sequence
+		want    any
+		wantErr bool
+	}{
+		{name: "default", cfg: Config{}, want: &Random{}},
+		{name: "counter", cfg: Config{Strategy: StrategyCounter}, seq: seq, want: &Counter{}},
+		{name: "hash", cfg: Config{Strategy: StrategyHash}, want: &Hash{}},
+		{name: "words", cfg: Config{Strategy: StrategyWords}, want: &Words{}},
+		{name: "counter without sequence", cfg: Config{Strategy: StrategyCounter}, wantErr: true},
+		{name: "unknown strategy", cfg: Config{Strategy: "uuid"}, wantErr: true},
+		{name: "too long", cfg: Config{Length: MaxLength + 1}, wantErr: true},
+		{name: "negative length", cfg: Config{Length: -1}, wantErr: true},
+		{name: "duplicate characters", cfg: Config{Alphabet: "abca"}, wantErr: true},
+		{name: "single character", cfg: Config{Alphabet: "a"}, wantErr: true},
+		{name: "slash in alphabet", cfg: Config{Alphabet: "ab/"}, wantErr: true},
+		{name: "dot in alphabet", cfg: Config{Alphabet: "ab."}, wantErr: true},
+		{name: "too many words", cfg: Config{Strategy: StrategyWords, Words: MaxWords + 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(tt.cfg, tt.seq)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.want, g)
+		})
+	}
+}
+
+func TestGrow(t *testing.T) {
+	g, err := NewHash(MaxLength-1, Base62)
+	require.NoError(t, err)
+
+	assert.True(t, g.Grow())
+	assert.Equal(t, MaxLength, g.Size())
+	id, _ := g.Generate(context.Background(), "https://ya.ru", 0)
+	assert.Len(t, id, MaxLength)
+	// дальше MaxLength не растем
+	assert.False(t, g.Grow())
+
+	var _ Growable = &Random{}
+	var _ Growable = &Counter{}
+	var _ Growable = &Words{}
+}
@@ -0,0 +1,31 @@
+package idgen
+
+// wordList слова для Words: короткие (до 7 букв), нейтральные, без повторов
+var wordList = [...]string{
+	"acorn", "actor", "agile", "amber", "anchor", "apple", "april", "arch", "arrow", "aspen", "atlas",
+	"attic", "autumn", "badge", "bagel", "baker", "bamboo", "banjo", "barley", "basil", "beach",
+	"beacon", "bean", "beaver", "berry", "birch", "bison", "blade", "bloom", "blue", "bold", "bonus",
+	"boot", "brave", "breeze", "brick", "bridge", "bright", "brook", "brush", "bubble", "bucket",
+	"cabin", "cactus", "camel", "candle", "canoe", "canyon", "carbon", "cargo", "carrot", "castle",
+	"cedar", "cello", "chalk", "cherry", "chess", "cider", "cinema", "circle", "citrus", "clay",
+	"cliff", "clover", "cobalt", "cocoa", "comet", "coral", "cotton", "cozy", "crane", "crater",
+	"crisp", "crown", "cubic", "curious", "daisy", "dawn", "delta", "denim", "desert", "dingo",
+	"dolphin", "domino", "dove", "dragon", "dream", "drift", "drum", "dune", "eagle", "early", "echo",
+	"eclipse", "ember", "emerald", "engine", "fable", "falcon", "fern", "ferry", "fiddle", "field",
+	"fig", "finch", "fjord", "flint", "flute", "forest", "fossil", "fox", "frost", "galaxy", "garden",
+	"garnet", "gecko", "gentle", "giant", "ginger", "glacier", "glow", "gold", "grape", "gravel",
+	"green", "grove", "gull", "harbor", "hazel", "heron", "hickory", "honey", "horizon", "humble",
+	"husky", "igloo", "indigo", "iris", "island", "ivory", "jade", "jaguar", "jasmine", "jelly",
+	"jolly", "juniper", "kayak", "kettle", "kiwi", "koala", "lagoon", "lantern", "lark", "lava",
+	"lemon", "lilac", "lily", "linen", "lively", "llama", "lotus", "lucky", "lunar", "magnet",
+	"mango", "maple", "marble", "meadow", "melody", "mint", "mocha", "moose", "mosaic", "motor",
+	"mural", "nectar", "nimble", "noble", "nutmeg", "oak", "oasis", "ocean", "olive", "onyx", "orbit",
+	"orchid", "otter", "owl", "paddle", "panda", "paper", "parrot", "pastel", "peach", "pebble",
+	"pepper", "piano", "pilot", "pine", "planet", "plum", "polar", "pony", "poppy", "prairie",
+	"prism", "puffin", "pumpkin", "quartz", "quiet", "quill", "rabbit", "radar", "rain", "raven",
+	"reef", "ribbon", "river", "robin", "rocket", "rose", "ruby", "saffron", "sage", "salmon",
+	"sandy", "satin", "scarlet", "shell", "silver", "sketch", "sky", "sleepy", "solar", "sonic",
+	"spark", "spruce", "squid", "star", "stone", "sunny", "swift", "tango", "teal", "thunder",
+	"tiger", "timber", "topaz", "tulip", "tundra", "turtle", "velvet", "violet", "walnut", "willow",
+	"winter",
+}
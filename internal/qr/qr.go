@@ -0,0 +1,545 @@
+// Package qr кодируем строку в QR код (ISO/IEC 18004) сами, без внешних сервисов:
+// только байтовый режим - для ссылок его хватает, версия подбирается по длине
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level уровень коррекции ошибок: чем выше, тем больше кода можно закрыть логотипом
+// или испачкать, но тем крупнее символ
+type Level int
+
+const (
+	Low      Level = iota // ~7% восстанавливается
+	Medium                // ~15%
+	Quartile              // ~25%
+	High                  // ~30%
+)
+
+// ParseLevel "L"/"M"/"Q"/"H" без учета регистра, пустая строка - M
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return Low, nil
+	case "", "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	}
+	return 0, fmt.Errorf("unknown error correction level %q: want L, M, Q or H", s)
+}
+
+// formatBits уровень в поле формата, порядок в стандарте не совпадает с L<M<Q<H
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// ErrTooLong строка не влезает даже в версию 40
+var ErrTooLong = errors.New("qr: data is too long")
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// eccPerBlock и eccBlocks по уровню и версии (индекс 0 не используется), таблицы 9 стандарта
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var eccBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code готовый символ: квадрат модулей, true - темный; тихая зона не входит
+type Code struct {
+	size    int
+	modules []bool
+	// function модули служебных узоров, маска их не трогает
+	function []bool
+}
+
+// Encode самая маленькая версия, в которую text влезает на уровне level
+func Encode(text string, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("qr: invalid level %d", level)
+	}
+	data := []byte(text)
+	version := 0
+	for v := minVersion; v <= maxVersion; v++ {
+		if dataBits(v, len(data)) <= dataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addECC(encodeData(data, version, level), version, level))
+
+	// маску выбираем по наименьшему штрафу, как требует стандарт
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(level, mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // xor - повторное наложение снимает маску
+	}
+	c.applyMask(best)
+	c.drawFormat(level, best)
+	return c, nil
+}
+
+// Size сторона символа в модулях, 17+4*версия
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Black темный ли модуль (x - столбец, y - строка); за пределами символа - светлый
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.size || y >= c.size {
+		return false
+	}
+	return c.modules[y*c.size+x]
+}
+
+func newCode(version int) *Code {
+	size := 17 + 4*version
+	return &Code{size: size, modules: make([]bool, size*size), function: make([]bool, size*size)}
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.size+x] = dark
+	c.function[y*c.size+x] = true
+}
+
+// rawDataModules сколько модулей версии остается под данные и коррекцию
+func rawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// dataCodewords байт данных без коррекции
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// countBits длина поля счетчика байт: до 9 версии 8 бит, дальше 16
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+func dataBits(version, n int) int {
+	if n >= 1<<countBits(version) {
+		return 1 << 30
+	}
+	return 4 + countBits(version) + 8*n
+}
+
+// bitBuffer биты по одному в байте - символ маленький, экономить нечего
+type bitBuffer []byte
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>i&1))
+	}
+}
+
+// encodeData режим, счетчик, байты, терминатор и заполнители 0xEC/0x11 до емкости версии
+func encodeData(data []byte, version int, level Level) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4) // байтовый режим
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := dataCodewords(version, level) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	out := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		out[i/8] |= bit << (7 - i%8)
+	}
+	return out
+}
+
+// addECC делим данные на блоки, к каждому считаем коррекцию Рида-Соломона и
+// перемежаем: сначала байты данных по столбцам, потом коррекция
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawDataModules(version) / 8
+	// короткие блоки идут первыми, длинные на байт данных больше
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw/numBlocks - eccLen
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	eccs := make([][]byte, numBlocks)
+	for i, off := 0, 0; i < numBlocks; i++ {
+		n := shortLen
+		if i >= numShort {
+			n++
+		}
+		blocks[i] = data[off : off+n]
+		eccs[i] = rsRemainder(blocks[i], divisor)
+		off += n
+	}
+
+	out := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, e := range eccs {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+// gfMul умножение в GF(256) по модулю x^8+x^4+x^3+x^2+1
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsDivisor порождающий многочлен степени degree без старшего коэффициента
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder остаток от деления данных на порождающий многочлен - это и есть коррекция
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMul(coef, factor)
+		}
+	}
+	return result
+}
+
+// alignmentPositions центры выравнивающих узоров по каждой оси
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 17+4*version-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// drawFunctionPatterns синхронизация, поисковые и выравнивающие узоры, версия;
+// поле формата только резервируем - его пишем после выбора маски
+func (c *Code) drawFunctionPatterns(version int) {
+	for i := 0; i < c.size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.size-4, 3)
+	c.drawFinder(3, c.size-4)
+
+	pos := alignmentPositions(version)
+	last := len(pos) - 1
+	for i, x := range pos {
+		for j, y := range pos {
+			// углы с поисковыми узорами пропускаем
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	c.drawFormat(Low, 0)
+	c.drawVersion(version)
+}
+
+// drawFinder поисковый узор 7x7 с белой рамкой-разделителем
+func (c *Code) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= c.size || y >= c.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.set(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// formatBits 5 бит уровня и маски, 10 бит BCH и xor с 0x5412, чтобы поле не было нулевым
+func formatBits(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat две копии поля формата и обязательный темный модуль
+func (c *Code) drawFormat(level Level, mask int) {
+	bits := formatBits(level, mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.size-15+i, bit(i))
+	}
+	c.set(8, c.size-8, true)
+}
+
+// versionBits 6 бит версии и 12 бит BCH, пишется с 7 версии
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	bits := versionBits(version)
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.size-11+i%3, i/3
+		c.set(a, b, dark)
+		c.set(b, a, dark)
+	}
+}
+
+// drawCodewords змейкой по парам столбцов снизу вверх и обратно, в обход служебных модулей
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// столбец синхронизации пропускаем целиком
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.size; vert++ {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*c.size+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*c.size+x] = data[i/8]>>(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask инвертируем модули данных по условию маски
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.function[y*c.size+x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			default:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.modules[y*c.size+x] = !c.modules[y*c.size+x]
+			}
+		}
+	}
+}
+
+// штрафы правил выбора маски
+const (
+	penaltyRun     = 3
+	penaltyBlock   = 3
+	penaltyFinder  = 40
+	penaltyBalance = 10
+)
+
+// finderLike узор 1:1:3:1:1 с четырьмя светлыми с одной стороны - его сканер
+// спутает с поисковым
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty штраф символа по четырем правилам стандарта: длинные серии, блоки 2x2,
+// похожие на поисковый узоры и перекос темных и светлых
+func (c *Code) penalty() int {
+	total := 0
+	line := make([]bool, c.size)
+	for _, column := range []bool{false, true} {
+		for i := 0; i < c.size; i++ {
+			for j := range line {
+				if column {
+					line[j] = c.Black(i, j)
+				} else {
+					line[j] = c.Black(j, i)
+				}
+			}
+			total += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			d := c.Black(x, y)
+			if d {
+				dark++
+			}
+			if x+1 < c.size && y+1 < c.size &&
+				d == c.Black(x+1, y) && d == c.Black(x, y+1) && d == c.Black(x+1, y+1) {
+				total += penaltyBlock
+			}
+		}
+	}
+
+	// каждые 5% отклонения от половины темных после первых 5%
+	all := c.size * c.size
+	k := (abs(dark*20-all*10)+all-1)/all - 1
+	return total + max(k, 0)*penaltyBalance
+}
+
+func linePenalty(line []bool) int {
+	total := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			total += penaltyRun + run - 5
+		}
+		run = 1
+	}
+	for i := 0; i+11 <= len(line); i++ {
+		for _, p := range finderLike {
+			if matches(line[i:i+11], p[:]) {
+				total += penaltyFinder
+			}
+		}
+	}
+	return total
+}
+
+func matches(line, pattern []bool) bool {
+	for i := range pattern {
+		if line[i] != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
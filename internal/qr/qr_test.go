@@ -0,0 +1,234 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"": Medium, "l": Low, "M": Medium, "q": Quartile, "H": High} {
+		got, err := ParseLevel(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	_, err := ParseLevel("X")
+	assert.Error(t, err)
+}
+
+// значения из приложений стандарта
+func TestTables(t *testing.T) {
+	assert.Equal(t, 0b111011111000100, formatBits(Low, 0))
+	assert.Equal(t, 0b101010000010010, formatBits(Medium, 0))
+	assert.Equal(t, 0b001011010001001, formatBits(High, 0))
+	assert.Equal(t, 0b000100000111011, formatBits(High, 7))
+	assert.Equal(t, 0b000111110010010100, versionBits(7))
+	assert.Equal(t, 0b101000110001101001, versionBits(40))
+
+	assert.Nil(t, alignmentPositions(1))
+	assert.Equal(t, []int{6, 18}, alignmentPositions(2))
+	assert.Equal(t, []int{6, 22, 38}, alignmentPositions(7))
+	assert.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPositions(32))
+	assert.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, alignmentPositions(40))
+
+	// емкость байтового режима: 1-M - 14 байт, 40-L - 2953, 40-H - 1273
+	capacity := func(version int, level Level) int {
+		return (dataCodewords(version, level)*8 - dataBits(version, 0)) / 8
+	}
+	assert.Equal(t, 17, capacity(1, Low))
+	assert.Equal(t, 14, capacity(1, Medium))
+	assert.Equal(t, 2953, capacity(40, Low))
+	assert.Equal(t, 1273, capacity(40, High))
+}
+
+// пример 1-M из стандарта: коррекция для "01234567" в числовом режиме
+func TestReedSolomon(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	assert.Equal(t, want, rsRemainder(data, rsDivisor(10)))
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range []struct {
+		text    string
+		level   Level
+		version int
+	}{
+		{"http://localhost:8080/abc", Medium, 2},
+		{"https://snort.example.com/EwHXdJfB", Low, 3},
+		{"https://snort.example.com/EwHXdJfB", High, 4},
+		{strings.Repeat("a", 300), Quartile, 16},
+		{strings.Repeat("z", 2953), Low, 40},
+	} {
+		code, err := Encode(tt.text, tt.level)
+		require.NoError(t, err)
+		assert.Equal(t, 17+4*tt.version, code.Size(), tt.text)
+
+		// читаем символ обратно: формат из обеих копий, данные змейкой без маски
+		level, mask := readFormat(t, code)
+		assert.Equal(t, tt.level, level)
+		assert.Equal(t, decode(t, code, tt.version, level, mask), tt.text)
+
+		// поисковые узоры на месте
+		for _, corner := range [][2]int{{0, 0}, {code.Size() - 7, 0}, {0, code.Size() - 7}} {
+			for i := 0; i < 7; i++ {
+				assert.True(t, code.Black(corner[0]+i, corner[1]))
+				assert.True(t, code.Black(corner[0], corner[1]+i))
+			}
+			assert.False(t, code.Black(corner[0]+1, corner[1]+1))
+			assert.True(t, code.Black(corner[0]+3, corner[1]+3))
+		}
+	}
+
+	_, err := Encode(strings.Repeat("z", 2954), Low)
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+func readFormat(t *testing.T, c *Code) (Level, int) {
+	t.Helper()
+	var first, second int
+	bit := func(dark bool) int {
+		if dark {
+			return 1
+		}
+		return 0
+	}
+	for i := 0; i <= 5; i++ {
+		first |= bit(c.Black(8, i)) << i
+	}
+	first |= bit(c.Black(8, 7))<<6 | bit(c.Black(8, 8))<<7 | bit(c.Black(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(c.Black(14-i, 8)) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(c.Black(c.size-1-i, 8)) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(c.Black(8, c.size-15+i)) << i
+	}
+	require.Equal(t, first, second, "format copies differ")
+	require.True(t, c.Black(8, c.size-8), "dark module")
+
+	for level := Low; level <= High; level++ {
+		for mask := 0; mask < 8; mask++ {
+			if formatBits(level, mask) == first {
+				return level, mask
+			}
+		}
+	}
+	t.Fatalf("unknown format bits %015b", first)
+	return 0, 0
+}
+
+// decode обратный путь: снимаем маску, собираем байты змейкой, разбираем блоки,
+// сверяем коррекцию и достаем строку из байтового режима
+func decode(t *testing.T, c *Code, version int, level Level, mask int) string {
+	t.Helper()
+	plain := newCode(version)
+	plain.drawFunctionPatterns(version)
+	copy(plain.modules, c.modules)
+	plain.applyMask(mask)
+
+	raw := make([]byte, rawDataModules(version)/8)
+	i := 0
+	for right := plain.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < plain.size; vert++ {
+			y := vert
+			if upward {
+				y = plain.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if plain.function[y*plain.size+x] || i >= len(raw)*8 {
+					continue
+				}
+				if plain.modules[y*plain.size+x] {
+					raw[i/8] |= 1 << (7 - i%8)
+				}
+				i++
+			}
+		}
+	}
+
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortLen := len(raw)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	pos := 0
+	for k := 0; k <= shortLen; k++ {
+		for b := range blocks {
+			if k < shortLen || b >= numShort {
+				blocks[b] = append(blocks[b], raw[pos])
+				pos++
+			}
+		}
+	}
+	var data []byte
+	for b := range blocks {
+		var ecc []byte
+		for k := 0; k < eccLen; k++ {
+			ecc = append(ecc, raw[pos+b+k*numBlocks])
+		}
+		require.Equal(t, rsRemainder(blocks[b], rsDivisor(eccLen)), ecc, "block %d ecc", b)
+		data = append(data, blocks[b]...)
+	}
+
+	var bits bitBuffer
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	read := func(n int) int {
+		v := 0
+		for _, b := range bits[:n] {
+			v = v<<1 | int(b)
+		}
+		bits = bits[n:]
+		return v
+	}
+	require.Equal(t, 0b0100, read(4), "byte mode")
+	n := read(countBits(version))
+	out := make([]byte, n)
+	for k := range out {
+		out[k] = byte(read(8))
+	}
+	return string(out)
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode("http://localhost:8080/abc", Medium)
+	require.NoError(t, err)
+
+	data, err := code.PNG(256, DefaultQuietZone)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+	assert.Equal(t, 256, img.Bounds().Dy())
+
+	// 25 модулей + 8 тихой зоны = 33, модуль 7 пикселей, поля (256-231)/2 = 12 + 28
+	r, _, _, _ := img.At(40, 40).RGBA()
+	assert.Zero(t, r, "top-left finder is dark")
+	r, _, _, _ = img.At(39, 39).RGBA()
+	assert.NotZero(t, r, "quiet zone is light")
+
+	_, err = code.PNG(32, DefaultQuietZone)
+	assert.Error(t, err, "smaller than the symbol")
+	_, err = code.PNG(256, -1)
+	assert.Error(t, err)
+
+	svg, err := code.SVG(300, 2)
+	require.NoError(t, err)
+	assert.Contains(t, string(svg), `width="300" height="300" viewBox="0 0 29 29"`)
+	assert.Contains(t, string(svg), "M2 2h7v1h-7z")
+	_, err = code.SVG(0, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+)
+
+// DefaultQuietZone тихая зона по стандарту - 4 модуля, меньше сканеры читают хуже
+const DefaultQuietZone = 4
+
+// Image картинка size x size пикселей, модуль - целое число пикселей, остаток
+// добавляем к полям поровну; size меньше символа с тихой зоной - ошибка
+func (c *Code) Image(size, quiet int) (image.Image, error) {
+	if quiet < 0 {
+		return nil, fmt.Errorf("qr: quiet zone cannot be negative")
+	}
+	total := c.size + 2*quiet
+	scale := size / total
+	if scale < 1 {
+		return nil, fmt.Errorf("qr: image size must be at least %d pixels", total)
+	}
+	offset := (size-scale*total)/2 + quiet*scale
+
+	// двухцветная палитра: PNG выходит в разы меньше, чем RGBA
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if !c.Black(x, y) {
+				continue
+			}
+			x0, y0 := offset+x*scale, offset+y*scale
+			for py := y0; py < y0+scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := x0; px < x0+scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// PNG картинка из Image в PNG
+func (c *Code) PNG(size, quiet int) ([]byte, error) {
+	img, err := c.Image(size, quiet)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG векторный вариант: одна координата - один модуль, size задает только
+// width/height; темные модули подряд в строке сливаем в один прямоугольник
+func (c *Code) SVG(size, quiet int) ([]byte, error) {
+	if quiet < 0 {
+		return nil, fmt.Errorf("qr: quiet zone cannot be negative")
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("qr: image size must be positive")
+	}
+	total := strconv.Itoa(c.size + 2*quiet)
+	px := strconv.Itoa(size)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="` + px + `" height="` + px +
+		`" viewBox="0 0 ` + total + ` ` + total + `" shape-rendering="crispEdges">` + "\n")
+	buf.WriteString(`<rect width="100%" height="100%" fill="#FFFFFF"/>` + "\n")
+	buf.WriteString(`<path fill="#000000" d="`)
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for c.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+quiet, y+quiet, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/>` + "\n</svg>\n")
+	return buf.Bytes(), nil
+}
@@ -1,12 +1,15 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	ChiMiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/mkukarin01/snort/internal/config"
 	"github.com/mkukarin01/snort/internal/handlers"
+	"github.com/mkukarin01/snort/internal/idgen"
 	"github.com/mkukarin01/snort/internal/logger"
 	InternalMiddleware "github.com/mkukarin01/snort/internal/middleware"
 	"github.com/mkukarin01/snort/internal/service"
@@ -14,8 +17,31 @@ import (
 )
 
 // NewRouter - создаем роутер chi
-func NewRouter(cfg *config.Config, db storage.Storager, deleter *service.URLDeleter) http.Handler {
-	shortener := service.NewURLShortener(db)
+// clicks пишет переходы для статистики, nil - не пишем
+func NewRouter(cfg *config.Config, db storage.Storager, deleter *service.URLDeleter, clicks *service.ClickRecorder) http.Handler {
+	aliases, err := service.NewAliasPolicy(cfg.AliasCharset, cfg.AliasMinLen, cfg.AliasMaxLen, splitList(cfg.AliasReserved))
+	if err != nil {
+		// конфиг уже прошел Validate, сюда попадаем только с собранным руками кривым конфигом
+		panic(err)
+	}
+	ids, err := idgen.New(idgen.Config{
+		Strategy: cfg.IDStrategy,
+		Length:   cfg.IDLength,
+		Alphabet: cfg.IDAlphabet,
+		Words:    cfg.IDWords,
+	}, func(ctx context.Context) (int64, error) {
+		// через замыкание: без бд (db == nil) роутер тоже собирается
+		return db.NextSequence(ctx)
+	})
+	if err != nil {
+		panic(err)
+	}
+	shortener := service.NewURLShortener(db,
+		service.WithPasswordThrottle(cfg.PasswordMaxAttempts, cfg.PasswordClientMaxAttempts, cfg.PasswordLockout),
+		service.WithAliasPolicy(aliases),
+		service.WithIDGenerator(ids),
+		service.WithCollisionPolicy(cfg.IDMaxAttempts, cfg.IDGrowThreshold),
+	)
 	r := chi.NewRouter()
 
 	// инициализуем собственный логгер синглтончик => мидлварь
@@ -27,6 +53,11 @@ func NewRouter(cfg *config.Config, db storage.Storager, deleter *service.URLDele
 
 	// есть какие-то встроенные мидлвари, позовем их
 	r.Use(ChiMiddleware.Recoverer)
+	// за прокси адрес клиента (для статистики переходов) берем из X-Forwarded-For/X-Real-IP,
+	// без прокси заголовкам не верим - их подставит кто угодно
+	if cfg.TrustProxy {
+		r.Use(ChiMiddleware.RealIP)
+	}
 
 	// публичные маршруты
 	// ping
@@ -34,18 +65,36 @@ func NewRouter(cfg *config.Config, db storage.Storager, deleter *service.URLDele
 		handlers.HandlePing(w, r, db)
 	})
 
-	// редирект
+	// счетчики коллизий short_id для мониторинга
+	r.Get("/api/stats/ids", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleIDStats(w, r, shortener)
+	})
+
+	// редирект, POST - форма пароля защищенной ссылки, HEAD - превьюшки (переход считаем ботом)
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleRedirect(w, r, shortener, clicks)
+	}
+	// QR код короткой ссылки, лежит рядом с ней
+	qrCode := func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleQR(w, r, shortener, cfg.BaseURL)
+	}
 	if cfg.BasePath == "" {
-		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-			handlers.HandleRedirect(w, r, shortener)
-		})
+		r.Get("/{id}", redirect)
+		r.Head("/{id}", redirect)
+		r.Post("/{id}", redirect)
+		r.Get("/{id}/qr", qrCode)
 	} else {
 		r.Route(cfg.BasePath, func(r chi.Router) {
-			r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-				handlers.HandleRedirect(w, r, shortener)
-			})
+			r.Get("/{id}", redirect)
+			r.Head("/{id}", redirect)
+			r.Post("/{id}", redirect)
+			r.Get("/{id}/qr", qrCode)
 		})
 	}
+	// то же с параметрами в JSON
+	r.Post("/api/qr", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleQRJSON(w, r, shortener, cfg.BaseURL)
+	})
 
 	// приватные маршруты
 	r.Group(func(private chi.Router) {
@@ -72,7 +121,28 @@ func NewRouter(cfg *config.Config, db storage.Storager, deleter *service.URLDele
 		private.Delete("/api/user/urls", func(w http.ResponseWriter, r *http.Request) {
 			handlers.HandleDeleteUserURLs(w, r, deleter)
 		})
+
+		// статистика переходов по ссылке
+		private.Get("/api/user/urls/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+			handlers.HandleLinkStats(w, r, shortener, cfg.BaseURL)
+		})
+
+		// восстанавливаем недавно удаленные
+		private.Post("/api/user/urls/restore", func(w http.ResponseWriter, r *http.Request) {
+			handlers.HandleRestoreUserURLs(w, r, shortener, cfg.RestoreGrace)
+		})
 	})
 
 	return r
 }
+
+// splitList "a, b,,c" -> [a b c]
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -39,13 +39,13 @@ func TestRouter_Routes(t *testing.T) {
 	// так-то теперь можно не мокать, а напрямую дергать memoryStorage и не париться
 	// мокаем стораджер
 	mockDB := storage.NewMockStorager(ctrl)
-	mockDB.EXPECT().Ping().Return(nil)
-	mockDB.EXPECT().Load("anyShortID").Return("http://ya.ru", nil)
-	mockDB.EXPECT().GetUserURLs(gomock.Any()).Return([]storage.UserURL{}, nil)
+	mockDB.EXPECT().Ping(gomock.Any()).Return(nil)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "anyShortID").Return(storage.Link{ShortURL: "anyShortID", OriginalURL: "http://ya.ru"}, nil).Times(2)
+	mockDB.EXPECT().GetUserURLs(gomock.Any(), gomock.Any()).Return([]storage.UserURL{}, nil)
 	// fanin
 	deleter := service.NewURLDeleter(mockDB)
 
-	r := NewRouter(cfg, mockDB, deleter)
+	r := NewRouter(cfg, mockDB, deleter, nil)
 
 	testCases := []struct {
 		method string
@@ -56,6 +56,7 @@ func TestRouter_Routes(t *testing.T) {
 		{"POST", "/api/shorten/batch"},
 		{"GET", "/api/user/urls"},
 		{"GET", "/anyShortID"},
+		{"GET", "/anyShortID/qr"},
 		{"GET", "/ping"},
 	}
 
@@ -78,7 +79,7 @@ func TestRouter_Ping_Success(t *testing.T) {
 
 	// мокаем стораджер
 	mockDB := storage.NewMockStorager(ctrl)
-	mockDB.EXPECT().Ping().Return(nil)
+	mockDB.EXPECT().Ping(gomock.Any()).Return(nil)
 
 	cfg := &config.Config{
 		Address: "localhost:8080",
@@ -87,7 +88,7 @@ func TestRouter_Ping_Success(t *testing.T) {
 	// fanin
 	deleter := service.NewURLDeleter(mockDB)
 
-	router := NewRouter(cfg, mockDB, deleter)
+	router := NewRouter(cfg, mockDB, deleter, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
 	rec := httptest.NewRecorder()
@@ -105,7 +106,7 @@ func TestRouter_Ping_Failed(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockDB := storage.NewMockStorager(ctrl)
-	mockDB.EXPECT().Ping().Return(assert.AnError)
+	mockDB.EXPECT().Ping(gomock.Any()).Return(assert.AnError)
 
 	cfg := &config.Config{
 		Address: "localhost:8080",
@@ -114,7 +115,7 @@ func TestRouter_Ping_Failed(t *testing.T) {
 	// fanin
 	deleter := service.NewURLDeleter(mockDB)
 
-	router := NewRouter(cfg, mockDB, deleter)
+	router := NewRouter(cfg, mockDB, deleter, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
 	rec := httptest.NewRecorder()
@@ -135,7 +136,7 @@ func TestRouter_Ping_NoDB(t *testing.T) {
 	// fanin
 	deleter := service.NewURLDeleter(nil)
 
-	router := NewRouter(cfg, nil, deleter)
+	router := NewRouter(cfg, nil, deleter, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
 	rec := httptest.NewRecorder()
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ошибки пользовательских алиасов
+var (
+	// ErrInvalidAlias - алиас не проходит по символам/длине или зарезервирован
+	ErrInvalidAlias = errors.New("invalid alias")
+	// ErrAliasTaken - такой short_id уже занят другой ссылкой
+	ErrAliasTaken = errors.New("alias is already taken")
+)
+
+// настройки алиасов по умолчанию
+const (
+	// DefaultAliasCharset содержимое класса символов регулярки, как внутри [...]
+	DefaultAliasCharset = `A-Za-z0-9_-`
+	DefaultAliasMinLen  = 3
+	DefaultAliasMaxLen  = 64
+)
+
+// builtinReservedAliases первые сегменты путей, которые роутер занимает сам
+var builtinReservedAliases = []string{"api", "ping"}
+
+// AliasPolicy что можно использовать как алиас
+type AliasPolicy struct {
+	charset  *regexp.Regexp
+	minLen   int
+	maxLen   int
+	reserved map[string]struct{} // в нижнем регистре
+}
+
+// NewAliasPolicy charset - класс символов регулярки ("A-Za-z0-9_-"), пустые
+// значения - по умолчанию; к reserved всегда добавляются пути самого сервиса
+func NewAliasPolicy(charset string, minLen, maxLen int, reserved []string) (*AliasPolicy, error) {
+	if charset == "" {
+		charset = DefaultAliasCharset
+	}
+	if minLen <= 0 {
+		minLen = DefaultAliasMinLen
+	}
+	if maxLen <= 0 {
+		maxLen = DefaultAliasMaxLen
+	}
+	if minLen > maxLen {
+		return nil, fmt.Errorf("alias min length %d is greater than max length %d", minLen, maxLen)
+	}
+	re, err := regexp.Compile(`^[` + charset + `]+$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alias charset %q: %w", charset, err)
+	}
+
+	p := &AliasPolicy{
+		charset:  re,
+		minLen:   minLen,
+		maxLen:   maxLen,
+		reserved: make(map[string]struct{}, len(builtinReservedAliases)+len(reserved)),
+	}
+	for _, words := range [][]string{builtinReservedAliases, reserved} {
+		for _, word := range words {
+			p.reserved[strings.ToLower(word)] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+// Reserved занято ли слово путями сервиса или конфигом, для сгенерированных id
+func (p *AliasPolicy) Reserved(id string) bool {
+	_, ok := p.reserved[strings.ToLower(id)]
+	return ok
+}
+
+// Validate ErrInvalidAlias с причиной
+func (p *AliasPolicy) Validate(alias string) error {
+	if n := len(alias); n < p.minLen || n > p.maxLen {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, p.minLen, p.maxLen)
+	}
+	// что бы ни разрешили в charset, алиас должен ложиться в один сегмент пути как есть
+	if !p.charset.MatchString(alias) || url.PathEscape(alias) != alias || alias == "." || alias == ".." {
+		return fmt.Errorf("%w: %q contains characters that are not allowed", ErrInvalidAlias, alias)
+	}
+	if p.Reserved(alias) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
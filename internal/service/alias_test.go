@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mkukarin01/snort/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAliasPolicy_Validate - символы, длина и зарезервированные слова
+func TestAliasPolicy_Validate(t *testing.T) {
+	p, err := NewAliasPolicy("", 3, 16, []string{"Admin"})
+	require.NoError(t, err)
+
+	for _, alias := range []string{"spring-sale", "Q3_report", "abc"} {
+		assert.NoError(t, p.Validate(alias), alias)
+	}
+	for _, alias := range []string{"ab", "this-alias-is-too-long", "spring sale", "a/b", "sale?", "api", "PING", "admin", "привет"} {
+		assert.ErrorIs(t, p.Validate(alias), ErrInvalidAlias, alias)
+	}
+
+	// charset шире пути - все равно не пропускаем то, что не ляжет в сегмент
+	p, err = NewAliasPolicy(`a-z./%`, 1, 10, nil)
+	require.NoError(t, err)
+	assert.NoError(t, p.Validate("v.a"))
+	for _, alias := range []string{"..", "a/b", "a%b"} {
+		assert.ErrorIs(t, p.Validate(alias), ErrInvalidAlias, alias)
+	}
+
+	_, err = NewAliasPolicy("z-a", 0, 0, nil)
+	assert.Error(t, err)
+	_, err = NewAliasPolicy("", 10, 5, nil)
+	assert.Error(t, err)
+}
+
+// TestURLShortener_Alias - алиас вместо генерации, занятый - ErrAliasTaken без повтора
+func TestURLShortener_Alias(t *testing.T) {
+	ctx := context.Background()
+	shortener := NewURLShortener(storage.NewMemoryStorage())
+
+	id, err := shortener.ShortenLink(ctx, "https://shop.ru/sale", "u1", LinkOptions{Alias: "spring-sale"})
+	require.NoError(t, err)
+	assert.Equal(t, "spring-sale", id)
+
+	url, err := shortener.Retrieve(ctx, "spring-sale")
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.ru/sale", url)
+
+	_, err = shortener.ShortenLink(ctx, "https://shop.ru/other", "u2", LinkOptions{Alias: "spring-sale"})
+	assert.ErrorIs(t, err, ErrAliasTaken)
+
+	_, err = shortener.ShortenLink(ctx, "https://shop.ru/other", "u2", LinkOptions{Alias: "api"})
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+}
+
+// TestURLShortener_BatchAlias - алиасы в батче: дубли и занятые не проходят
+func TestURLShortener_BatchAlias(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	shortener := NewURLShortener(store)
+	require.NoError(t, store.SaveUserURL(ctx, "u0", "taken", "https://shop.ru/old"))
+
+	result, err := shortener.ShortenBatchLinks(ctx, map[string]BatchURL{
+		"1": {OriginalURL: "https://shop.ru/1", LinkOptions: LinkOptions{Alias: "first"}},
+		"2": {OriginalURL: "https://shop.ru/2"},
+	}, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, "first", result["1"])
+	assert.Len(t, result["2"], 8)
+
+	_, err = shortener.ShortenBatchLinks(ctx, map[string]BatchURL{
+		"1": {OriginalURL: "https://shop.ru/3", LinkOptions: LinkOptions{Alias: "dup"}},
+		"2": {OriginalURL: "https://shop.ru/4", LinkOptions: LinkOptions{Alias: "dup"}},
+	}, "u1")
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+
+	_, err = shortener.ShortenBatchLinks(ctx, map[string]BatchURL{
+		"1": {OriginalURL: "https://shop.ru/5", LinkOptions: LinkOptions{Alias: "taken"}},
+	}, "u1")
+	assert.ErrorIs(t, err, ErrAliasTaken)
+
+	// чужую ссылку батч не перезаписал
+	url, err := shortener.Retrieve(ctx, "taken")
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.ru/old", url)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/bots"
+	"github.com/mkukarin01/snort/internal/geoip"
+	"github.com/mkukarin01/snort/internal/hll"
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// ClickInfo что знаем о переходе из запроса, IP в хранилище не попадает;
+// Method и Prefetch нужны только чтобы узнать ботов
+type ClickInfo struct {
+	Referrer  string
+	UserAgent string
+	IP        string
+	Method    string
+	Prefetch  bool
+}
+
+// ClickRecorder - фанин переходов по образцу URLDeleter: редирект кидает событие
+// в канал и не ждет, воркер копит пачку и пишет ее раз в секунду или по заполнению
+type ClickRecorder struct {
+	store   storage.Storager
+	inChan  chan storage.Click
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	bufSize int
+	ipKey   []byte
+	bots    *bots.Classifier
+	geo     *geoip.Resolver
+	dropped atomic.Int64
+	now     func() time.Time
+}
+
+// NewClickRecorder secret - ключ для HMAC от IP: без него хэш IPv4 перебирается за минуты;
+// classifier помечает переходы ботов, nil - все считаем людьми; geo определяет страну
+// и регион по IP, nil - без гео
+func NewClickRecorder(store storage.Storager, secret string, classifier *bots.Classifier, geo *geoip.Resolver) *ClickRecorder {
+	// ключ свой, производный: секрет подписи кук напрямую в другое место не несем
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("snort click ip"))
+	return &ClickRecorder{
+		store:   store,
+		inChan:  make(chan storage.Click, 1024), // буфер
+		stopCh:  make(chan struct{}),
+		bufSize: 100, // сброс/флеш
+		ipKey:   mac.Sum(nil),
+		bots:    classifier,
+		geo:     geo,
+		now:     time.Now,
+	}
+}
+
+// Start запускаем воркер; в отличие от URLDeleter.Run wg.Add делаем до горутины,
+// иначе Stop сразу после старта не дождется финального сброса
+func (cr *ClickRecorder) Start() {
+	cr.wg.Add(1)
+	go cr.run()
+}
+
+// run цикл на чтение и агрегация переходов, как у URLDeleter
+func (cr *ClickRecorder) run() {
+	defer cr.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var batch []storage.Click
+	flushFunc := func() {
+		if len(batch) == 0 {
+			return
+		}
+		cr.flush(batch)
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-cr.stopCh:
+			// финалочка: дочитываем то, что успели накидать до Stop
+			for {
+				select {
+				case click := <-cr.inChan:
+					batch = append(batch, click)
+				default:
+					flushFunc()
+					return
+				}
+			}
+		case click := <-cr.inChan:
+			batch = append(batch, click)
+			if len(batch) >= cr.bufSize {
+				flushFunc()
+			}
+		case <-ticker.C:
+			flushFunc()
+		}
+	}
+}
+
+// Record не блокирует: очередь забита - переход теряем, редирект важнее статистики;
+// nil-рекордер (статистика не нужна) ничего не делает
+func (cr *ClickRecorder) Record(shortID string, info ClickInfo) {
+	if cr == nil {
+		return
+	}
+	click := storage.Click{
+		ShortURL:  shortID,
+		At:        cr.now().UTC(),
+		Referrer:  clickField(info.Referrer),
+		UserAgent: clickField(info.UserAgent),
+		IPHash:    cr.hashIP(info.IP),
+	}
+	// страну определяем, пока IP еще на руках: дальше остается только хэш
+	loc := cr.geo.Locate(info.IP)
+	click.Country, click.Region = loc.Country, loc.Region
+	// классифицируем здесь, а не в воркере: повторы считаются по порядку прихода
+	click.Bot = string(cr.bots.Classify(bots.Hit{
+		Link:      shortID,
+		Method:    info.Method,
+		UserAgent: info.UserAgent,
+		Prefetch:  info.Prefetch,
+		Client:    click.IPHash,
+	}))
+	select {
+	case cr.inChan <- click:
+	default:
+		if n := cr.dropped.Add(1); n&(n-1) == 0 {
+			// логируем на степенях двойки, чтобы под нагрузкой не заспамить лог
+			log.Printf("WARN: click queue is full, dropped %d clicks so far", n)
+		}
+	}
+}
+
+// maxClickFieldLen заголовки длиннее режем, статистике хватит начала
+const maxClickFieldLen = 512
+
+// clickField обрезаем и выкидываем битый UTF-8: заголовки присылает кто угодно,
+// а одна кривая строка в бд уронила бы всю пачку
+func clickField(s string) string {
+	if len(s) > maxClickFieldLen {
+		s = s[:maxClickFieldLen]
+	}
+	return strings.ToValidUTF8(s, "")
+}
+
+// Dropped сколько переходов потеряли на переполненной очереди
+func (cr *ClickRecorder) Dropped() int64 {
+	return cr.dropped.Load()
+}
+
+// Stop - остановить, накопленное сбрасываем; звать после Start
+func (cr *ClickRecorder) Stop() {
+	close(cr.stopCh)
+	cr.wg.Wait()
+}
+
+// hashIP HMAC-SHA256, первых 16 байт хватает, чтобы различать клиентов
+func (cr *ClickRecorder) hashIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, cr.ipKey)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// flush контекст свой, дедлайн навешивает хранилище (storage.WithTimeouts)
+func (cr *ClickRecorder) flush(batch []storage.Click) {
+	if err := cr.store.SaveClicks(context.Background(), batch); err != nil {
+		log.Printf("ERROR: SaveClicks count=%d, err=%v", len(batch), err)
+	}
+}
+
+// ErrInvalidStatsRange кривой отрезок статистики
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// MaxStatsBuckets больше отрезков за запрос не считаем, ~41 день по часам
+const MaxStatsBuckets = 1000
+
+// LinkStats статистика переходов по ссылке
+type LinkStats struct {
+	ShortURL string `json:"short_url"`
+	// TotalClicks переходы людей за все время, BotClicks - ботов; в отрезки и
+	// уникальных боты не попадают
+	TotalClicks int64 `json:"total_clicks"`
+	BotClicks   int64 `json:"bot_clicks"`
+	// UniqueVisitors оценка уникальных посетителей за [From, To); скетчи суточные,
+	// так что при разбивке по часам крайние сутки учитываются целиком
+	UniqueVisitors int64         `json:"unique_visitors"`
+	Bucket         string        `json:"bucket"`
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	Clicks         []StatsBucket `json:"clicks"`
+	// топы людей за те же сутки, что и уникальные: хосты рефереров и семейства браузеров
+	TopReferrers  []TopEntry `json:"top_referrers"`
+	TopUserAgents []TopEntry `json:"top_user_agents"`
+	// Countries переходы людей за те же сутки по всем странам, не только топ;
+	// без GeoIP базы все попадают в storage.UnknownCountry
+	Countries []TopEntry `json:"countries"`
+}
+
+// statsTop длина топов в статистике
+const statsTop = 10
+
+// TopEntry строка топа
+type TopEntry struct {
+	Name   string `json:"name"`
+	Clicks int64  `json:"clicks"`
+}
+
+// topEntries самые частые сверху, при равенстве - по имени; limit <= 0 - все
+func topEntries(counts map[string]int64, limit int) []TopEntry {
+	top := make([]TopEntry, 0, len(counts))
+	for name, n := range counts {
+		top = append(top, TopEntry{Name: name, Clicks: n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Clicks != top[j].Clicks {
+			return top[i].Clicks > top[j].Clicks
+		}
+		return top[i].Name < top[j].Name
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// StatsBucket переходы за отрезок с начала Start длиной в Bucket; уникальных по
+// часам не считаем - там UniqueVisitors нет
+type StatsBucket struct {
+	Start          time.Time `json:"start"`
+	Clicks         int64     `json:"clicks"`
+	UniqueVisitors *int64    `json:"unique_visitors,omitempty"`
+}
+
+// LinkStats переходы по ссылке владельца: всего и по отрезкам [from, to), границы
+// выравниваем по отрезкам, пустые отрезки отдаем с нулем, чтобы ряд был сплошной
+func (us *URLShortener) LinkStats(ctx context.Context, userID, id, baseURL string, q storage.ClickQuery) (LinkStats, error) {
+	q.From = q.Bucket.Truncate(q.From)
+	if to := q.Bucket.Truncate(q.To); to.Before(q.To) {
+		q.To = q.Bucket.Next(to)
+	}
+	if !q.From.Before(q.To) {
+		return LinkStats{}, fmt.Errorf("%w: from must be before to", ErrInvalidStatsRange)
+	}
+	// отрезки бывают разной длины (месяцы), так что считаем шагами
+	var starts []time.Time
+	for start := q.From; start.Before(q.To); start = q.Bucket.Next(start) {
+		if len(starts) == MaxStatsBuckets {
+			return LinkStats{}, fmt.Errorf("%w: at most %d %s buckets per request", ErrInvalidStatsRange, MaxStatsBuckets, q.Bucket)
+		}
+		starts = append(starts, start)
+	}
+
+	stats, err := us.store.ClickStats(ctx, userID, id, q)
+	if err != nil {
+		return LinkStats{}, err
+	}
+
+	// ключ - секунды: у time.Time из разных бэкендов может отличаться Location
+	counts := make(map[int64]int64, len(stats.Buckets))
+	for _, b := range stats.Buckets {
+		counts[b.Start.Unix()] = b.Clicks
+	}
+
+	// суточные скетчи складываем в отрезки и в итог за весь запрос
+	total := hll.New()
+	visitors := make(map[int64]*hll.Sketch)
+	for _, v := range stats.Visitors {
+		mergeVisitors(total, v.Visitors)
+		if q.Bucket == storage.BucketHour {
+			continue
+		}
+		key := q.Bucket.Truncate(v.Day).Unix()
+		if visitors[key] == nil {
+			visitors[key] = hll.New()
+		}
+		mergeVisitors(visitors[key], v.Visitors)
+	}
+
+	buckets := make([]StatsBucket, 0, len(starts))
+	for _, start := range starts {
+		b := StatsBucket{Start: start, Clicks: counts[start.Unix()]}
+		if q.Bucket != storage.BucketHour {
+			var unique int64
+			if sketch := visitors[start.Unix()]; sketch != nil {
+				unique = int64(sketch.Count())
+			}
+			b.UniqueVisitors = &unique
+		}
+		buckets = append(buckets, b)
+	}
+
+	return LinkStats{
+		ShortURL:       baseURL + "/" + id,
+		TotalClicks:    stats.Total,
+		BotClicks:      stats.BotTotal,
+		UniqueVisitors: int64(total.Count()),
+		Bucket:         string(q.Bucket),
+		From:           q.From,
+		To:             q.To,
+		Clicks:         buckets,
+		TopReferrers:   topEntries(stats.Referrers, statsTop),
+		TopUserAgents:  topEntries(stats.UserAgents, statsTop),
+		Countries:      topEntries(stats.Countries, 0),
+	}, nil
+}
+
+// mergeVisitors скетч другой точности (поменяли DefaultPrecision, а в бд старые)
+// не складывается - пропускаем его, а не роняем всю статистику
+func mergeVisitors(dst, src *hll.Sketch) {
+	if err := dst.Merge(src); err != nil {
+		log.Printf("WARN: skip visitor sketch: %v", err)
+	}
+}
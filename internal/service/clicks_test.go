@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/bots"
+	"github.com/mkukarin01/snort/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// переходы из Record доезжают до хранилища на Stop, IP в открытом виде не хранится
+func TestClickRecorder(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "https://ya.ru"))
+
+	cr := NewClickRecorder(store, "secret", nil, nil)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cr.now = func() time.Time { return at }
+	cr.Start()
+
+	cr.Record("id1", ClickInfo{Referrer: "https://t.me", UserAgent: "curl/8.0", IP: "10.0.0.1"})
+	cr.Record("id1", ClickInfo{UserAgent: strings.Repeat("a", 1000) + "\xff", IP: "10.0.0.1"})
+	cr.Record("id1", ClickInfo{IP: "10.0.0.2"})
+	cr.Stop()
+
+	stats, err := store.ClickStats(ctx, "u1", "id1", storage.ClickQuery{From: at, To: at.Add(time.Hour), Bucket: storage.BucketHour})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, stats.Total)
+
+	// хэш стабилен для одного IP, различается для разных и зависит от секрета
+	h1, h2 := cr.hashIP("10.0.0.1"), cr.hashIP("10.0.0.2")
+	assert.Len(t, h1, 32)
+	assert.NotContains(t, h1, "10.0.0.1")
+	assert.Equal(t, h1, cr.hashIP("10.0.0.1"))
+	assert.NotEqual(t, h1, h2)
+	assert.NotEqual(t, h1, NewClickRecorder(store, "other", nil, nil).hashIP("10.0.0.1"))
+	assert.Empty(t, cr.hashIP(""))
+
+	assert.Len(t, clickField(strings.Repeat("a", 1000)), maxClickFieldLen)
+	assert.Equal(t, "ok", clickField("o\xffk"))
+
+	// с классификатором боты помечаются, но тоже доезжают
+	classifier, err := bots.New(bots.Config{})
+	require.NoError(t, err)
+	cr = NewClickRecorder(store, "secret", classifier, nil)
+	cr.now = func() time.Time { return at }
+	cr.Start()
+	cr.Record("id1", ClickInfo{UserAgent: "Mozilla/5.0", IP: "10.0.0.3"})
+	cr.Record("id1", ClickInfo{UserAgent: "Mozilla/5.0", IP: "10.0.0.3", Method: http.MethodHead})
+	cr.Record("id1", ClickInfo{UserAgent: "Mozilla/5.0", IP: "10.0.0.3", Prefetch: true})
+	cr.Stop()
+	stats, err = store.ClickStats(ctx, "u1", "id1", storage.ClickQuery{From: at, To: at.Add(time.Hour), Bucket: storage.BucketHour})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, stats.Total)
+	assert.EqualValues(t, 2, stats.BotTotal)
+
+	// без рекордера редирект просто не пишет статистику
+	var none *ClickRecorder
+	assert.NotPanics(t, func() { none.Record("id1", ClickInfo{}) })
+}
+
+// очередь забита - не ждем, а теряем переход
+func TestClickRecorder_Drop(t *testing.T) {
+	cr := NewClickRecorder(storage.NewMemoryStorage(), "secret", nil, nil)
+	for i := 0; i < cap(cr.inChan)+5; i++ {
+		cr.Record("id1", ClickInfo{})
+	}
+	assert.EqualValues(t, 5, cr.Dropped())
+}
+
+func TestURLShortener_LinkStats(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "https://ya.ru"))
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{ShortURL: "id1", At: day.Add(2 * time.Hour), IPHash: "aa", Country: "RU", Region: "MOW"},
+		{ShortURL: "id1", At: day.Add(50 * time.Hour), IPHash: "aa"},
+	}))
+	shortener := NewURLShortener(store)
+
+	// границы расширяем до целых суток, пустые сутки - с нулем
+	stats, err := shortener.LinkStats(ctx, "u1", "id1", "http://localhost:8080", storage.ClickQuery{
+		From: day.Add(time.Hour), To: day.Add(49 * time.Hour), Bucket: storage.BucketDay,
+	})
+	require.NoError(t, err)
+	one, zero := int64(1), int64(0)
+	assert.Equal(t, LinkStats{
+		ShortURL:       "http://localhost:8080/id1",
+		TotalClicks:    2,
+		UniqueVisitors: 1, // один и тот же посетитель в разные сутки
+		Bucket:         "day",
+		From:           day,
+		To:             day.Add(72 * time.Hour),
+		Clicks: []StatsBucket{
+			{Start: day, Clicks: 1, UniqueVisitors: &one},
+			{Start: day.Add(24 * time.Hour), Clicks: 0, UniqueVisitors: &zero},
+			{Start: day.Add(48 * time.Hour), Clicks: 1, UniqueVisitors: &one},
+		},
+		TopReferrers:  []TopEntry{{Name: storage.DirectReferrer, Clicks: 2}},
+		TopUserAgents: []TopEntry{{Name: "other", Clicks: 2}},
+		Countries:     []TopEntry{{Name: storage.UnknownCountry, Clicks: 1}, {Name: "RU", Clicks: 1}},
+	}, stats)
+
+	_, err = shortener.LinkStats(ctx, "u1", "id1", "", storage.ClickQuery{From: day, To: day, Bucket: storage.BucketDay})
+	assert.ErrorIs(t, err, ErrInvalidStatsRange)
+	_, err = shortener.LinkStats(ctx, "u1", "id1", "", storage.ClickQuery{From: day, To: day.AddDate(1, 0, 0), Bucket: storage.BucketHour})
+	assert.ErrorIs(t, err, ErrInvalidStatsRange)
+	_, err = shortener.LinkStats(ctx, "u2", "id1", "", storage.ClickQuery{From: day, To: day.Add(time.Hour), Bucket: storage.BucketDay})
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+// TestURLShortener_LinkStatsWeeks - суточные скетчи складываются в недели и месяцы
+func TestURLShortener_LinkStatsWeeks(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "https://ya.ru"))
+	// 2 марта 2026 - понедельник
+	monday := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	var clicks []storage.Click
+	for i := 0; i < 100; i++ {
+		// первая неделя: 50 посетителей по два раза в разные дни, вторая - 100 новых
+		clicks = append(clicks,
+			storage.Click{ShortURL: "id1", At: monday.Add(time.Duration(i%7) * 24 * time.Hour), IPHash: fmt.Sprintf("a%d", i%50)},
+			storage.Click{ShortURL: "id1", At: monday.AddDate(0, 0, 7+i%7), IPHash: fmt.Sprintf("b%d", i)},
+		)
+	}
+	require.NoError(t, store.SaveClicks(ctx, clicks))
+	shortener := NewURLShortener(store)
+
+	stats, err := shortener.LinkStats(ctx, "u1", "id1", "", storage.ClickQuery{
+		From: monday.Add(time.Hour), To: monday.AddDate(0, 0, 10), Bucket: storage.BucketWeek,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, monday, stats.From)
+	assert.Equal(t, monday.AddDate(0, 0, 14), stats.To)
+	require.Len(t, stats.Clicks, 2)
+	assert.EqualValues(t, 100, stats.Clicks[0].Clicks)
+	assert.InDelta(t, 50, *stats.Clicks[0].UniqueVisitors, 2)
+	assert.InDelta(t, 100, *stats.Clicks[1].UniqueVisitors, 3)
+	assert.InDelta(t, 150, stats.UniqueVisitors, 5)
+
+	stats, err = shortener.LinkStats(ctx, "u1", "id1", "", storage.ClickQuery{
+		From: monday, To: monday.Add(time.Hour), Bucket: storage.BucketMonth,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), stats.From)
+	assert.Equal(t, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), stats.To)
+	require.Len(t, stats.Clicks, 1)
+	assert.EqualValues(t, 200, stats.Clicks[0].Clicks)
+	assert.InDelta(t, 150, *stats.Clicks[0].UniqueVisitors, 5)
+
+	// по часам уникальных в отрезках нет, только итог по суткам
+	stats, err = shortener.LinkStats(ctx, "u1", "id1", "", storage.ClickQuery{
+		From: monday, To: monday.Add(2 * time.Hour), Bucket: storage.BucketHour,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, stats.Clicks[0].UniqueVisitors)
+	assert.InDelta(t, 15, stats.UniqueVisitors, 1)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mkukarin01/snort/internal/idgen"
+)
+
+// ErrIDSpaceExhausted - за отведенные попытки свободный short_id так и не нашелся
+var ErrIDSpaceExhausted = errors.New("no free short id found")
+
+// настройки повторов при коллизиях по умолчанию
+const (
+	DefaultIDMaxAttempts = 10
+	// DefaultIDGrowThreshold доля сохранений с коллизией, после которой удлиняем id
+	DefaultIDGrowThreshold = 0.1
+)
+
+// collisionWindow по скольким последним сохранениям считаем долю коллизий
+const collisionWindow = 100
+
+// IDStats счетчики генерации short_id для мониторинга, с запуска процесса
+type IDStats struct {
+	Attempts   int64 `json:"attempts"`
+	Collisions int64 `json:"collisions"`
+	Exhausted  int64 `json:"exhausted"`
+	Grown      int64 `json:"grown"`
+	// Length текущая длина id (для words - слов), 0 - генератор не удлиняется
+	Length int `json:"length"`
+}
+
+// collisionPolicy сколько раз перегенерируем id и когда его удлинять.
+// Длину наращиваем только в памяти: после рестарта начинаем с конфига и,
+// если место и правда кончилось, быстро дорастаем обратно
+type collisionPolicy struct {
+	maxAttempts int
+	threshold   float64
+
+	attempts   atomic.Int64
+	collisions atomic.Int64
+	exhausted  atomic.Int64
+	grown      atomic.Int64
+
+	mu               sync.Mutex
+	windowSaves      int
+	windowCollisions int
+}
+
+func newCollisionPolicy(maxAttempts int, threshold float64) *collisionPolicy {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultIDMaxAttempts
+	}
+	return &collisionPolicy{maxAttempts: maxAttempts, threshold: threshold}
+}
+
+// record итог одного сохранения; окно набралось и коллизий больше порога - удлиняем id,
+// threshold <= 0 - не удлиняем никогда
+func (cp *collisionPolicy) record(gen idgen.Generator, collided bool) {
+	cp.attempts.Add(1)
+	if collided {
+		cp.collisions.Add(1)
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.windowSaves++
+	if collided {
+		cp.windowCollisions++
+	}
+	if cp.windowSaves < collisionWindow {
+		return
+	}
+	rate := float64(cp.windowCollisions) / float64(cp.windowSaves)
+	cp.windowSaves, cp.windowCollisions = 0, 0
+	if cp.threshold > 0 && rate > cp.threshold {
+		cp.grow(gen)
+	}
+}
+
+// giveUp попытки кончились: считаем и сразу удлиняем, не дожидаясь окна
+func (cp *collisionPolicy) giveUp(gen idgen.Generator) {
+	cp.exhausted.Add(1)
+	if cp.threshold <= 0 {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.windowSaves, cp.windowCollisions = 0, 0
+	cp.grow(gen)
+}
+
+// grow вызывать под mu, чтобы несколько горутин не удлинили id разом
+func (cp *collisionPolicy) grow(gen idgen.Generator) {
+	if g, ok := gen.(idgen.Growable); ok && g.Grow() {
+		cp.grown.Add(1)
+	}
+}
+
+func (cp *collisionPolicy) stats(gen idgen.Generator) IDStats {
+	s := IDStats{
+		Attempts:   cp.attempts.Load(),
+		Collisions: cp.collisions.Load(),
+		Exhausted:  cp.exhausted.Load(),
+		Grown:      cp.grown.Load(),
+	}
+	if g, ok := gen.(idgen.Growable); ok {
+		s.Length = g.Size()
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mkukarin01/snort/internal/idgen"
+	"github.com/mkukarin01/snort/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sameID всегда один и тот же id, удлинять нечего
+type sameID struct{}
+
+func (sameID) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return "same", nil
+}
+
+// занятый id перегенерируем ограниченное число раз и отдаем отдельную ошибку
+func TestURLShortener_CollisionsGiveUp(t *testing.T) {
+	shortener := NewURLShortener(storage.NewMemoryStorage(),
+		WithIDGenerator(sameID{}),
+		WithCollisionPolicy(4, 0.1),
+	)
+	ctx := context.Background()
+
+	id, err := shortener.Shorten(ctx, "https://ya.ru", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "same", id)
+
+	_, err = shortener.Shorten(ctx, "https://yandex.ru", "foo")
+	assert.ErrorIs(t, err, ErrIDSpaceExhausted)
+
+	stats := shortener.IDStats()
+	assert.Equal(t, int64(5), stats.Attempts)
+	assert.Equal(t, int64(4), stats.Collisions)
+	assert.Equal(t, int64(1), stats.Exhausted)
+	assert.Zero(t, stats.Grown)
+	assert.Zero(t, stats.Length)
+}
+
+// id из одного знака кончаются быстро: сдаемся, удлиняем и дальше сокращаем
+func TestURLShortener_CollisionsGrow(t *testing.T) {
+	ids, err := idgen.NewRandom(1, "ab")
+	require.NoError(t, err)
+	shortener := NewURLShortener(storage.NewMemoryStorage(),
+		WithIDGenerator(ids),
+		WithCollisionPolicy(3, 0.1),
+	)
+	ctx := context.Background()
+
+	for i := 0; i < 20 && shortener.IDStats().Grown == 0; i++ {
+		_, err := shortener.Shorten(ctx, fmt.Sprintf("https://ya.ru/%d", i), "foo")
+		if err != nil {
+			assert.ErrorIs(t, err, ErrIDSpaceExhausted)
+		}
+	}
+	stats := shortener.IDStats()
+	require.Equal(t, int64(1), stats.Grown)
+	assert.Equal(t, 2, stats.Length)
+
+	id, err := shortener.Shorten(ctx, "https://ya.ru/next", "foo")
+	require.NoError(t, err)
+	assert.Len(t, id, 2)
+}
+
+// по окну: коллизий больше порога - удлиняем, меньше - нет, 0 - не удлиняем вовсе
+func TestCollisionPolicy_record(t *testing.T) {
+	tests := []struct {
+		name       string
+		threshold  float64
+		collisions int
+		wantLength int
+	}{
+		{name: "below threshold", threshold: 0.1, collisions: 10, wantLength: 8},
+		{name: "above threshold", threshold: 0.1, collisions: 11, wantLength: 9},
+		{name: "disabled", threshold: 0, collisions: 100, wantLength: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := idgen.NewRandom(8, idgen.Base62)
+			require.NoError(t, err)
+			cp := newCollisionPolicy(DefaultIDMaxAttempts, tt.threshold)
+
+			for i := 0; i < collisionWindow; i++ {
+				cp.record(ids, i < tt.collisions)
+			}
+			assert.Equal(t, tt.wantLength, ids.Size())
+			assert.Equal(t, int64(tt.collisions), cp.stats(ids).Collisions)
+		})
+	}
+}
+
+// батч идет через те же повторы и счетчики: одинаковые id внутри батча - коллизии
+func TestURLShortener_BatchCollisions(t *testing.T) {
+	shortener := NewURLShortener(storage.NewMemoryStorage(),
+		WithIDGenerator(sameID{}),
+		WithCollisionPolicy(3, 0.1),
+	)
+	ctx := context.Background()
+
+	_, err := shortener.ShortenBatch(ctx, map[string]string{
+		"1": "https://ya.ru",
+		"2": "https://yandex.ru",
+	}, "foo")
+	assert.ErrorIs(t, err, ErrIDSpaceExhausted)
+
+	// первая попытка сохранила одну ссылку, вторая ссылка упиралась в ее id все 3 раза
+	stats := shortener.IDStats()
+	assert.Equal(t, int64(4), stats.Attempts)
+	assert.Equal(t, int64(3), stats.Collisions)
+	assert.Equal(t, int64(1), stats.Exhausted)
+}
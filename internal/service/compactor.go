@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// DefaultRollupBatch сколько сырых переходов сворачиваем за один вызов хранилища
+const DefaultRollupBatch = 10000
+
+// ClickCompactor - фоновая свертка по образцу URLDeleter: по тикеру сырые переходы
+// старше retention сворачиваются в суточные агрегаты ссылок и удаляются
+type ClickCompactor struct {
+	store     storage.Storager
+	retention time.Duration
+	interval  time.Duration
+	batch     int
+	now       func() time.Time
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewClickCompactor - создаём свертку
+func NewClickCompactor(store storage.Storager, retention, interval time.Duration) *ClickCompactor {
+	return &ClickCompactor{
+		store:     store,
+		retention: retention,
+		interval:  interval,
+		batch:     DefaultRollupBatch,
+		now:       time.Now,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start запускаем воркер, wg.Add до горутины - как у ClickRecorder
+func (cc *ClickCompactor) Start() {
+	cc.wg.Add(1)
+	go cc.run()
+}
+
+// run сворачиваем по тикеру до Stop; на остановке новый проход не начинаем -
+// несвернутое дождется следующего запуска
+func (cc *ClickCompactor) run() {
+	defer cc.wg.Done()
+
+	ticker := time.NewTicker(cc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cc.stopCh:
+			return
+		case <-ticker.C:
+			cc.compact()
+		}
+	}
+}
+
+// Stop - остановить, текущую пачку доделываем
+func (cc *ClickCompactor) Stop() {
+	close(cc.stopCh)
+	cc.wg.Wait()
+}
+
+// compact - один проход: граница по началу суток, чтобы сутки сворачивались целиком,
+// пачками, пока хранилище отдает полные
+func (cc *ClickCompactor) compact() {
+	before := storage.BucketDay.Truncate(cc.now().Add(-cc.retention))
+
+	var total int64
+loop:
+	for {
+		rolled, err := cc.store.RollupClicks(context.Background(), before, cc.batch)
+		if err != nil {
+			log.Printf("ERROR: RollupClicks before=%s, err=%v", before.Format(time.RFC3339), err)
+			break
+		}
+		total += rolled
+		if rolled < int64(cc.batch) {
+			break
+		}
+		select {
+		case <-cc.stopCh:
+			// остальное свернем после рестарта
+			break loop
+		default:
+		}
+	}
+	if total > 0 {
+		log.Printf("Rolled up %d raw clicks older than %s", total, before.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// TestClickCompactor_compact - граница по началу суток, пачки гоняем, пока приходят полные
+func TestClickCompactor_compact(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := storage.NewMockStorager(mockCtrl)
+	compactor := NewClickCompactor(mockStore, 30*24*time.Hour, time.Hour)
+	compactor.batch = 2
+
+	now := time.Date(2026, 3, 31, 15, 30, 0, 0, time.UTC)
+	compactor.now = func() time.Time { return now }
+	before := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	gomock.InOrder(
+		mockStore.EXPECT().RollupClicks(gomock.Any(), before, 2).Return(int64(2), nil),
+		mockStore.EXPECT().RollupClicks(gomock.Any(), before, 2).Return(int64(2), nil),
+		mockStore.EXPECT().RollupClicks(gomock.Any(), before, 2).Return(int64(1), nil),
+	)
+
+	compactor.compact()
+}
+
+// TestClickCompactor_Run - по тикеру сворачиваем, по Stop выходим
+func TestClickCompactor_Run(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := storage.NewMockStorager(mockCtrl)
+	compactor := NewClickCompactor(mockStore, time.Hour, 50*time.Millisecond)
+
+	mockStore.EXPECT().RollupClicks(gomock.Any(), gomock.Any(), DefaultRollupBatch).Return(int64(0), nil).MinTimes(1)
+
+	compactor.Start()
+	time.Sleep(150 * time.Millisecond)
+	compactor.Stop()
+}
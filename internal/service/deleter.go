@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -33,11 +34,16 @@ func NewURLDeleter(store storage.Storager) *URLDeleter {
 	}
 }
 
-// Run запускатор, цикл на чтение и агрегация тасков по фанин
-func (d *URLDeleter) Run() {
-	// какой-то странный костыль, когда запускаешь горутину, если она ничего не сделала, а ты выполнил Stop
-	// произойдет `panic: sync: negative WaitGroup counter` с ненулевым wg этой проблемы нет
+// Start запускатор воркера, wg.Add до горутины - как у ClickRecorder и URLPurger,
+// иначе Stop сразу после Start мог не дождаться воркера
+func (d *URLDeleter) Start() {
 	d.wg.Add(1)
+	go d.run()
+}
+
+// run цикл на чтение и агрегация тасков по фанин
+func (d *URLDeleter) run() {
+	defer d.wg.Done()
 
 	flushInterval := time.Second
 
@@ -59,10 +65,16 @@ func (d *URLDeleter) Run() {
 	for {
 		select {
 		case <-d.stopCh:
-			// финалочка
-			flushFunc()
-			d.wg.Done()
-			return
+			// финалочка: дочитываем то, что успели накидать до Stop
+			for {
+				select {
+				case job := <-d.inChan:
+					userBatch[job.userID] = append(userBatch[job.userID], job.shortIDs...)
+				default:
+					flushFunc()
+					return
+				}
+			}
 		case job := <-d.inChan:
 			// копим
 			userBatch[job.userID] = append(userBatch[job.userID], job.shortIDs...)
@@ -80,24 +92,32 @@ func (d *URLDeleter) Run() {
 	}
 }
 
-// Submit - для хендлеров отправка задач
-func (d *URLDeleter) Submit(userID string, shortIDs []string) {
-	d.inChan <- deleteJob{
+// Submit - для хендлеров отправка задач, если очередь забита - ждем не дольше контекста запроса
+func (d *URLDeleter) Submit(ctx context.Context, userID string, shortIDs []string) error {
+	select {
+	case d.inChan <- deleteJob{
 		userID:   userID,
 		shortIDs: shortIDs,
+	}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// Stop - остановить
+// Stop - остановить, очередь сбрасываем в хранилище до возврата
 func (d *URLDeleter) Stop() {
 	close(d.stopCh)
 	d.wg.Wait()
 }
 
 // flush - собственно сброс - выполняем задачи
+// запросы, которые накидали задачи, уже завершились, так что контекст свой, а дедлайн
+// навешивает само хранилище (storage.WithTimeouts)
 func (d *URLDeleter) flush(userBatch map[string][]string) {
+	ctx := context.Background()
 	for uid, sids := range userBatch {
-		err := d.store.MarkUserURLsDeleted(uid, sids)
+		err := d.store.MarkUserURLsDeleted(ctx, uid, sids)
 		if err != nil {
 			log.Printf("ERROR: MarkUserURLsDeleted user=%s, shortIDs=%v, err=%v", uid, sids, err)
 		} else {
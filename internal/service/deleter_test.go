@@ -1,7 +1,7 @@
 package service
 
 import (
-	"sync"
+	"context"
 	"testing"
 	"time"
 
@@ -25,8 +25,7 @@ func TestNewURLDeleter(t *testing.T) {
 	assert.NotNil(t, deleter.stopCh)
 	assert.Equal(t, 100, deleter.bufSize)
 
-	go deleter.Run()
-	time.Sleep(500 * time.Millisecond)
+	deleter.Start()
 	deleter.Stop()
 }
 
@@ -55,28 +54,22 @@ func TestURLDeleter_FlushByBufferThreshold(t *testing.T) {
 	// MarkUserURLsDeleted - вызывается 1 раз
 	mockStore.
 		EXPECT().
-		MarkUserURLsDeleted(userID, shortIDsAll).
+		MarkUserURLsDeleted(gomock.Any(), userID, shortIDsAll).
 		Return(nil).
 		Times(1)
 
 	// запуск в отдельной рутине
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		deleter.Run()
-	}()
+	deleter.Start()
 
 	// сабмит задач
-	deleter.Submit(userID, []string{"id1", "id2"}) // +2
-	deleter.Submit(userID, []string{"id3"})        // +1 === bufSize
+	deleter.Submit(context.Background(), userID, []string{"id1", "id2"}) // +2
+	deleter.Submit(context.Background(), userID, []string{"id3"})        // +1 === bufSize
 
 	// поспим
 	time.Sleep(100 * time.Millisecond)
 
 	// остановка
-	close(deleter.stopCh)
-	wg.Wait()
+	deleter.Stop()
 }
 
 // TestURLDeleter_FlushByTimeout проверка, истечение таймера => flush, даже если буфер еще ок
@@ -100,25 +93,19 @@ func TestURLDeleter_FlushByTimeout(t *testing.T) {
 	// MarkUserURLsDeleted по таймеру должен сработать
 	mockStore.
 		EXPECT().
-		MarkUserURLsDeleted(userID, shortIDsAll).
+		MarkUserURLsDeleted(gomock.Any(), userID, shortIDsAll).
 		Return(nil).
 		Times(1)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		deleter.Run()
-	}()
+	deleter.Start()
 
 	// отправляем задачу
-	deleter.Submit(userID, shortIDsAll)
+	deleter.Submit(context.Background(), userID, shortIDsAll)
 
 	// 1.2 секунды чтобы точно сработал flush
 	time.Sleep(1200 * time.Millisecond)
 
-	close(deleter.stopCh)
-	wg.Wait()
+	deleter.Stop()
 }
 
 // TestURLDeleter_Stop закрытие тоже вызывает flush
@@ -140,26 +127,41 @@ func TestURLDeleter_Stop(t *testing.T) {
 
 	mockStore.
 		EXPECT().
-		MarkUserURLsDeleted(userID, shortIDsAll).
+		MarkUserURLsDeleted(gomock.Any(), userID, shortIDsAll).
 		Return(nil).
 		Times(1)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		deleter.Run()
-	}()
+	deleter.Start()
 
 	// +3
-	deleter.Submit(userID, shortIDsAll)
+	deleter.Submit(context.Background(), userID, shortIDsAll)
 
 	// run жует данные, пусть будет 300
 	time.Sleep(300 * time.Millisecond)
 
 	// стопаем канал
-	close(deleter.stopCh)
-	wg.Wait()
+	deleter.Stop()
+}
+
+// TestURLDeleter_StopDrains задачи, которые еще лежат в очереди, Stop тоже сбрасывает
+func TestURLDeleter_StopDrains(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := storage.NewMockStorager(mockCtrl)
+	deleter := NewURLDeleter(mockStore)
+
+	mockStore.
+		EXPECT().
+		MarkUserURLsDeleted(gomock.Any(), "userA", []string{"id1", "id2", "id3"}).
+		Return(nil).
+		Times(1)
+
+	// очередь набиваем до старта воркера, Stop сразу за Start
+	deleter.Submit(context.Background(), "userA", []string{"id1"})
+	deleter.Submit(context.Background(), "userA", []string{"id2", "id3"})
+	deleter.Start()
+	deleter.Stop()
 }
 
 // TestURLDeleter_flush - дополнительный не красивый тест приватной функции, потому что могу
@@ -183,9 +185,23 @@ func TestURLDeleter_flush(t *testing.T) {
 	}
 
 	// 2 пользака === 2 вызова
-	mockStore.EXPECT().MarkUserURLsDeleted("userA", []string{"short1", "short2"}).Return(nil).Times(1)
-	mockStore.EXPECT().MarkUserURLsDeleted("userB", []string{"short3"}).Return(nil).Times(1)
+	mockStore.EXPECT().MarkUserURLsDeleted(gomock.Any(), "userA", []string{"short1", "short2"}).Return(nil).Times(1)
+	mockStore.EXPECT().MarkUserURLsDeleted(gomock.Any(), "userB", []string{"short3"}).Return(nil).Times(1)
 
 	// стопаем канал
 	deleter.flush(userBatch)
 }
+
+// TestURLDeleter_SubmitCanceled забитая очередь не держит хендлер дольше контекста
+func TestURLDeleter_SubmitCanceled(t *testing.T) {
+	deleter := &URLDeleter{
+		inChan: make(chan deleteJob), // без буфера и без Start - никто не читает
+		stopCh: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := deleter.Submit(ctx, "user", []string{"id1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ошибки проверки пароля ссылки
+var (
+	// ErrPasswordRequired - ссылка под паролем, а его не прислали
+	ErrPasswordRequired = errors.New("password required")
+	// ErrPasswordInvalid - пароль не подошел
+	ErrPasswordInvalid = errors.New("invalid password")
+	// ErrTooManyAttempts - слишком много неудачных попыток, ссылка временно закрыта
+	ErrTooManyAttempts = errors.New("too many failed password attempts")
+)
+
+// настройки ограничения попыток по умолчанию
+const (
+	DefaultPasswordMaxAttempts       = 10
+	DefaultPasswordClientMaxAttempts = 5
+	DefaultPasswordLockout           = 15 * time.Minute
+)
+
+// maxPasswordLen bcrypt дальше 72 байт не смотрит, длиннее не принимаем вовсе
+const maxPasswordLen = 72
+
+// TooManyAttemptsError ссылка закрыта до RetryAfter, errors.Is(err, ErrTooManyAttempts) работает
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// hashPassword bcrypt сам солит, соль хранится внутри хэша
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLen {
+		return "", fmt.Errorf("password is longer than %d bytes", maxPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// passwordAttempts неудачные попытки по одной ссылке (всего или одного клиента)
+type passwordAttempts struct {
+	failures    int
+	first       time.Time // первая неудача в текущем окне
+	lockedUntil time.Time
+}
+
+// throttleKey счетчик ссылки целиком (client пустой) или одного клиента по ссылке
+type throttleKey struct {
+	id     string
+	client string
+}
+
+// PasswordThrottle ограничиваем перебор паролей: после maxAttempts неудач по ссылке
+// (в пределах lockout, со всех адресов вместе) ссылка закрывается на lockout для всех,
+// даже с верным паролем, так что перебор с разных адресов лимит не умножает.
+// Сверху свой лимит clientMaxAttempts у каждого адреса: если он меньше общего, один
+// клиент закрывает ссылку только себе, а не владельцу.
+// Считаем в памяти процесса, для нескольких инстансов лимит умножается на их число
+type PasswordThrottle struct {
+	maxAttempts       int
+	clientMaxAttempts int
+	lockout           time.Duration
+	now               func() time.Time
+
+	mu       sync.Mutex
+	attempts map[throttleKey]*passwordAttempts
+}
+
+// NewPasswordThrottle maxAttempts - лимит на ссылку, clientMaxAttempts - на адрес клиента;
+// <= 0 - без такого ограничения
+func NewPasswordThrottle(maxAttempts, clientMaxAttempts int, lockout time.Duration) *PasswordThrottle {
+	return &PasswordThrottle{
+		maxAttempts:       maxAttempts,
+		clientMaxAttempts: clientMaxAttempts,
+		lockout:           lockout,
+		now:               time.Now,
+		attempts:          make(map[throttleKey]*passwordAttempts),
+	}
+}
+
+// limits счетчики попытки с их лимитами: ссылки и, если адрес известен, клиента
+func (pt *PasswordThrottle) limits(id, client string) map[throttleKey]int {
+	limits := make(map[throttleKey]int, 2)
+	if pt.maxAttempts > 0 {
+		limits[throttleKey{id: id}] = pt.maxAttempts
+	}
+	if pt.clientMaxAttempts > 0 && client != "" {
+		limits[throttleKey{id: id, client: client}] = pt.clientMaxAttempts
+	}
+	return limits
+}
+
+// reserve можно ли сейчас проверять пароль, иначе сколько ждать. С count попытку
+// сразу засчитываем неудачной во все счетчики, на лимите закрываем: проверка и учет
+// под одним mu, так что параллельные догадки не проскочат лимит, пока идет медленный
+// bcrypt. Верный пароль потом вернет попытку через release
+func (pt *PasswordThrottle) reserve(limits map[throttleKey]int, count bool) (time.Duration, bool) {
+	if len(limits) == 0 {
+		return 0, true
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	now := pt.now()
+	var wait time.Duration
+	for key := range limits {
+		a, ok := pt.attempts[key]
+		if !ok {
+			continue
+		}
+		if left := a.lockedUntil.Sub(now); left > 0 {
+			wait = max(wait, left)
+			continue
+		}
+		if !a.lockedUntil.IsZero() || now.Sub(a.first) >= pt.lockout {
+			// блокировка или окно прошли - считаем с нуля
+			delete(pt.attempts, key)
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	if !count {
+		return 0, true
+	}
+
+	for key, limit := range limits {
+		a, ok := pt.attempts[key]
+		if !ok {
+			pt.sweep(now)
+			a = &passwordAttempts{first: now}
+			pt.attempts[key] = a
+		}
+		a.failures++
+		if a.failures >= limit {
+			a.lockedUntil = now.Add(pt.lockout)
+		}
+	}
+	return 0, true
+}
+
+// release верный пароль: счетчик клиента забываем, а в счетчике ссылки возвращаем
+// только свою зарезервированную попытку - чужие неудачи владелец не списывает
+func (pt *PasswordThrottle) release(limits map[throttleKey]int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	for key, limit := range limits {
+		if key.client != "" {
+			delete(pt.attempts, key)
+			continue
+		}
+		a, ok := pt.attempts[key]
+		if !ok {
+			continue
+		}
+		if a.failures--; a.failures <= 0 {
+			delete(pt.attempts, key)
+			continue
+		}
+		if a.failures < limit {
+			// закрыли на нашей же попытке, а она оказалась верной
+			a.lockedUntil = time.Time{}
+		}
+	}
+}
+
+// sweep выкидываем протухшие записи, чтобы перебор по разным id не копил память;
+// зовем только при добавлении новой записи и только когда их много, вызывать под mu
+func (pt *PasswordThrottle) sweep(now time.Time) {
+	if len(pt.attempts) < 1024 {
+		return
+	}
+	for key, a := range pt.attempts {
+		if now.After(a.lockedUntil) && now.Sub(a.first) >= pt.lockout {
+			delete(pt.attempts, key)
+		}
+	}
+}
+
+// check сверяем пароль с хэшем с учетом ограничения попыток по ссылке и клиента client
+func (pt *PasswordThrottle) check(id, client, hash, password string) error {
+	limits := pt.limits(id, client)
+	// без пароля нечего сверять - попытку не тратим, но про блокировку скажем
+	if wait, ok := pt.reserve(limits, password != ""); !ok {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if len(password) > maxPasswordLen || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrPasswordInvalid
+	}
+	pt.release(limits)
+	return nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestURLShortener_Password - хэш вместо пароля, переход только с верным паролем
+func TestURLShortener_Password(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	shortener := NewURLShortener(store)
+
+	one := int64(1)
+	id, err := shortener.ShortenLink(ctx, "https://docs.ru/private", "u1", LinkOptions{Password: "s3cret", MaxClicks: &one})
+	require.NoError(t, err)
+
+	link, err := store.LoadLink(ctx, id)
+	require.NoError(t, err)
+	assert.NotEqual(t, "s3cret", link.PasswordHash)
+	assert.NotEmpty(t, link.PasswordHash)
+
+	_, err = shortener.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	_, err = shortener.RetrieveWithPassword(ctx, id, "wrong", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrPasswordInvalid)
+
+	// неверные попытки переход не тратят
+	url, err := shortener.RetrieveWithPassword(ctx, id, "s3cret", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://docs.ru/private", url)
+
+	urls, err := shortener.UserURLs(ctx, "u1", "http://localhost")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, urls[0].Protected)
+}
+
+// TestPasswordThrottle - после лимита неудач клиента ссылка закрыта для него даже с верным паролем
+func TestPasswordThrottle(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	require.NoError(t, err)
+
+	now := time.Now()
+	pt := NewPasswordThrottle(10, 3, time.Minute)
+	pt.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	}
+	err = pt.check("id", "10.0.0.1", hash, "s3cret")
+	var throttled *TooManyAttemptsError
+	require.True(t, errors.As(err, &throttled))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	assert.Equal(t, time.Minute, throttled.RetryAfter)
+
+	// у соседней ссылки свой счетчик, у другого клиента тоже
+	assert.NoError(t, pt.check("other", "10.0.0.1", hash, "s3cret"))
+	assert.NoError(t, pt.check("id", "10.0.0.2", hash, "s3cret"))
+	// без пароля попытка не тратится
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, pt.check("id", "10.0.0.2", hash, ""), ErrPasswordRequired)
+	}
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, pt.check("id", "10.0.0.1", hash, "s3cret"))
+
+	// верный пароль сбрасывает неудачи клиента
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	assert.NoError(t, pt.check("id", "10.0.0.1", hash, "s3cret"))
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "wrong"), ErrPasswordInvalid)
+	assert.ErrorIs(t, pt.check("id", "10.0.0.1", hash, "s3cret"), ErrTooManyAttempts)
+}
+
+// TestPasswordThrottle_ManyClients - перебор с разных адресов упирается в общий лимит ссылки,
+// а верный пароль не списывает чужие неудачи
+func TestPasswordThrottle_ManyClients(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	require.NoError(t, err)
+
+	now := time.Now()
+	pt := NewPasswordThrottle(5, 2, time.Minute)
+	pt.now = func() time.Time { return now }
+
+	// 4 неудачи с разных адресов, верный пароль владельца - общий счетчик остается 4
+	for i := 0; i < 4; i++ {
+		assert.ErrorIs(t, pt.check("id", fmt.Sprintf("10.0.0.%d", i), hash, "wrong"), ErrPasswordInvalid)
+	}
+	assert.NoError(t, pt.check("id", "192.0.2.1", hash, "s3cret"))
+
+	var invalid int
+	for i := 0; i < 50; i++ {
+		err := pt.check("id", fmt.Sprintf("10.0.1.%d", i), hash, "wrong")
+		if errors.Is(err, ErrPasswordInvalid) {
+			invalid++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+	}
+	assert.Equal(t, 1, invalid)
+	// закрыта для всех, в том числе для владельца с верным паролем и для пустого адреса
+	assert.ErrorIs(t, pt.check("id", "192.0.2.1", hash, "s3cret"), ErrTooManyAttempts)
+	assert.ErrorIs(t, pt.check("id", "", hash, "s3cret"), ErrTooManyAttempts)
+	assert.NoError(t, pt.check("other", "10.0.1.1", hash, "s3cret"))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, pt.check("id", "192.0.2.1", hash, "s3cret"))
+}
+
+// TestPasswordThrottle_Parallel - параллельные догадки не проскакивают лимит, пока идет bcrypt
+func TestPasswordThrottle_Parallel(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	require.NoError(t, err)
+	pt := NewPasswordThrottle(3, 0, time.Minute)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		invalid int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pt.check("id", "10.0.0.1", hash, "wrong")
+			if errors.Is(err, ErrPasswordInvalid) {
+				mu.Lock()
+				invalid++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, ErrTooManyAttempts)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, invalid)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// URLPurger - фоновая очистка: ссылки, удаленные раньше чем retention назад,
+// удаляются из хранилища насовсем, освобождая short_id и url
+type URLPurger struct {
+	store     storage.Storager
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewURLPurger - создаём чистильщика
+func NewURLPurger(store storage.Storager, retention, interval time.Duration) *URLPurger {
+	return &URLPurger{
+		store:     store,
+		retention: retention,
+		interval:  interval,
+		now:       time.Now,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start запускаем воркер, wg.Add до горутины - как у ClickRecorder и ClickCompactor
+func (p *URLPurger) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// run чистим по тикеру до Stop
+func (p *URLPurger) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.purge()
+		}
+	}
+}
+
+// Stop - остановить, текущий проход доделываем
+func (p *URLPurger) Stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
+
+// purge - один проход очистки
+func (p *URLPurger) purge() {
+	before := p.now().Add(-p.retention)
+
+	purged, err := p.store.PurgeDeleted(context.Background(), before)
+	if err != nil {
+		log.Printf("ERROR: PurgeDeleted before=%s, err=%v", before.Format(time.RFC3339), err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted URLs, deleted before %s", purged, before.Format(time.RFC3339))
+	}
+}
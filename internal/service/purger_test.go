@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// TestURLPurger_purge - граница очистки считается от текущего времени минус retention
+func TestURLPurger_purge(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := storage.NewMockStorager(mockCtrl)
+	purger := NewURLPurger(mockStore, 24*time.Hour, time.Hour)
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	purger.now = func() time.Time { return now }
+
+	mockStore.EXPECT().PurgeDeleted(gomock.Any(), now.Add(-24*time.Hour)).Return(int64(3), nil).Times(1)
+
+	purger.purge()
+}
+
+// TestURLPurger_Run - по тикеру чистим, по Stop выходим
+func TestURLPurger_Run(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := storage.NewMockStorager(mockCtrl)
+	purger := NewURLPurger(mockStore, time.Hour, 50*time.Millisecond)
+
+	mockStore.EXPECT().PurgeDeleted(gomock.Any(), gomock.Any()).Return(int64(0), nil).MinTimes(1)
+
+	purger.Start()
+	time.Sleep(150 * time.Millisecond)
+	purger.Stop()
+}
+
+// TestURLPurger_MemoryStorage - удаленные дольше retention ссылки пропадают, url снова свободен
+func TestURLPurger_MemoryStorage(t *testing.T) {
+	for name, store := range map[string]storage.Storager{
+		"single":  storage.NewMemoryStorage(),
+		"sharded": storage.NewShardedMemoryStorage(4),
+	} {
+		t.Run(name, func(t *testing.T) {
+			shortener := NewURLShortener(store)
+			ctx := context.Background()
+
+			id, err := shortener.Shorten(ctx, "https://ya.ru", "u1")
+			assert.NoError(t, err)
+			assert.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{id}))
+
+			purger := NewURLPurger(store, time.Hour, time.Hour)
+			purger.purge()
+			_, err = shortener.Retrieve(ctx, id)
+			assert.ErrorIs(t, err, storage.ErrURLDeleted, "retention еще не прошел")
+
+			purger.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			purger.purge()
+			_, err = shortener.Retrieve(ctx, id)
+			assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+			_, err = shortener.Shorten(ctx, "https://ya.ru", "u2")
+			assert.NoError(t, err)
+		})
+	}
+}
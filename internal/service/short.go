@@ -1,34 +1,131 @@
 package service
 
 import (
+	"context"
 	"errors"
-	"math/rand"
+	"fmt"
+	"time"
 
+	"github.com/mkukarin01/snort/internal/idgen"
 	"github.com/mkukarin01/snort/internal/storage"
 )
 
 // URLShortener обертка для хранилища
 type URLShortener struct {
-	store storage.Storager
+	store      storage.Storager
+	throttle   *PasswordThrottle
+	aliases    *AliasPolicy
+	ids        idgen.Generator
+	collisions *collisionPolicy
 }
 
-// UserURL структурка (short_url, original_url)
+// Option настройка URLShortener
+type Option func(*URLShortener)
+
+// WithPasswordThrottle свои лимиты на перебор паролей ссылок: на ссылку и на клиента
+func WithPasswordThrottle(maxAttempts, clientMaxAttempts int, lockout time.Duration) Option {
+	return func(us *URLShortener) {
+		us.throttle = NewPasswordThrottle(maxAttempts, clientMaxAttempts, lockout)
+	}
+}
+
+// WithAliasPolicy свои правила для алиасов
+func WithAliasPolicy(p *AliasPolicy) Option {
+	return func(us *URLShortener) {
+		us.aliases = p
+	}
+}
+
+// WithIDGenerator своя стратегия генерации short_id
+func WithIDGenerator(g idgen.Generator) Option {
+	return func(us *URLShortener) {
+		us.ids = g
+	}
+}
+
+// WithCollisionPolicy сколько раз перегенерировать занятый short_id и при какой доле
+// коллизий удлинять id; threshold <= 0 - длину не трогаем
+func WithCollisionPolicy(maxAttempts int, threshold float64) Option {
+	return func(us *URLShortener) {
+		us.collisions = newCollisionPolicy(maxAttempts, threshold)
+	}
+}
+
+// UserURL структурка (short_url, original_url, expires_at, clicks_left, protected)
 type UserURL struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ClicksLeft  *int64     `json:"clicks_left,omitempty"`
+	Protected   bool       `json:"protected,omitempty"`
+}
+
+// LinkOptions необязательные свойства новой ссылки
+type LinkOptions struct {
+	// ExpiresAt после этого момента редирект отдает 410, nil - бессрочная
+	ExpiresAt *time.Time
+	// MaxClicks сколько раз можно перейти по ссылке, потом 410; nil - без ограничения
+	MaxClicks *int64
+	// Password перед редиректом спросим пароль, храним только хэш; "" - без пароля
+	Password string
+	// Alias свой short_id вместо сгенерированного, "" - генерируем
+	Alias string
+}
+
+// BatchURL элемент батча: ссылка и её свойства
+type BatchURL struct {
+	OriginalURL string
+	LinkOptions
 }
 
 // NewURLShortener создаёт новый URLShortener
-func NewURLShortener(store storage.Storager) *URLShortener {
-	return &URLShortener{store: store}
+func NewURLShortener(store storage.Storager, opts ...Option) *URLShortener {
+	// с настройками по умолчанию конструкторы не ошибаются
+	aliases, _ := NewAliasPolicy("", 0, 0, nil)
+	ids, _ := idgen.NewRandom(idgen.DefaultLength, idgen.Base62)
+	us := &URLShortener{
+		store:      store,
+		throttle:   NewPasswordThrottle(DefaultPasswordMaxAttempts, DefaultPasswordClientMaxAttempts, DefaultPasswordLockout),
+		aliases:    aliases,
+		ids:        ids,
+		collisions: newCollisionPolicy(DefaultIDMaxAttempts, DefaultIDGrowThreshold),
+	}
+	for _, opt := range opts {
+		opt(us)
+	}
+	return us
 }
 
 // Shorten создает короткий идентификатор для ссылки по userID
 // Возвращает сам идентификатор и ошибку (дубликат ссылки или другая проблема)
-func (us *URLShortener) Shorten(originalURL, userID string) (string, error) {
-	for {
-		id := generateID()
-		err := us.store.SaveUserURL(userID, id, originalURL)
+func (us *URLShortener) Shorten(ctx context.Context, originalURL, userID string) (string, error) {
+	return us.ShortenLink(ctx, originalURL, userID, LinkOptions{})
+}
+
+// ShortenLink как Shorten, но со свойствами ссылки
+// с алиасом занятый short_id не перегенерируем, а возвращаем ErrAliasTaken
+func (us *URLShortener) ShortenLink(ctx context.Context, originalURL, userID string, opts LinkOptions) (string, error) {
+	if opts.Alias != "" {
+		if err := us.aliases.Validate(opts.Alias); err != nil {
+			return "", err
+		}
+	}
+	link, err := newLink(originalURL, opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.Alias != "" {
+		return us.saveAlias(ctx, userID, link)
+	}
+
+	for attempt := 0; attempt < us.collisions.maxAttempts; attempt++ {
+		id, err := us.generateID(ctx, originalURL, attempt)
+		if err != nil {
+			return "", err
+		}
+		link.ShortURL = id
+		err = us.store.SaveLink(ctx, userID, link)
+		us.collisions.record(us.ids, errors.Is(err, storage.ErrShortIDConflict))
 		if err == nil {
 			// успех
 			return id, nil
@@ -36,14 +133,7 @@ func (us *URLShortener) Shorten(originalURL, userID string) (string, error) {
 
 		// ошибка - разрбираемся что происходит
 		if errors.Is(err, storage.ErrURLConflict) {
-			// все уже в хранилище, найдем другой shortId и вернем 409 или другую ошибку
-			existingID, saveErr := us.store.FindIDByURL(originalURL)
-
-			if saveErr == nil {
-				return existingID, err
-			}
-
-			return "", saveErr
+			return us.existingID(ctx, userID, originalURL, err)
 		}
 
 		if errors.Is(err, storage.ErrShortIDConflict) {
@@ -54,37 +144,246 @@ func (us *URLShortener) Shorten(originalURL, userID string) (string, error) {
 		// Прочие ошибки - завершаем
 		return "", err
 	}
+
+	return "", us.exhausted()
 }
 
-// ShortenBatch создает короткие идентификаторы для ссылок
-func (us *URLShortener) ShortenBatch(urls map[string]string, userID string) map[string]string {
-	result := make(map[string]string)
-	batchData := make(map[string]string)
+// exhausted попытки кончились: отмечаем в политике коллизий и возвращаем ошибку
+func (us *URLShortener) exhausted() error {
+	us.collisions.giveUp(us.ids)
+	return fmt.Errorf("%w after %d attempts", ErrIDSpaceExhausted, us.collisions.maxAttempts)
+}
+
+// IDStats счетчики коллизий short_id
+func (us *URLShortener) IDStats() IDStats {
+	return us.collisions.stats(us.ids)
+}
 
+// saveAlias сохраняем ссылку под алиасом, ShortURL уже заполнен
+func (us *URLShortener) saveAlias(ctx context.Context, userID string, link storage.Link) (string, error) {
+	err := us.store.SaveLink(ctx, userID, link)
+	switch {
+	case err == nil:
+		return link.ShortURL, nil
+	case errors.Is(err, storage.ErrShortIDConflict):
+		return "", fmt.Errorf("%w: %q", ErrAliasTaken, link.ShortURL)
+	case errors.Is(err, storage.ErrURLConflict):
+		return us.existingID(ctx, userID, link.OriginalURL, err)
+	}
+	return "", err
+}
+
+// existingID url уже в хранилище (глобально или у этого пользователя - зависит от dedup),
+// найдем другой shortId и вернем его с conflictErr (409) или другую ошибку,
+// ищем на primary - реплика могла еще не увидеть конфликтующую запись
+func (us *URLShortener) existingID(ctx context.Context, userID, originalURL string, conflictErr error) (string, error) {
+	existingID, err := us.store.FindIDByURL(storage.WithPrimary(ctx), userID, originalURL)
+	if err == nil {
+		return existingID, conflictErr
+	}
+	return "", err
+}
+
+// ShortenBatch создает короткие идентификаторы для ссылок
+func (us *URLShortener) ShortenBatch(ctx context.Context, urls map[string]string, userID string) (map[string]string, error) {
+	items := make(map[string]BatchURL, len(urls))
 	for correlationID, originalURL := range urls {
-		id := generateID()
+		items[correlationID] = BatchURL{OriginalURL: originalURL}
+	}
+	return us.ShortenBatchLinks(ctx, items, userID)
+}
+
+// ShortenBatchLinks как ShortenBatch, но у каждой ссылки свои свойства.
+// Алиасы проверяем до записи, а сохраняем по одному, чтобы занятый алиас отличить
+// от коллизии. На занятом алиасе останавливаемся с ErrAliasTaken, сохраненные
+// до него ссылки остаются. Занятые сгенерированные short_id генерируем заново
+func (us *URLShortener) ShortenBatchLinks(ctx context.Context, items map[string]BatchURL, userID string) (map[string]string, error) {
+	seen := make(map[string]struct{})
+	for _, item := range items {
+		if item.Alias == "" {
+			continue
+		}
+		if err := us.aliases.Validate(item.Alias); err != nil {
+			return nil, err
+		}
+		if _, dup := seen[item.Alias]; dup {
+			return nil, fmt.Errorf("%w: %q is used twice in batch", ErrInvalidAlias, item.Alias)
+		}
+		seen[item.Alias] = struct{}{}
+	}
+
+	result := make(map[string]string, len(items))
+	generated := make(map[string]storage.Link, len(items))
+	aliased := make(map[string]storage.Link, len(seen))
+
+	for correlationID, item := range items {
+		link, err := newLink(item.OriginalURL, item.LinkOptions)
+		if err != nil {
+			return nil, err
+		}
+		if item.Alias != "" {
+			aliased[correlationID] = link
+			continue
+		}
+		generated[correlationID] = link
+	}
+
+	for correlationID, link := range aliased {
+		id, err := us.saveAlias(ctx, userID, link)
+		// url уже сокращен - как и остальной батч, отдаем существующий short_id
+		if err != nil && !errors.Is(err, storage.ErrURLConflict) {
+			return nil, err
+		}
 		result[correlationID] = id
-		batchData[id] = originalURL
 	}
 
-	us.store.SaveBatchUserURLs(userID, batchData)
+	if err := us.saveGenerated(ctx, userID, generated, result); err != nil {
+		return nil, err
+	}
 
-	return result
+	return result, nil
+}
+
+// saveGenerated сохраняем ссылки батча под сгенерированными short_id и пишем их в result;
+// уже сокращенные url получают существующий short_id, а ссылки, чей id оказался занят,
+// генерируем заново следующей попыткой. Попытки и
+// коллизии считаем по каждой ссылке, как в ShortenLink, так что и батчи удлиняют id
+func (us *URLShortener) saveGenerated(ctx context.Context, userID string, links map[string]storage.Link, result map[string]string) error {
+	pending := make([]string, 0, len(links))
+	for correlationID := range links {
+		pending = append(pending, correlationID)
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == us.collisions.maxAttempts {
+			return us.exhausted()
+		}
+
+		batch := make([]storage.Link, 0, len(pending))
+		for _, correlationID := range pending {
+			link := links[correlationID]
+			id, err := us.generateID(ctx, link.OriginalURL, attempt)
+			if err != nil {
+				return err
+			}
+			link.ShortURL = id
+			batch = append(batch, link)
+		}
+
+		collided := make(map[int]bool)
+		var existing map[int]string
+		err := us.store.SaveBatchLinks(ctx, userID, batch)
+		var conflict *storage.BatchConflictError
+		switch {
+		case errors.As(err, &conflict):
+			for _, i := range conflict.Indexes {
+				collided[i] = true
+			}
+			existing = conflict.Existing
+		case err != nil:
+			return err
+		}
+
+		next := make([]string, 0, len(collided))
+		for i, correlationID := range pending {
+			// url уже сокращен - как и в Shorten, отдаем существующий short_id
+			if existID, ok := existing[i]; ok {
+				result[correlationID] = existID
+				continue
+			}
+			us.collisions.record(us.ids, collided[i])
+			if collided[i] {
+				next = append(next, correlationID)
+				continue
+			}
+			result[correlationID] = batch[i].ShortURL
+		}
+		pending = next
+	}
+	return nil
+}
+
+// newLink ссылка с алиасом в short_id (без алиаса пустой), пароль сразу превращаем в хэш
+func newLink(originalURL string, opts LinkOptions) (storage.Link, error) {
+	link := storage.Link{
+		ShortURL:    opts.Alias,
+		OriginalURL: originalURL,
+		ExpiresAt:   opts.ExpiresAt,
+		ClicksLeft:  opts.MaxClicks,
+	}
+	if opts.Password != "" {
+		hash, err := hashPassword(opts.Password)
+		if err != nil {
+			return storage.Link{}, err
+		}
+		link.PasswordHash = hash
+	}
+	return link, nil
 }
 
 // Retrieve юзаем стор, чтобы вытащить данные по идентификатору и возвращаем + ok
-// Если ссылка "удалена", вернем ErrURLDeleted
-func (us *URLShortener) Retrieve(id string) (string, error) {
-	url, err := us.store.Load(id)
+// Если ссылка "удалена", вернем ErrURLDeleted, если срок вышел - ErrURLExpired,
+// если переходы кончились - ErrURLExhausted. Это переход по ссылке: лимит тратится
+func (us *URLShortener) Retrieve(ctx context.Context, id string) (string, error) {
+	return us.RetrieveWithPassword(ctx, id, "", "")
+}
+
+// RetrieveWithPassword как Retrieve, но для ссылки под паролем сначала сверяем его:
+// ErrPasswordRequired/ErrPasswordInvalid/ErrTooManyAttempts; переход тратим
+// только после верного пароля. client - адрес клиента, по нему считаем неудачные попытки
+func (us *URLShortener) RetrieveWithPassword(ctx context.Context, id, password, client string) (string, error) {
+	link, err := us.peek(ctx, id, password, client)
 	if err != nil {
 		return "", err
 	}
-	return url, nil
+	if link.ClicksLeft == nil {
+		// без лимита списывать нечего, второй раз в хранилище не ходим
+		return link.OriginalURL, nil
+	}
+
+	link, err = us.store.ConsumeLink(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return link.OriginalURL, nil
+}
+
+// PeekWithPassword как RetrieveWithPassword, но переход не тратит: для HEAD,
+// которым ссылки проверяют превьюшки и мониторинги
+func (us *URLShortener) PeekWithPassword(ctx context.Context, id, password, client string) (string, error) {
+	link, err := us.peek(ctx, id, password, client)
+	if err != nil {
+		return "", err
+	}
+	return link.OriginalURL, nil
+}
+
+// ShortLink полный короткий адрес живой ссылки для QR кода: пароль не спрашиваем и
+// переход не тратим - в код попадает только короткий адрес, пароль спросит редирект
+func (us *URLShortener) ShortLink(ctx context.Context, id, baseURL string) (string, error) {
+	if _, err := us.store.LoadLink(ctx, id); err != nil {
+		return "", err
+	}
+	return baseURL + "/" + id, nil
+}
+
+// peek ссылка и проверка пароля, без списания перехода
+func (us *URLShortener) peek(ctx context.Context, id, password, client string) (storage.Link, error) {
+	link, err := us.store.LoadLink(ctx, id)
+	if err != nil {
+		return storage.Link{}, err
+	}
+	if link.PasswordHash != "" {
+		if err := us.throttle.check(id, client, link.PasswordHash, password); err != nil {
+			return storage.Link{}, err
+		}
+	}
+	return link, nil
 }
 
 // UserURLs возвращает все ссылки по userID
-func (us *URLShortener) UserURLs(userID string, baseURL string) ([]UserURL, error) {
-	urls, err := us.store.GetUserURLs(userID)
+func (us *URLShortener) UserURLs(ctx context.Context, userID string, baseURL string) ([]UserURL, error) {
+	urls, err := us.store.GetUserURLs(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -93,23 +392,45 @@ func (us *URLShortener) UserURLs(userID string, baseURL string) ([]UserURL, erro
 		results = append(results, UserURL{
 			ShortURL:    baseURL + "/" + u.ShortURL,
 			OriginalURL: u.OriginalURL,
+			ExpiresAt:   u.ExpiresAt,
+			ClicksLeft:  u.ClicksLeft,
+			Protected:   u.Protected,
 		})
 	}
 	return results, nil
 }
 
-// generateID рандомный идентификатор, написал тупую функцию
-func generateID() string {
-	const length = 8
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// RestoreResult итог восстановления одной ссылки, порядок как в запросе
+type RestoreResult struct {
+	ID     string                `json:"id"`
+	Status storage.RestoreStatus `json:"status"`
+}
+
+// Restore снимаем удаление со ссылок пользователя, удаленных не раньше чем grace назад
+func (us *URLShortener) Restore(ctx context.Context, userID string, shortIDs []string, grace time.Duration) ([]RestoreResult, error) {
+	statuses, err := us.store.RestoreUserURLs(ctx, userID, shortIDs, time.Now().Add(-grace))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]RestoreResult, 0, len(shortIDs))
+	for _, id := range shortIDs {
+		status, ok := statuses[id]
+		if !ok {
+			status = storage.RestoreNotFound
+		}
+		results = append(results, RestoreResult{ID: id, Status: status})
+	}
+	return results, nil
+}
 
-	// слайс байтов в длину length === 8
-	id := make([]byte, length)
-	for i := range id {
-		// берем случайный индекс
-		randomIndex := rand.Intn(len(charset))
-		// для позиции i ставим символ из charset
-		id[i] = charset[randomIndex]
+// generateID id от генератора, зарезервированные пути ("api", "ping") пропускаем,
+// но не бесконечно - генератор может выдавать только их
+func (us *URLShortener) generateID(ctx context.Context, originalURL string, attempt int) (string, error) {
+	for i := 0; i < us.collisions.maxAttempts; i++ {
+		id, err := us.ids.Generate(ctx, originalURL, attempt+i)
+		if err != nil || !us.aliases.Reserved(id) {
+			return id, err
+		}
 	}
-	return string(id)
+	return "", fmt.Errorf("%w: generator returns only reserved ids", ErrIDSpaceExhausted)
 }
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
+	"github.com/mkukarin01/snort/internal/idgen"
 	"github.com/mkukarin01/snort/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
@@ -14,13 +17,13 @@ func TestURLShortener_ShortenAndRetrieve(t *testing.T) {
 	uid := "foo"
 
 	originalURL := "https://ya.ru"
-	id, _ := shortener.Shorten(originalURL, uid)
+	id, _ := shortener.Shorten(context.Background(), originalURL, uid)
 
-	retrievedURL, foundErr := shortener.Retrieve(id)
+	retrievedURL, foundErr := shortener.Retrieve(context.Background(), id)
 	assert.Nil(t, foundErr)
 	assert.Equal(t, originalURL, retrievedURL)
 
-	nextID, conflict := shortener.Shorten(originalURL, uid)
+	nextID, conflict := shortener.Shorten(context.Background(), originalURL, uid)
 	assert.ErrorIs(t, conflict, storage.ErrURLConflict)
 	assert.Equal(t, id, nextID)
 }
@@ -30,7 +33,7 @@ func TestURLShortener_RetrieveNonExistent(t *testing.T) {
 	store := storage.NewMemoryStorage()
 	shortener := NewURLShortener(store)
 
-	_, foundErr := shortener.Retrieve("nonexistent")
+	_, foundErr := shortener.Retrieve(context.Background(), "nonexistent")
 	assert.ErrorIs(t, foundErr, storage.ErrURLNotFound)
 }
 
@@ -44,7 +47,8 @@ func TestURLShortener_ShortenBatchAndRetrieve(t *testing.T) {
 		"2": "http://github.com",
 	}
 
-	shortened := shortener.ShortenBatch(urls, uid)
+	shortened, err := shortener.ShortenBatch(context.Background(), urls, uid)
+	assert.NoError(t, err)
 
 	assert.Len(t, shortened, len(urls))
 
@@ -52,7 +56,7 @@ func TestURLShortener_ShortenBatchAndRetrieve(t *testing.T) {
 		shortID, exists := shortened[correlationID]
 		assert.True(t, exists, "The correlation ID should exist in shortened URLs")
 
-		retrievedURL, foundErr := shortener.Retrieve(shortID)
+		retrievedURL, foundErr := shortener.Retrieve(context.Background(), shortID)
 		assert.Nil(t, foundErr, "Shortened ID should be retrievable")
 		assert.Equal(t, originalURL, retrievedURL, "Retrieved URL should match original URL")
 	}
@@ -64,10 +68,134 @@ func TestURLShortener_RetrieveUserURLs(t *testing.T) {
 	uid := "baz"
 
 	originalURL := "https://ya.ru"
-	// id, _ := shortener.Shorten(originalURL, uid)
-	shortener.Shorten(originalURL, uid)
+	// id, _ := shortener.Shorten(context.Background(), originalURL, uid)
+	shortener.Shorten(context.Background(), originalURL, uid)
 
-	urls, _ := shortener.UserURLs(uid, "http://bar.foo")
+	urls, _ := shortener.UserURLs(context.Background(), uid, "http://bar.foo")
 
 	assert.Len(t, urls, 1)
 }
+
+// per-user: второй пользователь получает свою ссылку, а не 409 с чужой
+func TestURLShortener_ShortenPerUser(t *testing.T) {
+	store := storage.NewMemoryStorage(storage.WithDedupScope(storage.DedupPerUser))
+	shortener := NewURLShortener(store)
+	ctx := context.Background()
+
+	idA, err := shortener.Shorten(ctx, "https://ya.ru", "userA")
+	assert.NoError(t, err)
+	idB, err := shortener.Shorten(ctx, "https://ya.ru", "userB")
+	assert.NoError(t, err)
+	assert.NotEqual(t, idA, idB)
+
+	again, err := shortener.Shorten(ctx, "https://ya.ru", "userB")
+	assert.ErrorIs(t, err, storage.ErrURLConflict)
+	assert.Equal(t, idB, again)
+
+	urls, err := shortener.UserURLs(ctx, "userB", "http://bar.foo")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+// hash: тот же url у второго пользователя упирается в чужой id и уходит на следующую попытку
+func TestURLShortener_HashIDs(t *testing.T) {
+	store := storage.NewMemoryStorage(storage.WithDedupScope(storage.DedupPerUser))
+	ids, err := idgen.NewHash(6, idgen.Base62)
+	assert.NoError(t, err)
+	shortener := NewURLShortener(store, WithIDGenerator(ids))
+	ctx := context.Background()
+
+	want, _ := ids.Generate(ctx, "https://ya.ru", 0)
+	idA, err := shortener.Shorten(ctx, "https://ya.ru", "userA")
+	assert.NoError(t, err)
+	assert.Equal(t, want, idA)
+
+	idB, err := shortener.Shorten(ctx, "https://ya.ru", "userB")
+	assert.NoError(t, err)
+	assert.NotEqual(t, idA, idB)
+	assert.Len(t, idB, 6)
+}
+
+// hash в батче: чужой id не перезаписываем, а генерируем следующий
+func TestURLShortener_BatchHashIDs(t *testing.T) {
+	store := storage.NewMemoryStorage(storage.WithDedupScope(storage.DedupPerUser))
+	ids, err := idgen.NewHash(6, idgen.Base62)
+	assert.NoError(t, err)
+	shortener := NewURLShortener(store, WithIDGenerator(ids))
+	ctx := context.Background()
+
+	idA, err := shortener.Shorten(ctx, "https://ya.ru", "userA")
+	assert.NoError(t, err)
+
+	shortened, err := shortener.ShortenBatch(ctx, map[string]string{
+		"1": "https://ya.ru",
+		"2": "https://github.com",
+	}, "userB")
+	assert.NoError(t, err)
+	assert.NotEqual(t, idA, shortened["1"])
+
+	for correlationID, want := range map[string]string{"1": "https://ya.ru", "2": "https://github.com"} {
+		url, err := shortener.Retrieve(ctx, shortened[correlationID])
+		assert.NoError(t, err)
+		assert.Equal(t, want, url)
+	}
+	url, err := shortener.Retrieve(ctx, idA)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", url)
+
+	urls, err := shortener.UserURLs(ctx, "userA", "http://bar.foo")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+// батч с уже сокращенным url отдает существующий short_id, а не несохраненный новый
+func TestURLShortener_BatchExistingURL(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]storage.Storager{
+		"global":   storage.NewMemoryStorage(),
+		"per-user": storage.NewMemoryStorage(storage.WithDedupScope(storage.DedupPerUser)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			shortener := NewURLShortener(store)
+			id, err := shortener.Shorten(ctx, "https://ya.ru", "userA")
+			assert.NoError(t, err)
+
+			shortened, err := shortener.ShortenBatch(ctx, map[string]string{
+				"1": "https://ya.ru",
+				"2": "https://github.com",
+				"3": "https://github.com",
+			}, "userA")
+			assert.NoError(t, err)
+			assert.Equal(t, id, shortened["1"])
+			assert.Equal(t, shortened["2"], shortened["3"])
+
+			for correlationID, want := range map[string]string{"1": "https://ya.ru", "2": "https://github.com", "3": "https://github.com"} {
+				url, err := shortener.Retrieve(ctx, shortened[correlationID])
+				assert.NoError(t, err)
+				assert.Equal(t, want, url)
+			}
+			urls, err := shortener.UserURLs(ctx, "userA", "http://bar.foo")
+			assert.NoError(t, err)
+			assert.Len(t, urls, 2)
+		})
+	}
+}
+
+// reservedIDs первым отдает зарезервированный путь
+type reservedIDs struct{ calls int }
+
+func (g *reservedIDs) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	g.calls++
+	if g.calls == 1 {
+		return "ping", nil
+	}
+	return fmt.Sprintf("id%d", g.calls), nil
+}
+
+func TestURLShortener_SkipsReservedIDs(t *testing.T) {
+	shortener := NewURLShortener(storage.NewMemoryStorage(), WithIDGenerator(&reservedIDs{}))
+
+	id, err := shortener.Shorten(context.Background(), "https://ya.ru", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "id2", id)
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CacheOptions настройки кэша редиректов, Size <= 0 - кэш выключен
+type CacheOptions struct {
+	Size        int           // сколько ссылок держим, самые давние вытесняются (LRU)
+	TTL         time.Duration // сколько живет найденная ссылка
+	NegativeTTL time.Duration // сколько помним, что ссылки нет/она удалена, 0 - не помним
+}
+
+// cacheItem запись кэша, err - ErrURLNotFound/ErrURLDeleted/ErrURLExpired/ErrURLExhausted для негативных записей
+type cacheItem struct {
+	id      string
+	link    Link
+	err     error
+	expires time.Time
+}
+
+// cacheCall загрузка одного id, на которую ждут все параллельные запросы
+type cacheCall struct {
+	done  chan struct{}
+	link  Link
+	err   error
+	stale bool // пока грузили, ссылку инвалидировали - результат в кэш не кладем
+}
+
+// cachedStorage read-through кэш над Load/LoadLink, остальное проксируется как есть
+type cachedStorage struct {
+	store Storager
+	opts  CacheOptions
+	now   func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List               // front - самые свежие
+	items map[string]*list.Element // id -> *cacheItem
+	calls map[string]*cacheCall    // загрузки в процессе
+}
+
+// WithCache оборачиваем хранилище LRU кэшем для Load, при Size <= 0 - возвращаем как есть
+func WithCache(store Storager, opts CacheOptions) Storager {
+	if opts.Size <= 0 {
+		return store
+	}
+	return &cachedStorage{
+		store: store,
+		opts:  opts,
+		now:   time.Now,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+		calls: make(map[string]*cacheCall),
+	}
+}
+
+func (cs *cachedStorage) Load(ctx context.Context, id string) (string, error) {
+	link, err := cs.LoadLink(ctx, id)
+	return link.OriginalURL, err
+}
+
+// LoadLink отдаем из кэша, а при промахе грузим один раз на все параллельные запросы
+func (cs *cachedStorage) LoadLink(ctx context.Context, id string) (Link, error) {
+	cs.mu.Lock()
+	if item, ok := cs.get(id); ok {
+		cs.mu.Unlock()
+		return item.link, item.err
+	}
+
+	call, ok := cs.calls[id]
+	if !ok {
+		call = &cacheCall{done: make(chan struct{})}
+		cs.calls[id] = call
+		// грузим без отмены от конкретного запроса: если первый клиент ушел, остальные
+		// все равно ждут результат; дедлайн навешивает WithTimeouts под кэшем
+		go cs.fetch(context.WithoutCancel(ctx), id, call)
+	}
+	cs.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.link, call.err
+	case <-ctx.Done():
+		return Link{}, ctx.Err()
+	}
+}
+
+// ConsumeLink ссылки без лимита отдаем из кэша как есть, с лимитом счетчик живет
+// только в хранилище - туда и идем; исчерпанную ссылку запоминаем негативной записью
+func (cs *cachedStorage) ConsumeLink(ctx context.Context, id string) (Link, error) {
+	link, err := cs.LoadLink(ctx, id)
+	if err != nil || link.ClicksLeft == nil {
+		return link, err
+	}
+
+	link, err = cs.store.ConsumeLink(ctx, id)
+	if err != nil {
+		cs.mu.Lock()
+		cs.put(id, Link{}, err)
+		cs.mu.Unlock()
+	}
+	return link, err
+}
+
+func (cs *cachedStorage) fetch(ctx context.Context, id string, call *cacheCall) {
+	call.link, call.err = cs.store.LoadLink(ctx, id)
+
+	cs.mu.Lock()
+	if cs.calls[id] == call {
+		delete(cs.calls, id)
+	}
+	if !call.stale {
+		cs.put(id, call.link, call.err)
+	}
+	cs.mu.Unlock()
+
+	close(call.done)
+}
+
+// get вызывать под mu
+func (cs *cachedStorage) get(id string) (*cacheItem, bool) {
+	el, ok := cs.items[id]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*cacheItem)
+	if !cs.now().Before(item.expires) {
+		cs.lru.Remove(el)
+		delete(cs.items, id)
+		return nil, false
+	}
+	cs.lru.MoveToFront(el)
+	return item, true
+}
+
+// put кладем результат LoadLink, временные ошибки (таймауты, нет связи) не кэшируем; вызывать под mu
+func (cs *cachedStorage) put(id string, link Link, err error) {
+	ttl := cs.opts.TTL
+	if err != nil {
+		if !isPermanentLoadErr(err) {
+			return
+		}
+		ttl = cs.opts.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	expires := cs.now().Add(ttl)
+	// ссылка с ограниченным сроком не должна пережить его в кэше
+	if link.ExpiresAt != nil && link.ExpiresAt.Before(expires) {
+		expires = *link.ExpiresAt
+	}
+
+	item := &cacheItem{id: id, link: link, err: err, expires: expires}
+	if el, ok := cs.items[id]; ok {
+		el.Value = item
+		cs.lru.MoveToFront(el)
+		return
+	}
+	cs.items[id] = cs.lru.PushFront(item)
+
+	for cs.lru.Len() > cs.opts.Size {
+		oldest := cs.lru.Back()
+		cs.lru.Remove(oldest)
+		delete(cs.items, oldest.Value.(*cacheItem).id)
+	}
+}
+
+// isPermanentLoadErr ошибки, которые не пройдут сами, их можно кэшировать
+func isPermanentLoadErr(err error) bool {
+	return errors.Is(err, ErrURLNotFound) || errors.Is(err, ErrURLDeleted) ||
+		errors.Is(err, ErrURLExpired) || errors.Is(err, ErrURLExhausted)
+}
+
+// invalidate выкидываем id из кэша, а текущие загрузки помечаем устаревшими
+func (cs *cachedStorage) invalidate(ids ...string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, id := range ids {
+		if el, ok := cs.items[id]; ok {
+			cs.lru.Remove(el)
+			delete(cs.items, id)
+		}
+		if call, ok := cs.calls[id]; ok {
+			call.stale = true
+			delete(cs.calls, id)
+		}
+	}
+}
+
+func (cs *cachedStorage) Ping(ctx context.Context) error {
+	return cs.store.Ping(ctx)
+}
+
+func (cs *cachedStorage) Close() error {
+	return cs.store.Close()
+}
+
+// сохранения тоже инвалидируют: иначе негативная запись спрячет только что созданную ссылку
+
+func (cs *cachedStorage) Save(ctx context.Context, id, url string) error {
+	defer cs.invalidate(id)
+	return cs.store.Save(ctx, id, url)
+}
+
+func (cs *cachedStorage) SaveBatch(ctx context.Context, urls map[string]string) error {
+	defer cs.invalidate(mapKeys(urls)...)
+	return cs.store.SaveBatch(ctx, urls)
+}
+
+func (cs *cachedStorage) NextSequence(ctx context.Context) (int64, error) {
+	return cs.store.NextSequence(ctx)
+}
+
+func (cs *cachedStorage) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
+	return cs.store.FindIDByURL(ctx, userID, url)
+}
+
+func (cs *cachedStorage) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	defer cs.invalidate(shortID)
+	return cs.store.SaveUserURL(ctx, userID, shortID, originalURL)
+}
+
+func (cs *cachedStorage) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
+	defer cs.invalidate(mapKeys(batch)...)
+	return cs.store.SaveBatchUserURLs(ctx, userID, batch)
+}
+
+func (cs *cachedStorage) SaveLink(ctx context.Context, userID string, link Link) error {
+	defer cs.invalidate(link.ShortURL)
+	return cs.store.SaveLink(ctx, userID, link)
+}
+
+func (cs *cachedStorage) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		ids = append(ids, link.ShortURL)
+	}
+	defer cs.invalidate(ids...)
+	return cs.store.SaveBatchLinks(ctx, userID, links)
+}
+
+func (cs *cachedStorage) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
+	return cs.store.GetUserURLs(ctx, userID)
+}
+
+func (cs *cachedStorage) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
+	defer cs.invalidate(shortIDs...)
+	return cs.store.MarkUserURLsDeleted(ctx, userID, shortIDs)
+}
+
+// RestoreUserURLs без сброса восстановленная ссылка отдавала бы 410 из кэша
+func (cs *cachedStorage) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	defer cs.invalidate(shortIDs...)
+	return cs.store.RestoreUserURLs(ctx, userID, shortIDs, since)
+}
+
+// PurgeDeleted кэш не трогаем: удаленные id и так закэшированы как ErrURLDeleted не дольше
+// NegativeTTL, а если id займут заново - его сбросит сохранение
+func (cs *cachedStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return cs.store.PurgeDeleted(ctx, before)
+}
+
+// переходы в кэше не держим, статистика всегда из хранилища
+func (cs *cachedStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	return cs.store.SaveClicks(ctx, clicks)
+}
+
+func (cs *cachedStorage) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	return cs.store.ClickStats(ctx, userID, shortID, q)
+}
+
+func (cs *cachedStorage) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return cs.store.RollupClicks(ctx, before, limit)
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
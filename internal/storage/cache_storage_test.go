@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache(t *testing.T, store Storager, opts CacheOptions) *cachedStorage {
+	cs, ok := WithCache(store, opts).(*cachedStorage)
+	require.True(t, ok)
+	return cs
+}
+
+// TestCachedStorage_Hit - повторный редирект не ходит в хранилище
+func TestCachedStorage_Hit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "id1").Return(Link{ShortURL: "id1", OriginalURL: "http://ya.ru"}, nil).Times(1)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		url, err := cs.Load(context.Background(), "id1")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://ya.ru", url)
+	}
+}
+
+// TestCachedStorage_Negative - несуществующие id тоже кэшируем, временные ошибки - нет
+func TestCachedStorage_Negative(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "missing").Return(Link{}, ErrURLNotFound).Times(1)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "flaky").Return(Link{}, ErrDBConnection).Times(2)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		_, err := cs.Load(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		_, err = cs.Load(context.Background(), "flaky")
+		assert.ErrorIs(t, err, ErrDBConnection)
+	}
+}
+
+// TestCachedStorage_TTL - протухшая запись грузится заново
+func TestCachedStorage_TTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "id1").Return(Link{ShortURL: "id1", OriginalURL: "http://ya.ru"}, nil).Times(2)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute})
+	now := time.Now()
+	cs.now = func() time.Time { return now }
+
+	_, err := cs.Load(context.Background(), "id1")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = cs.Load(context.Background(), "id1")
+	require.NoError(t, err)
+}
+
+// TestCachedStorage_LinkExpiry - запись живет не дольше срока самой ссылки
+func TestCachedStorage_LinkExpiry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+	mockDB := NewMockStorager(ctrl)
+	gomock.InOrder(
+		mockDB.EXPECT().LoadLink(gomock.Any(), "promo").Return(Link{ShortURL: "promo", OriginalURL: "http://ya.ru", ExpiresAt: &expiresAt}, nil),
+		mockDB.EXPECT().LoadLink(gomock.Any(), "promo").Return(Link{}, ErrURLExpired),
+	)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Hour, NegativeTTL: time.Hour})
+	cs.now = func() time.Time { return now }
+
+	url, err := cs.Load(context.Background(), "promo")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+
+	now = now.Add(2 * time.Minute)
+	_, err = cs.Load(context.Background(), "promo")
+	assert.ErrorIs(t, err, ErrURLExpired)
+	_, err = cs.Load(context.Background(), "promo")
+	assert.ErrorIs(t, err, ErrURLExpired)
+}
+
+// TestCachedStorage_ConsumeLink - без лимита переход из кэша, с лимитом всегда в хранилище
+func TestCachedStorage_ConsumeLink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	one, zero := int64(1), int64(0)
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "free").Return(Link{ShortURL: "free", OriginalURL: "http://free.ru"}, nil)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "once").Return(Link{ShortURL: "once", OriginalURL: "http://once.ru", ClicksLeft: &one}, nil)
+	gomock.InOrder(
+		mockDB.EXPECT().ConsumeLink(gomock.Any(), "once").Return(Link{ShortURL: "once", OriginalURL: "http://once.ru", ClicksLeft: &zero}, nil),
+		mockDB.EXPECT().ConsumeLink(gomock.Any(), "once").Return(Link{}, ErrURLExhausted),
+	)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		link, err := cs.ConsumeLink(ctx, "free")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://free.ru", link.OriginalURL)
+	}
+
+	link, err := cs.ConsumeLink(ctx, "once")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, *link.ClicksLeft)
+	_, err = cs.ConsumeLink(ctx, "once")
+	assert.ErrorIs(t, err, ErrURLExhausted)
+	// исчерпанная ссылка осела негативной записью, хранилище больше не дергаем
+	_, err = cs.ConsumeLink(ctx, "once")
+	assert.ErrorIs(t, err, ErrURLExhausted)
+}
+
+// TestCachedStorage_Evict - сверх размера вытесняется самая давняя ссылка
+func TestCachedStorage_Evict(t *testing.T) {
+	ms := NewMemoryStorage()
+	ctx := context.Background()
+	cs := newTestCache(t, ms, CacheOptions{Size: 2, TTL: time.Minute})
+
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, ms.Save(ctx, id, "http://ya.ru/"+id))
+		_, err := cs.Load(ctx, id)
+		require.NoError(t, err)
+	}
+	// a свежее b
+	_, err := cs.Load(ctx, "a")
+	require.NoError(t, err)
+
+	require.NoError(t, ms.Save(ctx, "c", "http://ya.ru/c"))
+	_, err = cs.Load(ctx, "c")
+	require.NoError(t, err)
+
+	assert.Len(t, cs.items, 2)
+	assert.Contains(t, cs.items, "a")
+	assert.Contains(t, cs.items, "c")
+}
+
+// TestCachedStorage_Invalidate - удаление и сохранение сбрасывают кэш
+func TestCachedStorage_Invalidate(t *testing.T) {
+	ms := NewMemoryStorage()
+	ctx := context.Background()
+	cs := newTestCache(t, ms, CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+
+	// негативная запись не прячет свежесозданную ссылку
+	_, err := cs.Load(ctx, "id1")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+	require.NoError(t, cs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+
+	url, err := cs.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+
+	require.NoError(t, cs.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+	_, err = cs.Load(ctx, "id1")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+}
+
+// TestCachedStorage_Restore - закэшированный 410 не должен пережить восстановление
+func TestCachedStorage_Restore(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryStorage()
+	require.NoError(t, ms.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, ms.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+
+	cs := WithCache(ms, CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+	_, err := cs.Load(ctx, "id1")
+	require.ErrorIs(t, err, ErrURLDeleted)
+
+	_, err = cs.RestoreUserURLs(ctx, "u1", []string{"id1"}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+
+	url, err := cs.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+}
+
+// TestCachedStorage_Collapse - параллельные запросы одного id дают один поход в хранилище
+func TestCachedStorage_Collapse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	release := make(chan struct{})
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "hot").DoAndReturn(func(ctx context.Context, id string) (Link, error) {
+		<-release
+		return Link{ShortURL: id, OriginalURL: "http://ya.ru"}, nil
+	}).Times(1)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := cs.Load(context.Background(), "hot")
+			assert.NoError(t, err)
+			assert.Equal(t, "http://ya.ru", url)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+// TestCachedStorage_CallerCanceled - ушедший клиент не ломает загрузку остальным
+func TestCachedStorage_CallerCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	release := make(chan struct{})
+	mockDB := NewMockStorager(ctrl)
+	mockDB.EXPECT().LoadLink(gomock.Any(), "hot").DoAndReturn(func(ctx context.Context, id string) (Link, error) {
+		<-release
+		return Link{ShortURL: id, OriginalURL: "http://ya.ru"}, ctx.Err()
+	}).Times(1)
+
+	cs := newTestCache(t, mockDB, CacheOptions{Size: 10, TTL: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cs.Load(ctx, "hot")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	url, err := cs.Load(context.Background(), "hot")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+}
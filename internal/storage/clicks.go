@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/hll"
+)
+
+// Click переход по ссылке для статистики владельцу
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	At        time.Time `json:"at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// IPHash HMAC от IP клиента, сам IP не храним
+	IPHash string `json:"ip_hash,omitempty"`
+	// Bot почему переход посчитан ботом (bots.Reason), пустой - человек
+	Bot string `json:"bot,omitempty"`
+	// Country и Region по IP из GeoIP базы (ISO 3166), пустые - не знаем
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+}
+
+// UnknownCountry переходы, страну которых не определили
+const UnknownCountry = "(unknown)"
+
+// clickCountry страна перехода для разбивки
+func clickCountry(c Click) string {
+	if c.Country == "" {
+		return UnknownCountry
+	}
+	return c.Country
+}
+
+// BucketUnit шаг разбивки статистики по времени, границы считаем в UTC
+type BucketUnit string
+
+const (
+	BucketHour  BucketUnit = "hour"
+	BucketDay   BucketUnit = "day"
+	BucketWeek  BucketUnit = "week"
+	BucketMonth BucketUnit = "month"
+)
+
+// ParseBucketUnit "hour"/"day"/"week"/"month", пустая строка - по дням
+func ParseBucketUnit(s string) (BucketUnit, error) {
+	switch u := BucketUnit(s); u {
+	case "":
+		return BucketDay, nil
+	case BucketHour, BucketDay, BucketWeek, BucketMonth:
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown bucket %q: want hour, day, week or month", s)
+}
+
+// Truncate начало отрезка, в который попадает t; неделя с понедельника, как
+// date_trunc('week') в postgres
+func (u BucketUnit) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch u {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketWeek:
+		day := BucketDay.Truncate(t)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	// нулевое время в Go - полночь UTC, так что Truncate по суткам режет ровно по границам UTC
+	return t.Truncate(24 * time.Hour)
+}
+
+// Next начало следующего отрезка после start; месяцы разной длины, поэтому
+// не Duration, а календарный шаг
+func (u BucketUnit) Next(start time.Time) time.Time {
+	switch u {
+	case BucketHour:
+		return start.Add(time.Hour)
+	case BucketWeek:
+		return start.AddDate(0, 0, 7)
+	case BucketMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// ClickQuery какой отрезок времени и с каким шагом считать, [From, To)
+type ClickQuery struct {
+	From   time.Time
+	To     time.Time
+	Bucket BucketUnit
+}
+
+// ClickBucket переходы за отрезок [Start, Start+шаг)
+type ClickBucket struct {
+	Start  time.Time
+	Clicks int64
+}
+
+// DayVisitors скетч уникальных посетителей ссылки за сутки [Day, Day+1d) UTC
+type DayVisitors struct {
+	Day      time.Time
+	Visitors *hll.Sketch
+}
+
+// ClickStats Total - переходы людей за все время, BotTotal - ботов, вместе со свернутыми
+// в суточные агрегаты; Buckets - только непустые отрезки из запроса и только люди, по порядку;
+// свернутые сутки часов не помнят, в разбивку по часам они не попадают.
+// Visitors - непустые суточные скетчи за сутки, пересекающие [From, To), тоже по порядку:
+// недели и месяцы из них собирает сервис, часовых скетчей не держим.
+// Referrers, UserAgents и Countries - переходы людей за те же сутки по хостам рефереров,
+// семействам UA и странам
+type ClickStats struct {
+	Total      int64
+	BotTotal   int64
+	Buckets    []ClickBucket
+	Visitors   []DayVisitors
+	Referrers  map[string]int64
+	UserAgents map[string]int64
+	Countries  map[string]int64
+}
+
+// visitorKey что кладем в скетч: хэш IP, а без него (IP не узнали) - хэш UA,
+// иначе такие переходы вовсе пропали бы из уникальных; боты в уникальные не идут - nil
+func visitorKey(c Click) []byte {
+	if c.Bot != "" {
+		return nil
+	}
+	if c.IPHash != "" {
+		if b, err := hex.DecodeString(c.IPHash); err == nil {
+			return b
+		}
+		return []byte(c.IPHash)
+	}
+	if c.UserAgent != "" {
+		return []byte("ua:" + c.UserAgent)
+	}
+	return nil
+}
+
+// clickLog переходы в памяти для mem и file бэкендов: события, суточные скетчи
+// уникальных и свернутые сутки, short_id -> ...; своего лока нет, защищает владелец
+type clickLog struct {
+	events map[string][]Click
+	// visitors short_id -> начало суток в секундах -> скетч
+	visitors map[string]map[int64]*hll.Sketch
+	// rollups short_id -> начало суток в секундах -> агрегат
+	rollups map[string]map[int64]*ClickRollup
+}
+
+func newClickLog() clickLog {
+	return clickLog{
+		events:   make(map[string][]Click),
+		visitors: make(map[string]map[int64]*hll.Sketch),
+		rollups:  make(map[string]map[int64]*ClickRollup),
+	}
+}
+
+func (cl clickLog) add(clicks []Click) {
+	for _, c := range clicks {
+		cl.events[c.ShortURL] = append(cl.events[c.ShortURL], c)
+		if key := visitorKey(c); key != nil {
+			cl.daySketch(c.ShortURL, BucketDay.Truncate(c.At).Unix()).Add(key)
+		}
+	}
+}
+
+func (cl clickLog) daySketch(shortID string, day int64) *hll.Sketch {
+	days := cl.visitors[shortID]
+	if days == nil {
+		days = make(map[int64]*hll.Sketch)
+		cl.visitors[shortID] = days
+	}
+	if days[day] == nil {
+		days[day] = hll.New()
+	}
+	return days[day]
+}
+
+// addRollup сливаем агрегат с уже свернутыми сутками; скетч сразу и в уникальные -
+// после рестарта file бэкенда сырых событий за эти сутки уже нет
+func (cl clickLog) addRollup(r *ClickRollup) {
+	days := cl.rollups[r.ShortURL]
+	if days == nil {
+		days = make(map[int64]*ClickRollup)
+		cl.rollups[r.ShortURL] = days
+	}
+	day := r.Day.Unix()
+	if days[day] == nil {
+		days[day] = newClickRollup(r.ShortURL, r.Day)
+	}
+	days[day].merge(r)
+	days[day].trim()
+	if r.Visitors != nil {
+		_ = cl.daySketch(r.ShortURL, day).Merge(r.Visitors)
+	}
+}
+
+// rollup сворачиваем события старше before; ссылки берем целиком, пока не наберем
+// limit событий (limit <= 0 - без ограничения); вернем сколько свернули
+func (cl clickLog) rollup(before time.Time, limit int) int64 {
+	var rolled int64
+	for shortID, events := range cl.events {
+		if limit > 0 && rolled >= int64(limit) {
+			break
+		}
+		var old, keep []Click
+		for _, c := range events {
+			if c.At.Before(before) {
+				old = append(old, c)
+			} else {
+				keep = append(keep, c)
+			}
+		}
+		if len(old) == 0 {
+			continue
+		}
+		keys, rollups := rollupClicks(old)
+		for _, k := range keys {
+			cl.addRollup(rollups[k])
+		}
+		if len(keep) == 0 {
+			delete(cl.events, shortID)
+		} else {
+			cl.events[shortID] = keep
+		}
+		rolled += int64(len(old))
+	}
+	return rolled
+}
+
+// allRollups все агрегаты, для перезаписи файла
+func (cl clickLog) allRollups() []*ClickRollup {
+	var all []*ClickRollup
+	for _, days := range cl.rollups {
+		for _, r := range days {
+			all = append(all, r)
+		}
+	}
+	return all
+}
+
+// drop забываем ссылку целиком, true - было что забывать
+func (cl clickLog) drop(shortID string) bool {
+	_, hasEvents := cl.events[shortID]
+	_, hasRollups := cl.rollups[shortID]
+	delete(cl.events, shortID)
+	delete(cl.visitors, shortID)
+	delete(cl.rollups, shortID)
+	return hasEvents || hasRollups
+}
+
+// stats считаем по всем событиям ссылки, порядок событий не важен;
+// скетчи отдаем копиями, чтобы сервис складывал их уже без лока
+func (cl clickLog) stats(shortID string, q ClickQuery) ClickStats {
+	events := cl.events[shortID]
+	counts := make(map[time.Time]int64)
+	stats := ClickStats{
+		Referrers:  make(map[string]int64),
+		UserAgents: make(map[string]int64),
+		Countries:  make(map[string]int64),
+	}
+	from := BucketDay.Truncate(q.From)
+	for _, c := range events {
+		if c.Bot != "" {
+			stats.BotTotal++
+			continue
+		}
+		stats.Total++
+		if c.At.Before(from) || !c.At.Before(q.To) {
+			continue
+		}
+		// топы - за те же сутки, что и уникальные
+		stats.Referrers[referrerHost(c.Referrer)]++
+		stats.UserAgents[userAgentFamily(c.UserAgent)]++
+		stats.Countries[clickCountry(c)]++
+		if c.At.Before(q.From) {
+			continue
+		}
+		counts[q.Bucket.Truncate(c.At)]++
+	}
+	for _, r := range cl.rollups[shortID] {
+		stats.Total += r.Clicks
+		stats.BotTotal += r.BotClicks
+		if r.Day.Before(from) || !r.Day.Before(q.To) {
+			continue
+		}
+		addCounts(&stats.Referrers, r.Referrers)
+		addCounts(&stats.UserAgents, r.UserAgents)
+		addCounts(&stats.Countries, r.Countries)
+		if q.Bucket != BucketHour && !r.Day.Before(q.From) {
+			counts[q.Bucket.Truncate(r.Day)] += r.Clicks
+		}
+	}
+
+	for start, n := range counts {
+		stats.Buckets = append(stats.Buckets, ClickBucket{Start: start, Clicks: n})
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool { return stats.Buckets[i].Start.Before(stats.Buckets[j].Start) })
+
+	for day, sketch := range cl.visitors[shortID] {
+		start := time.Unix(day, 0).UTC()
+		if start.Before(from) || !start.Before(q.To) {
+			continue
+		}
+		stats.Visitors = append(stats.Visitors, DayVisitors{Day: start, Visitors: sketch.Clone()})
+	}
+	sort.Slice(stats.Visitors, func(i, j int) bool { return stats.Visitors[i].Day.Before(stats.Visitors[j].Day) })
+	return stats
+}
@@ -1,22 +1,41 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
+
+	"github.com/mkukarin01/snort/internal/config"
+	"github.com/mkukarin01/snort/internal/hll"
 )
 
+func init() {
+	// DSN в виде url отдаем pq как есть
+	openPostgres := func(location string, cfg *config.Config, opts ...Option) (Storager, error) {
+		return NewDatabase(location, cfg.AutoMigrate, opts...)
+	}
+	Register("postgres", openPostgres)
+	Register("postgresql", openPostgres)
+}
+
 // Database реализация хранилища в бд
 type Database struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *replicaSet // nil - всё читаем с primary
+	dedup    DedupScope
 }
 
 // NewDatabase запускатор соединения с pg или БД
-func NewDatabase(dsn string) (*Database, error) {
+// autoMigrate - накатить недостающие миграции, иначе только сверяем версию схемы
+func NewDatabase(dsn string, autoMigrate bool, opts ...Option) (*Database, error) {
 	if dsn == "" {
 		return nil, fmt.Errorf("DSN is empty")
 	}
@@ -32,33 +51,53 @@ func NewDatabase(dsn string) (*Database, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// создаем таблицу с уникальными полями short_id и original_url + user_id + is_deleted
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS urls (
-			id SERIAL PRIMARY KEY,
-			short_id VARCHAR(8) UNIQUE NOT NULL,
-			original_url TEXT UNIQUE NOT NULL,
-			user_id TEXT NOT NULL,
-			is_deleted BOOLEAN NOT NULL DEFAULT false
-		)
-	`)
+	// схема живет в migrations/, таблица urls создается первой миграцией
+	if err := prepareSchema(db, autoMigrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	d := &Database{db: db, dedup: o.dedup}
+	if len(o.replicas) > 0 {
+		if d.replicas, err = newReplicaSet(o.replicas); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open replicas: %w", err)
+		}
+		d.replicas.run()
+	}
+	return d, nil
+}
+
+// prepareSchema приводим схему к версии бинаря или отказываемся стартовать
+func prepareSchema(db *sql.DB, autoMigrate bool) error {
+	ctx := context.Background()
+
+	migrator, err := NewMigrator(db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+		return err
 	}
 
-	return &Database{db: db}, nil
+	if !autoMigrate {
+		return migrator.Check(ctx)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
 }
 
 // -- методы из старого интерфейса --
 
 // Ping - проверка соединения с бд
-func (d *Database) Ping() error {
+func (d *Database) Ping(ctx context.Context) error {
 	// для нул базы - вернем ошибку
 	if d == nil || d.db == nil {
 		return ErrDBConnection
 	}
 
-	return d.db.Ping()
+	return d.db.PingContext(ctx)
 }
 
 // Close - закрываем соединения бд
@@ -69,89 +108,225 @@ func (d *Database) Close() error {
 		return errors.New("database connection is already closed or uninitialized")
 	}
 
+	if d.replicas != nil {
+		if err := d.replicas.close(); err != nil {
+			log.Printf("DB Error: failed to close replicas: %v", err)
+		}
+	}
 	return d.db.Close()
 }
 
 // Save - старый метод сохранения, подкинем пустой uid
-func (d *Database) Save(id, url string) error {
-	return d.SaveUserURL("", id, url)
+func (d *Database) Save(ctx context.Context, id, url string) error {
+	return d.SaveUserURL(ctx, "", id, url)
 }
 
 // SaveBatch - старый метод сохранения пачки, подкинем так же uid === ""
-func (d *Database) SaveBatch(urls map[string]string) error {
-	return d.SaveBatchUserURLs("", urls)
+func (d *Database) SaveBatch(ctx context.Context, urls map[string]string) error {
+	return d.SaveBatchUserURLs(ctx, "", urls)
+}
+
+// Load - загружаем ссылку по short_id, проверяем флаг удаления и срок жизни
+func (d *Database) Load(ctx context.Context, id string) (string, error) {
+	link, err := d.LoadLink(ctx, id)
+	return link.OriginalURL, err
 }
 
-// Load - загружаем ссылку по short_id, проверяем флаг удаления
-func (d *Database) Load(id string) (string, error) {
+// LoadLink - ссылка целиком, читаем с реплики если есть
+func (d *Database) LoadLink(ctx context.Context, id string) (Link, error) {
 	if d == nil || d.db == nil {
-		return "", ErrDBConnection
+		return Link{}, ErrDBConnection
+	}
+
+	link := Link{ShortURL: id}
+	err := d.read(ctx, func(db *sql.DB) error {
+		var (
+			isDeleted  bool
+			expiresAt  sql.NullTime
+			clicksLeft sql.NullInt64
+			password   sql.NullString
+		)
+		err := db.QueryRowContext(ctx, `
+			SELECT original_url, is_deleted, expires_at, clicks_left, password_hash
+			FROM urls
+			WHERE short_id = $1
+		`, id).Scan(&link.OriginalURL, &isDeleted, &expiresAt, &clicksLeft, &password)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrURLNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if isDeleted {
+			return ErrURLDeleted
+		}
+		link.ExpiresAt = nullTime(expiresAt)
+		link.ClicksLeft = nullInt64(clicksLeft)
+		link.PasswordHash = password.String
+		return nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrURLNotFound), errors.Is(err, ErrURLDeleted):
+		return Link{}, err
+	default:
+		return Link{}, ctxErr(ctx, err)
+	}
+
+	// время берем свое, а не now() базы - так же как в остальных бэкендах
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// ConsumeLink - ссылки без лимита просто читаем (с реплики), с лимитом списываем
+// одним UPDATE на primary: строка блокируется, и условие clicks_left > 0
+// перепроверяется после ожидания, так что лишний переход не проскочит
+func (d *Database) ConsumeLink(ctx context.Context, id string) (Link, error) {
+	link, err := d.LoadLink(ctx, id)
+	if err != nil || link.ClicksLeft == nil {
+		return link, err
 	}
 
 	var (
-		url       string
-		isDeleted bool
+		expiresAt  sql.NullTime
+		clicksLeft int64
 	)
-
-	err := d.db.QueryRow(`
-		SELECT original_url, is_deleted
-		FROM urls
+	err = d.db.QueryRowContext(ctx, `
+		UPDATE urls SET clicks_left = clicks_left - 1
 		WHERE short_id = $1
-	`, id).Scan(&url, &isDeleted)
-
+		  AND is_deleted = false
+		  AND clicks_left > 0
+		  AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING original_url, expires_at, clicks_left
+	`, id, time.Now()).Scan(&link.OriginalURL, &expiresAt, &clicksLeft)
 	if errors.Is(err, sql.ErrNoRows) {
-		return "", ErrURLNotFound
+		// не списали - пока ждали, ссылку исчерпали/удалили, причину читаем с primary
+		link, err = d.LoadLink(WithPrimary(ctx), id)
+		switch {
+		case err != nil:
+			return Link{}, err
+		case link.ClicksLeft == nil:
+			// лимит сняли перезаписью ссылки - отдаем как есть
+			return link, nil
+		default:
+			// ссылку перезаписали с новым лимитом ровно между запросами - лучше
+			// отказать, чем пропустить переход без списания
+			return Link{}, ErrURLExhausted
+		}
 	}
 	if err != nil {
-		return "", err
+		log.Printf("DB Error: ConsumeLink shortID=%s, err=%v", id, err)
+		return Link{}, ctxErr(ctx, err)
 	}
-	if isDeleted {
-		return "", ErrURLDeleted
+	link.ExpiresAt = nullTime(expiresAt)
+	link.ClicksLeft = &clicksLeft
+	return link, nil
+}
+
+// nullTime NULL -> nil
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
 	}
+	return &t.Time
+}
+
+// nullString "" -> NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
 
-	return url, nil
+// nullInt64 NULL -> nil
+func nullInt64(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
 }
 
-// FindIDByURL находит short_id по original_url
-func (d *Database) FindIDByURL(url string) (string, error) {
+// FindIDByURL находит short_id по original_url (и userID, если дедупликация не глобальная)
+func (d *Database) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
 	if d == nil || d.db == nil {
 		return "", ErrDBConnection
 	}
 
+	filter, args := d.urlFilter(userID, url)
+
 	var shortID string
-	err := d.db.QueryRow("SELECT short_id FROM urls WHERE original_url = $1", url).Scan(&shortID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return "", ErrURLNotFound
+	err := d.read(ctx, func(db *sql.DB) error {
+		err := db.QueryRowContext(ctx, `SELECT short_id FROM urls WHERE `+filter+` ORDER BY id LIMIT 1`, args...).Scan(&shortID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrURLNotFound
+		}
+		return err
+	})
+	if errors.Is(err, ErrURLNotFound) {
+		return "", err
 	}
 	if err != nil {
-		return "", err
+		return "", ctxErr(ctx, err)
 	}
 
 	return shortID, nil
 }
 
 // -- методы с юид --
-// NOTE: SaveUserURL и SaveBatchUserURLs - используют обычный лог, потому что мне лень доработать логгер
+// NOTE: SaveLink и SaveBatchLinks - используют обычный лог, потому что мне лень доработать логгер
 
 // SaveUserURL - сохраняемся с uid
-func (d *Database) SaveUserURL(userID, shortID, originalURL string) error {
+func (d *Database) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	return d.SaveLink(ctx, userID, Link{ShortURL: shortID, OriginalURL: originalURL})
+}
+
+// SaveBatchUserURLs - сохраняемся пачкой с uid
+func (d *Database) SaveBatchUserURLs(ctx context.Context, userID string, urls map[string]string) error {
+	return d.SaveBatchLinks(ctx, userID, linksFromMap(urls))
+}
+
+// SaveLink - сохраняем ссылку со свойствами
+// уникальность url держится не констрейнтом, а проверкой под advisory lock по ключу dedup
+func (d *Database) SaveLink(ctx context.Context, userID string, link Link) error {
+	shortID, originalURL := link.ShortURL, link.OriginalURL
 	if d == nil || d.db == nil {
 		return errors.New("database connection is nil")
 	}
 
-	_, err := d.db.Exec(`
-		INSERT INTO urls (short_id, original_url, user_id) 
-		VALUES ($1, $2, $3)
-	`, shortID, originalURL, userID)
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("DB Error: can't begin transaction for userID=%s: %v", userID, err)
+		return ctxErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if d.dedup != DedupNone {
+		if err := d.lockURLs(ctx, tx, d.dedup.key(userID, originalURL)); err != nil {
+			return err
+		}
+
+		filter, args := d.urlFilter(userID, originalURL)
+		var existID string
+		err := tx.QueryRowContext(ctx, `SELECT short_id FROM urls WHERE `+filter+` LIMIT 1`, args...).Scan(&existID)
+		if err == nil && existID != shortID {
+			return ErrURLConflict
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to check url conflict: %w", ctxErr(ctx, err))
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO urls (short_id, original_url, user_id, expires_at, clicks_left, password_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, shortID, originalURL, userID, link.ExpiresAt, link.ClicksLeft, nullString(link.PasswordHash))
 
 	if err != nil {
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) {
 			switch pqErr.Code {
 			case pgerrcode.UniqueViolation:
-				if pqErr.Constraint == "urls_original_url_key" { // unique для original_url
-					return ErrURLConflict
-				}
 				if pqErr.Constraint == "urls_short_id_key" { // unique для short_id
 					return ErrShortIDConflict
 				}
@@ -160,7 +335,12 @@ func (d *Database) SaveUserURL(userID, shortID, originalURL string) error {
 
 		log.Printf("DB Error: can't insert shortID=%s, originalURL=%s, userID=%s: %v",
 			shortID, originalURL, userID, err)
-		return fmt.Errorf("failed to insert userURL: %w", err)
+		return fmt.Errorf("failed to insert userURL: %w", ctxErr(ctx, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("DB Error: can't commit transaction for userID=%s: %v", userID, err)
+		return ctxErr(ctx, err)
 	}
 
 	log.Printf("DB Info: successfully inserted shortID=%s, originalURL=%s, userID=%s",
@@ -169,83 +349,203 @@ func (d *Database) SaveUserURL(userID, shortID, originalURL string) error {
 	return nil
 }
 
-// SaveBatchUserURLs - сохраняем пачку с uid
-func (d *Database) SaveBatchUserURLs(userID string, urls map[string]string) error {
+// SaveBatchLinks - сохраняем пачку с uid
+// ссылки, чей url уже занят (в пределах dedup) или чей short_id занят другой ссылкой,
+// не вставляем и возвращаем в BatchConflictError
+func (d *Database) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
 	if d == nil || d.db == nil {
 		return errors.New("database connection is nil")
 	}
 
-	tx, err := d.db.Begin()
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("DB Error: can't begin transaction for userID=%s: %v", userID, err)
-		return err
+		return ctxErr(ctx, err)
 	}
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO urls (short_id, original_url, user_id) 
-		VALUES ($1, $2, $3)
-		ON CONFLICT DO NOTHING
-	`)
+	query := `
+		INSERT INTO urls (short_id, original_url, user_id, expires_at, clicks_left, password_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (short_id) DO NOTHING
+	`
+	if d.dedup != DedupNone {
+		keys := make([]string, 0, len(links))
+		for _, link := range links {
+			keys = append(keys, d.dedup.key(userID, link.OriginalURL))
+		}
+		if err := d.lockURLs(ctx, tx, keys...); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		filter := "original_url = $2"
+		if d.dedup == DedupPerUser {
+			filter += " AND user_id = $3"
+		}
+		query = `
+			INSERT INTO urls (short_id, original_url, user_id, expires_at, clicks_left, password_hash)
+			SELECT $1, $2, $3, $4::timestamptz, $5::bigint, $6::text
+			WHERE NOT EXISTS (SELECT 1 FROM urls WHERE ` + filter + `)
+			ON CONFLICT (short_id) DO NOTHING
+		`
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
 		tx.Rollback()
 		log.Printf("DB Error: can't prepare statement for userID=%s: %v", userID, err)
-		return err
+		return ctxErr(ctx, err)
 	}
 	defer stmt.Close()
 
-	for shortID, originalURL := range urls {
-		_, execErr := stmt.Exec(shortID, originalURL, userID)
+	var conflicts []int
+	existing := make(map[int]string)
+	for i, link := range links {
+		res, execErr := stmt.ExecContext(ctx, link.ShortURL, link.OriginalURL, userID, link.ExpiresAt, link.ClicksLeft, nullString(link.PasswordHash))
 		if execErr != nil {
 			tx.Rollback()
 			log.Printf("DB Error: can't insert shortID=%s, originalURL=%s, userID=%s: %v",
-				shortID, originalURL, userID, execErr)
-			return fmt.Errorf("failed batch insert: %w", execErr)
+				link.ShortURL, link.OriginalURL, userID, execErr)
+			return fmt.Errorf("failed batch insert: %w", ctxErr(ctx, execErr))
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			continue
+		}
+		// не вставилась: либо url уже занят, либо short_id - и тогда важно, кем
+		if d.dedup != DedupNone {
+			existID, err := d.existingShortID(ctx, tx, userID, link.OriginalURL)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if existID != "" && existID != link.ShortURL {
+				existing[i] = existID
+				continue
+			}
+		}
+		taken, err := d.shortIDTaken(ctx, tx, userID, link)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if taken {
+			conflicts = append(conflicts, i)
 		}
 	}
 
 	if commitErr := tx.Commit(); commitErr != nil {
 		log.Printf("DB Error: can't commit transaction for userID=%s: %v", userID, commitErr)
-		return commitErr
+		return ctxErr(ctx, commitErr)
 	}
 
-	log.Printf("DB Info: successfully inserted batch of %d URLs for userID=%s", len(urls), userID)
+	log.Printf("DB Info: successfully inserted batch of %d URLs for userID=%s", len(links)-len(conflicts)-len(existing), userID)
+	return batchConflict(conflicts, existing)
+}
+
+// existingShortID short_id, под которым url уже сохранен (в пределах dedup), "" - нет такого;
+// в той же транзакции, чтобы видеть и вставленное раньше в этом батче
+func (d *Database) existingShortID(ctx context.Context, tx *sql.Tx, userID, url string) (string, error) {
+	filter, args := d.urlFilter(userID, url)
+
+	var shortID string
+	err := tx.QueryRowContext(ctx, `SELECT short_id FROM urls WHERE `+filter+` ORDER BY id LIMIT 1`, args...).Scan(&shortID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find existing url: %w", ctxErr(ctx, err))
+	}
+	return shortID, nil
+}
+
+// shortIDTaken short_id ссылки занят другой ссылкой (чужой или с другим url);
+// свободный или та же ссылка того же пользователя - не конфликт
+func (d *Database) shortIDTaken(ctx context.Context, tx *sql.Tx, userID string, link Link) (bool, error) {
+	var originalURL, owner string
+	err := tx.QueryRowContext(ctx, `SELECT original_url, user_id FROM urls WHERE short_id = $1`, link.ShortURL).Scan(&originalURL, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check short_id conflict: %w", ctxErr(ctx, err))
+	}
+	return originalURL != link.OriginalURL || owner != userID, nil
+}
+
+// urlFilter условие поиска ссылки по url с учетом dedup и аргументы к нему
+func (d *Database) urlFilter(userID, url string) (string, []any) {
+	if d.dedup == DedupGlobal {
+		return "original_url = $1", []any{url}
+	}
+	return "original_url = $1 AND user_id = $2", []any{url, userID}
+}
+
+// lockURLs транзакционные advisory lock на ключи dedup, чтобы проверка и вставка одного
+// url из параллельных запросов не проскочили обе; ключи сортируем - иначе два батча
+// с общими url могут взять локи в разном порядке и задедлочиться
+func (d *Database) lockURLs(ctx context.Context, tx *sql.Tx, keys ...string) error {
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+			return fmt.Errorf("failed to lock url: %w", ctxErr(ctx, err))
+		}
+	}
 	return nil
 }
 
 // GetUserURLs возвращает всё для заданного userID
-func (d *Database) GetUserURLs(userID string) ([]UserURL, error) {
+func (d *Database) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
 	if d == nil || d.db == nil {
 		return nil, errors.New("database connection is nil")
 	}
 
-	rows, err := d.db.Query(`
-		SELECT short_id, original_url
-		FROM urls
-		WHERE user_id = $1 AND is_deleted = false
-	`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var result []UserURL
-	for rows.Next() {
-		var s, o string
-		if err := rows.Scan(&s, &o); err != nil {
-			return nil, err
+	err := d.read(ctx, func(db *sql.DB) error {
+		// при повторе на primary начинаем с чистого листа
+		result = nil
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT short_id, original_url, expires_at, clicks_left, password_hash IS NOT NULL
+			FROM urls
+			WHERE user_id = $1 AND is_deleted = false
+		`, userID)
+		if err != nil {
+			return err
 		}
-		result = append(result, UserURL{ShortURL: s, OriginalURL: o})
-	}
+		defer rows.Close()
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+		for rows.Next() {
+			var (
+				s, o       string
+				expiresAt  sql.NullTime
+				clicksLeft sql.NullInt64
+				protected  bool
+			)
+			if err := rows.Scan(&s, &o, &expiresAt, &clicksLeft, &protected); err != nil {
+				return err
+			}
+			result = append(result, UserURL{
+				ShortURL:    s,
+				OriginalURL: o,
+				ExpiresAt:   nullTime(expiresAt),
+				ClicksLeft:  nullInt64(clicksLeft),
+				Protected:   protected,
+			})
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
 	}
 
 	return result, nil
 }
 
 // MarkUserURLsDeleted - batch update для uid и списка shortIDs
-func (d *Database) MarkUserURLsDeleted(userID string, shortIDs []string) error {
+func (d *Database) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
 	if d == nil || d.db == nil {
 		return errors.New("database connection is nil")
 	}
@@ -256,16 +556,738 @@ func (d *Database) MarkUserURLsDeleted(userID string, shortIDs []string) error {
 
 	query := `
 		UPDATE urls
-		SET is_deleted = true
+		SET is_deleted = true, deleted_at = now()
 		WHERE user_id = $1
 		  AND short_id = ANY($2)
+		  AND is_deleted = false
 	`
-	_, err := d.db.Exec(query, userID, pq.StringArray(shortIDs))
+	_, err := d.db.ExecContext(ctx, query, userID, pq.StringArray(shortIDs))
 	if err != nil {
 		log.Printf("DB Error: MarkUserURLsDeleted userID=%s, shortIDs=%v, err=%v",
 			userID, shortIDs, err)
-		return err
+		return ctxErr(ctx, err)
+	}
+
+	return nil
+}
+
+// RestoreUserURLs - снимаем флаг удаления, а для остальных id выясняем почему не вышло
+func (d *Database) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	if d == nil || d.db == nil {
+		return nil, errors.New("database connection is nil")
+	}
+
+	result := make(map[string]RestoreStatus, len(shortIDs))
+	if len(shortIDs) == 0 {
+		return result, nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE urls
+		SET is_deleted = false, deleted_at = NULL
+		WHERE user_id = $1
+		  AND short_id = ANY($2)
+		  AND is_deleted = true
+		  AND deleted_at >= $3
+		RETURNING short_id
+	`, userID, pq.StringArray(shortIDs), since)
+	if err != nil {
+		log.Printf("DB Error: RestoreUserURLs userID=%s, shortIDs=%v, err=%v", userID, shortIDs, err)
+		return nil, ctxErr(ctx, err)
+	}
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return nil, ctxErr(ctx, err)
+		}
+		result[sid] = RestoreOK
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	// всё, что не восстановилось: либо не удалено, либо срок вышел, либо чужое/нет такого
+	rows, err = tx.QueryContext(ctx, `
+		SELECT short_id, is_deleted
+		FROM urls
+		WHERE user_id = $1
+		  AND short_id = ANY($2)
+	`, userID, pq.StringArray(shortIDs))
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			sid       string
+			isDeleted bool
+		)
+		if err := rows.Scan(&sid, &isDeleted); err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+		if _, restored := result[sid]; restored {
+			continue
+		}
+		if isDeleted {
+			result[sid] = RestoreExpired
+		} else {
+			result[sid] = RestoreNotDeleted
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	for _, sid := range shortIDs {
+		if _, ok := result[sid]; !ok {
+			result[sid] = RestoreNotFound
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return result, nil
+}
+
+// exportPageSize сколько строк тянем за один запрос при выгрузке
+const exportPageSize = 1000
+
+// Export - выгружаем постранично по short_id (keyset), всегда с primary
+func (d *Database) Export(ctx context.Context, after string, fn func(Record) error) error {
+	if d == nil || d.db == nil {
+		return ErrDBConnection
+	}
+
+	for {
+		page, err := d.exportPage(ctx, after)
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+		for _, rec := range page {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		after = page[len(page)-1].ShortURL
+	}
+}
+
+func (d *Database) exportPage(ctx context.Context, after string) ([]Record, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT short_id, original_url, user_id, is_deleted, deleted_at, expires_at, clicks_left, password_hash
+		FROM urls
+		WHERE short_id > $1
+		ORDER BY short_id
+		LIMIT $2
+	`, after, exportPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := make([]Record, 0, exportPageSize)
+	for rows.Next() {
+		var (
+			rec       Record
+			userID    sql.NullString
+			deletedAt sql.NullTime
+			expiresAt sql.NullTime
+			clicks    sql.NullInt64
+			password  sql.NullString
+		)
+		if err := rows.Scan(&rec.ShortURL, &rec.OriginalURL, &userID, &rec.IsDeleted, &deletedAt, &expiresAt, &clicks, &password); err != nil {
+			return nil, err
+		}
+		rec.UserID = userID.String
+		rec.DeletedAt = nullTime(deletedAt)
+		rec.ExpiresAt = nullTime(expiresAt)
+		rec.ClicksLeft = nullInt64(clicks)
+		rec.PasswordHash = password.String
+		page = append(page, rec)
+	}
+	return page, rows.Err()
+}
+
+// Import - upsert по short_id одной транзакцией на пачку
+func (d *Database) Import(ctx context.Context, records []Record) error {
+	if d == nil || d.db == nil {
+		return ErrDBConnection
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO urls (short_id, original_url, user_id, is_deleted, deleted_at, expires_at, clicks_left, password_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (short_id) DO UPDATE
+		SET original_url = EXCLUDED.original_url,
+		    user_id = EXCLUDED.user_id,
+		    is_deleted = EXCLUDED.is_deleted,
+		    deleted_at = EXCLUDED.deleted_at,
+		    expires_at = EXCLUDED.expires_at,
+		    clicks_left = EXCLUDED.clicks_left,
+		    password_hash = EXCLUDED.password_hash
+	`)
+	if err != nil {
+		return ctxErr(ctx, err)
 	}
+	defer stmt.Close()
 
+	for _, rec := range records {
+		var deletedAt sql.NullTime
+		if rec.IsDeleted {
+			// без даты срок хранения считаем с момента импорта
+			deletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			if rec.DeletedAt != nil {
+				deletedAt.Time = *rec.DeletedAt
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, rec.ShortURL, rec.OriginalURL, rec.UserID, rec.IsDeleted, deletedAt, rec.ExpiresAt, rec.ClicksLeft, nullString(rec.PasswordHash)); err != nil {
+			log.Printf("DB Error: Import shortID=%s, err=%v", rec.ShortURL, err)
+			return ctxErr(ctx, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ctxErr(ctx, err)
+	}
 	return nil
 }
+
+// NextSequence - номер из short_id_seq, только с primary
+func (d *Database) NextSequence(ctx context.Context) (int64, error) {
+	if d == nil || d.db == nil {
+		return 0, ErrDBConnection
+	}
+
+	var n int64
+	if err := d.db.QueryRowContext(ctx, `SELECT nextval('short_id_seq')`).Scan(&n); err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return n, nil
+}
+
+// Count - сколько всего строк, вместе с удаленными
+func (d *Database) Count(ctx context.Context) (int64, error) {
+	if d == nil || d.db == nil {
+		return 0, ErrDBConnection
+	}
+
+	var n int64
+	if err := d.db.QueryRowContext(ctx, `SELECT count(*) FROM urls`).Scan(&n); err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return n, nil
+}
+
+// PurgeDeleted - окончательно удаляем помеченные удаленными раньше before
+func (d *Database) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	if d == nil || d.db == nil {
+		return 0, errors.New("database connection is nil")
+	}
+
+	// переходы удаляем тем же запросом, иначе их унаследует ссылка, занявшая id
+	var purged int64
+	err := d.db.QueryRowContext(ctx, `
+		WITH purged AS (
+			DELETE FROM urls
+			WHERE is_deleted = true
+			  AND deleted_at < $1
+			RETURNING short_id
+		), dropped AS (
+			DELETE FROM clicks WHERE short_id IN (SELECT short_id FROM purged)
+		), dropped_visitors AS (
+			DELETE FROM visitor_sketches WHERE short_id IN (SELECT short_id FROM purged)
+		), dropped_rollups AS (
+			DELETE FROM click_rollups WHERE short_id IN (SELECT short_id FROM purged)
+		)
+		SELECT count(*) FROM purged
+	`, before).Scan(&purged)
+	if err != nil {
+		log.Printf("DB Error: PurgeDeleted before=%s, err=%v", before, err)
+		return 0, ctxErr(ctx, err)
+	}
+	return purged, nil
+}
+
+// SaveClicks - пачка переходов подготовленным insert и скетчи уникальных в одной транзакции
+func (d *Database) SaveClicks(ctx context.Context, clicks []Click) error {
+	if d == nil || d.db == nil {
+		return ErrDBConnection
+	}
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO clicks (short_id, clicked_at, referrer, user_agent, ip_hash, bot, country, region)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return ctxErr(ctx, err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		if _, err := stmt.ExecContext(ctx, c.ShortURL, c.At, nullString(c.Referrer), nullString(c.UserAgent), nullString(c.IPHash), nullString(c.Bot),
+			nullString(c.Country), nullString(c.Region)); err != nil {
+			tx.Rollback()
+			log.Printf("DB Error: SaveClicks shortID=%s, err=%v", c.ShortURL, err)
+			return ctxErr(ctx, err)
+		}
+	}
+	if err := mergeVisitors(ctx, tx, clicks); err != nil {
+		tx.Rollback()
+		log.Printf("DB Error: SaveClicks visitors, err=%v", err)
+		return ctxErr(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return ctxErr(ctx, err)
+	}
+	return nil
+}
+
+// mergeVisitors складываем пачку в суточные скетчи: читаем, сливаем в Go, пишем обратно.
+// Строки может еще не быть, FOR UPDATE ее не залочит - поэтому advisory lock на
+// (short_id, day), как у дедупликации; порядок ключей один, чтобы инстансы не ловили дедлок
+func mergeVisitors(ctx context.Context, tx *sql.Tx, clicks []Click) error {
+	type dayKey struct {
+		shortID string
+		day     time.Time
+	}
+	batch := make(map[dayKey]*hll.Sketch)
+	var keys []dayKey
+	for _, c := range clicks {
+		v := visitorKey(c)
+		if v == nil {
+			continue
+		}
+		k := dayKey{shortID: c.ShortURL, day: BucketDay.Truncate(c.At)}
+		if batch[k] == nil {
+			batch[k] = hll.New()
+			keys = append(keys, k)
+		}
+		batch[k].Add(v)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].shortID != keys[j].shortID {
+			return keys[i].shortID < keys[j].shortID
+		}
+		return keys[i].day.Before(keys[j].day)
+	})
+
+	for _, k := range keys {
+		sketch := batch[k]
+		lockKey := "visitors:" + k.shortID + ":" + k.day.Format(time.DateOnly)
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, lockKey); err != nil {
+			return err
+		}
+		var stored []byte
+		err := tx.QueryRowContext(ctx, `SELECT sketch FROM visitor_sketches WHERE short_id = $1 AND day = $2`, k.shortID, k.day).Scan(&stored)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			var old hll.Sketch
+			// битый скетч не повод терять переходы - начинаем сутки заново
+			if err := old.UnmarshalBinary(stored); err == nil && sketch.Merge(&old) != nil {
+				log.Printf("DB: visitor sketch %s precision mismatch, resetting", lockKey)
+			}
+		}
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO visitor_sketches (short_id, day, sketch)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (short_id, day) DO UPDATE SET sketch = EXCLUDED.sketch
+		`, k.shortID, k.day, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClickStats - владельца проверяем по urls, отрезки режем в UTC прямо в базе
+func (d *Database) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	if d == nil || d.db == nil {
+		return ClickStats{}, ErrDBConnection
+	}
+
+	var stats ClickStats
+	err := d.read(ctx, func(db *sql.DB) error {
+		stats = ClickStats{}
+
+		var owner string
+		err := db.QueryRowContext(ctx, `SELECT user_id FROM urls WHERE short_id = $1`, shortID).Scan(&owner)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+			return ErrURLNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = db.QueryRowContext(ctx, `
+			SELECT count(*) FILTER (WHERE bot IS NULL), count(*) FILTER (WHERE bot IS NOT NULL)
+			FROM clicks WHERE short_id = $1
+		`, shortID).Scan(&stats.Total, &stats.BotTotal)
+		if err != nil {
+			return err
+		}
+		var rolled, rolledBots int64
+		err = db.QueryRowContext(ctx, `
+			SELECT coalesce(sum(clicks), 0), coalesce(sum(bot_clicks), 0)
+			FROM click_rollups WHERE short_id = $1
+		`, shortID).Scan(&rolled, &rolledBots)
+		if err != nil {
+			return err
+		}
+		stats.Total += rolled
+		stats.BotTotal += rolledBots
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT date_trunc($2, clicked_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket, count(*)
+			FROM clicks
+			WHERE short_id = $1 AND clicked_at >= $3 AND clicked_at < $4 AND bot IS NULL
+			GROUP BY bucket
+			ORDER BY bucket
+		`, shortID, string(q.Bucket), q.From, q.To)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var b ClickBucket
+			if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+				return err
+			}
+			b.Start = b.Start.UTC()
+			stats.Buckets = append(stats.Buckets, b)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// свернутые сутки часов не помнят - в разбивку по часам не идут
+		if q.Bucket != BucketHour {
+			if stats.Buckets, err = rollupBuckets(ctx, db, shortID, q, stats.Buckets); err != nil {
+				return err
+			}
+		}
+		if err := clickTops(ctx, db, shortID, q, &stats); err != nil {
+			return err
+		}
+
+		vrows, err := db.QueryContext(ctx, `
+			SELECT day, sketch FROM visitor_sketches
+			WHERE short_id = $1 AND day >= $2 AND day < $3
+			ORDER BY day
+		`, shortID, BucketDay.Truncate(q.From), q.To)
+		if err != nil {
+			return err
+		}
+		defer vrows.Close()
+		for vrows.Next() {
+			var (
+				v    DayVisitors
+				data []byte
+			)
+			if err := vrows.Scan(&v.Day, &data); err != nil {
+				return err
+			}
+			v.Visitors = new(hll.Sketch)
+			if err := v.Visitors.UnmarshalBinary(data); err != nil {
+				log.Printf("DB Error: ClickStats shortID=%s day=%s, err=%v", shortID, v.Day, err)
+				continue
+			}
+			v.Day = v.Day.UTC()
+			stats.Visitors = append(stats.Visitors, v)
+		}
+		return vrows.Err()
+	})
+	if errors.Is(err, ErrURLNotFound) {
+		return ClickStats{}, err
+	}
+	if err != nil {
+		return ClickStats{}, ctxErr(ctx, err)
+	}
+	return stats, nil
+}
+
+// rollupBuckets добавляем к отрезкам переходы из суточных агрегатов
+func rollupBuckets(ctx context.Context, db *sql.DB, shortID string, q ClickQuery, buckets []ClickBucket) ([]ClickBucket, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT date_trunc($2, day AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket, sum(clicks)
+		FROM click_rollups
+		WHERE short_id = $1 AND day >= $3 AND day < $4
+		GROUP BY bucket
+	`, shortID, string(q.Bucket), q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int64, len(buckets))
+	for _, b := range buckets {
+		counts[b.Start.Unix()] += b.Clicks
+	}
+	for rows.Next() {
+		var b ClickBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		counts[b.Start.Unix()] += b.Clicks
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	merged := make([]ClickBucket, 0, len(counts))
+	for start, n := range counts {
+		merged = append(merged, ClickBucket{Start: time.Unix(start, 0).UTC(), Clicks: n})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Start.Before(merged[j].Start) })
+	return merged, nil
+}
+
+// clickTops рефереры, семейства UA и страны за сутки запроса: сырые группируем в базе по
+// полным значениям, до хоста и семейства сводим уже здесь, агрегаты складываем как есть
+func clickTops(ctx context.Context, db *sql.DB, shortID string, q ClickQuery, stats *ClickStats) error {
+	stats.Referrers = make(map[string]int64)
+	stats.UserAgents = make(map[string]int64)
+	stats.Countries = make(map[string]int64)
+	from := BucketDay.Truncate(q.From)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT coalesce(referrer, ''), coalesce(user_agent, ''), coalesce(country, ''), count(*)
+		FROM clicks
+		WHERE short_id = $1 AND clicked_at >= $2 AND clicked_at < $3 AND bot IS NULL
+		GROUP BY 1, 2, 3
+	`, shortID, from, q.To)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c Click
+			n int64
+		)
+		if err := rows.Scan(&c.Referrer, &c.UserAgent, &c.Country, &n); err != nil {
+			return err
+		}
+		stats.Referrers[referrerHost(c.Referrer)] += n
+		stats.UserAgents[userAgentFamily(c.UserAgent)] += n
+		stats.Countries[clickCountry(c)] += n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rrows, err := db.QueryContext(ctx, `
+		SELECT referrers, user_agents, countries FROM click_rollups
+		WHERE short_id = $1 AND day >= $2 AND day < $3
+	`, shortID, from, q.To)
+	if err != nil {
+		return err
+	}
+	defer rrows.Close()
+	for rrows.Next() {
+		var refs, uas, countries []byte
+		if err := rrows.Scan(&refs, &uas, &countries); err != nil {
+			return err
+		}
+		var r ClickRollup
+		if err := json.Unmarshal(refs, &r.Referrers); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(uas, &r.UserAgents); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(countries, &r.Countries); err != nil {
+			return err
+		}
+		addCounts(&stats.Referrers, r.Referrers)
+		addCounts(&stats.UserAgents, r.UserAgents)
+		addCounts(&stats.Countries, r.Countries)
+	}
+	return rrows.Err()
+}
+
+// RollupClicks пачка самых старых сырых переходов: строки берем FOR UPDATE SKIP LOCKED,
+// чтобы инстансы сворачивали разные, агрегаты сливаем под advisory lock суток, как скетчи
+// уникальных, сырые удаляем в той же транзакции - двойного счета не будет
+func (d *Database) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	if d == nil || d.db == nil {
+		return 0, ErrDBConnection
+	}
+	if limit <= 0 {
+		limit = 10000
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	rolled, err := rollupTx(ctx, tx, before, limit)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("DB Error: RollupClicks before=%s, err=%v", before.Format(time.RFC3339), err)
+		return 0, ctxErr(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return rolled, nil
+}
+
+func rollupTx(ctx context.Context, tx *sql.Tx, before time.Time, limit int) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, short_id, clicked_at, coalesce(referrer, ''), coalesce(user_agent, ''), coalesce(ip_hash, ''), coalesce(bot, ''),
+			coalesce(country, ''), coalesce(region, '')
+		FROM clicks
+		WHERE clicked_at < $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		ids    []int64
+		clicks []Click
+	)
+	for rows.Next() {
+		var (
+			id int64
+			c  Click
+		)
+		if err := rows.Scan(&id, &c.ShortURL, &c.At, &c.Referrer, &c.UserAgent, &c.IPHash, &c.Bot, &c.Country, &c.Region); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		clicks = append(clicks, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(clicks) == 0 {
+		return 0, nil
+	}
+
+	keys, rollups := rollupClicks(clicks)
+	for _, k := range keys {
+		if err := mergeRollup(ctx, tx, rollups[k]); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM clicks WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	return int64(len(clicks)), nil
+}
+
+// mergeRollup читаем агрегат суток, сливаем в Go и пишем обратно; строки может не быть,
+// поэтому advisory lock, как в mergeVisitors
+func mergeRollup(ctx context.Context, tx *sql.Tx, r *ClickRollup) error {
+	lockKey := "rollup:" + r.ShortURL + ":" + r.Day.Format(time.DateOnly)
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, lockKey); err != nil {
+		return err
+	}
+
+	var (
+		old                  ClickRollup
+		visitors             []byte
+		refs, uas, countries []byte
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT clicks, bot_clicks, visitors, referrers, user_agents, countries
+		FROM click_rollups WHERE short_id = $1 AND day = $2
+	`, r.ShortURL, r.Day).Scan(&old.Clicks, &old.BotClicks, &visitors, &refs, &uas, &countries)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		if len(visitors) > 0 {
+			old.Visitors = new(hll.Sketch)
+			if err := old.Visitors.UnmarshalBinary(visitors); err != nil {
+				// битый скетч не повод терять счетчики - уникальные суток начнем заново
+				log.Printf("DB: click rollup %s has broken visitors sketch: %v", lockKey, err)
+				old.Visitors = nil
+			}
+		}
+		if err := json.Unmarshal(refs, &old.Referrers); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(uas, &old.UserAgents); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(countries, &old.Countries); err != nil {
+			return err
+		}
+		r.merge(&old)
+		r.trim()
+	}
+
+	visitors, err = r.Visitors.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if refs, err = json.Marshal(r.Referrers); err != nil {
+		return err
+	}
+	if uas, err = json.Marshal(r.UserAgents); err != nil {
+		return err
+	}
+	if countries, err = json.Marshal(r.Countries); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO click_rollups (short_id, day, clicks, bot_clicks, visitors, referrers, user_agents, countries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (short_id, day) DO UPDATE SET
+			clicks = EXCLUDED.clicks,
+			bot_clicks = EXCLUDED.bot_clicks,
+			visitors = EXCLUDED.visitors,
+			referrers = EXCLUDED.referrers,
+			user_agents = EXCLUDED.user_agents,
+			countries = EXCLUDED.countries
+	`, r.ShortURL, r.Day, r.Clicks, r.BotClicks, visitors, refs, uas, countries)
+	return err
+}
+
+// ctxErr если запрос упал из-за отмены/дедлайна контекста, pq отдает свою ошибку
+// (canceling statement due to user request) - приклеиваем к ней ошибку контекста,
+// чтобы наверху можно было сделать errors.Is(err, context.DeadlineExceeded)
+func ctxErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
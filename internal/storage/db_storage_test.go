@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -9,7 +10,7 @@ import (
 
 // TestNewDatabase_EmptyDSN - ошибка при пустой строке подключения
 func TestNewDatabase_EmptyDSN(t *testing.T) {
-	db, err := NewDatabase("")
+	db, err := NewDatabase("", true)
 	assert.Error(t, err)
 	assert.Nil(t, db)
 }
@@ -30,7 +31,7 @@ func TestDatabase_Close(t *testing.T) {
 // TestDatabase_Ping_NilDB - проверяем пинга вернет nil
 func TestDatabase_Ping_NilDB(t *testing.T) {
 	var db *Database
-	err := db.Ping()
+	err := db.Ping(context.Background())
 	assert.Error(t, err)
 	assert.Equal(t, "db connection issue", err.Error())
 }
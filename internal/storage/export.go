@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Record переносимая запись ссылки, поля как в storage.json
+type Record struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ClicksLeft  *int64     `json:"clicks_left,omitempty"`
+	// PasswordHash переносим как есть, пароль заново не спросить
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// Exporter бэкенд умеет отдать все записи по возрастанию short_id,
+// after - продолжить с места остановки, "" - с начала
+type Exporter interface {
+	Export(ctx context.Context, after string, fn func(Record) error) error
+	Count(ctx context.Context) (int64, error)
+}
+
+// Importer бэкенд умеет принять записи как есть, без проверок dedup.
+// Повторный импорт той же записи перезаписывает её, так что перенос можно перезапускать
+type Importer interface {
+	Import(ctx context.Context, records []Record) error
+	Count(ctx context.Context) (int64, error)
+}
+
+// exportSorted отдаем скопированные записи в fn по возрастанию short_id,
+// копию снимают под локом бэкенда, колбэк зовем уже без него
+func exportSorted(ctx context.Context, records []Record, fn func(Record) error) error {
+	sort.Slice(records, func(i, j int) bool { return records[i].ShortURL < records[j].ShortURL })
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,129 +1,375 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/config"
+)
+
+const (
+	// journalSuffix - журнал операций лежит рядом со снапшотом
+	journalSuffix = ".journal"
+	// clicksSuffix - переходы пишем отдельно: их много, и в снапшот ссылок они не сворачиваются
+	clicksSuffix = ".clicks"
+	// rollupsSuffix - суточные агрегаты свернутых переходов, файл небольшой, переписываем целиком
+	rollupsSuffix = ".rollups"
+	// defaultCompactThreshold - после стольких записей в журнале сворачиваем его в снапшот
+	defaultCompactThreshold = 1000
+
+	// операции журнала
+	opCreate  = "create"
+	opDelete  = "delete"
+	opRestore = "restore"
+	opClick   = "click"
 )
 
+// fileScheme file:///abs/path.json или file://./rel/path.json
+const fileScheme = "file://"
+
+func init() {
+	Register("file", func(location string, cfg *config.Config, opts ...Option) (Storager, error) {
+		path := strings.TrimPrefix(location, fileScheme)
+		if path == "" {
+			return nil, fmt.Errorf("file storage path is empty in %q", location)
+		}
+		return NewFileStorage(path, opts...)
+	})
+}
+
 // fileEntry структура для сериализации в файл
 type fileEntry struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 	UserID      string `json:"user_id"`
 	IsDeleted   bool   `json:"is_deleted"`
+	// DeletedAt когда пометили удаленной, от этого считается срок хранения до очистки
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ExpiresAt срок жизни ссылки, нет поля - бессрочная
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ClicksLeft сколько переходов осталось, нет поля - без ограничения
+	ClicksLeft *int64 `json:"clicks_left,omitempty"`
+	// PasswordHash bcrypt хэш пароля, нет поля - ссылка открытая
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// link ссылка из записи; ClicksLeft на месте не меняем, только подменяем указатель,
+// так что делить его можно
+func (e *fileEntry) link() Link {
+	return Link{
+		ShortURL:     e.ShortURL,
+		OriginalURL:  e.OriginalURL,
+		ExpiresAt:    e.ExpiresAt,
+		ClicksLeft:   e.ClicksLeft,
+		PasswordHash: e.PasswordHash,
+	}
 }
 
-// FileStorage реализация хранилища в файле
+// journalRecord запись журнала операций, для create заполнен весь fileEntry,
+// для delete/restore достаточно short_url и user_id (+ deleted_at для delete),
+// для click - short_url и clicks_left после перехода
+type journalRecord struct {
+	Op string `json:"op"`
+	fileEntry
+}
+
+// FileStorage реализация хранилища в файле: снапшот в старом формате (по строке
+// fileEntry на ссылку) + журнал операций, который дописывается в конец и
+// периодически сворачивается обратно в снапшот
 type FileStorage struct {
 	sync.RWMutex
-	filePath  string
-	store     map[string]*fileEntry // short_id -> *fileEntry
-	userLinks map[string][]string   // user->[]shortIDs
+	filePath         string
+	journalPath      string
+	journal          *os.File // открыт на дозапись, создается лениво
+	journalOps       int      // сколько записей в журнале с последнего сворачивания
+	clicksPath       string
+	clicksFile       *os.File // открыт на дозапись, создается лениво
+	rollupsPath      string
+	clicks           clickLog
+	compactThreshold int
+	recovered        int                   // сколько записей подняли при загрузке
+	quarantined      int                   // сколько битых записей отложили в карантин
+	store            map[string]*fileEntry // short_id -> *fileEntry
+	userLinks        map[string][]string   // user->[]shortIDs
+	urlIndex         map[string]string     // original -> short_id, обратный индекс для конфликтов
+	dedup            DedupScope
+	seq              atomic.Int64 // счетчик для id, после загрузки начинаем с числа записей
 }
 
 // NewFileStorage запускатор "соединения" с файлом, аналогия на NewDatabase
-func NewFileStorage(filePath string) (*FileStorage, error) {
+func NewFileStorage(filePath string, opts ...Option) (*FileStorage, error) {
+	o := newOptions(opts)
 	fs := &FileStorage{
-		filePath:  filePath,
-		store:     make(map[string]*fileEntry),
-		userLinks: make(map[string][]string),
+		filePath:         filePath,
+		journalPath:      filePath + journalSuffix,
+		clicksPath:       filePath + clicksSuffix,
+		rollupsPath:      filePath + rollupsSuffix,
+		clicks:           newClickLog(),
+		compactThreshold: defaultCompactThreshold,
+		store:            make(map[string]*fileEntry),
+		userLinks:        make(map[string][]string),
+		urlIndex:         make(map[string]string),
+		dedup:            o.dedup,
 	}
 	if err := fs.load(); err != nil {
 		return nil, err
 	}
+	fs.seq.Store(int64(len(fs.store)))
 	return fs, nil
 }
 
 // -- методы из старого интерфейса --
 
-func (fs *FileStorage) Save(id, url string) error {
-	return fs.SaveUserURL("", id, url)
+func (fs *FileStorage) Save(ctx context.Context, id, url string) error {
+	return fs.SaveUserURL(ctx, "", id, url)
+}
+
+func (fs *FileStorage) SaveBatch(ctx context.Context, urls map[string]string) error {
+	return fs.SaveBatchUserURLs(ctx, "", urls)
+}
+
+func (fs *FileStorage) Load(ctx context.Context, id string) (string, error) {
+	link, err := fs.LoadLink(ctx, id)
+	return link.OriginalURL, err
+}
+
+func (fs *FileStorage) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	if id, ok := fs.urlIndex[fs.dedup.key(userID, url)]; ok {
+		return id, nil
+	}
+	return "", ErrURLNotFound
+}
+
+func (fs *FileStorage) Ping(ctx context.Context) error {
+	return errors.New("there is no connection: fs001")
 }
 
-func (fs *FileStorage) SaveBatch(urls map[string]string) error {
-	return fs.SaveBatchUserURLs("", urls)
+// Close сворачиваем журнал в снапшот и закрываем файл журнала
+func (fs *FileStorage) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err := fs.compact()
+	if fs.journal != nil {
+		if closeErr := fs.journal.Close(); err == nil {
+			err = closeErr
+		}
+		fs.journal = nil
+	}
+	if fs.clicksFile != nil {
+		if closeErr := fs.clicksFile.Close(); err == nil {
+			err = closeErr
+		}
+		fs.clicksFile = nil
+	}
+	return err
 }
 
-func (fs *FileStorage) Load(id string) (string, error) {
+// NextSequence счетчик в файл не пишем: после рестарта продолжаем с числа записей,
+// а если номера уже заняты (после очистки удаленных), SaveLink и SaveBatchLinks вернут
+// конфликт short_id и сервис возьмет следующий
+func (fs *FileStorage) NextSequence(ctx context.Context) (int64, error) {
+	return fs.seq.Add(1), nil
+}
+
+// -- методы с юид --
+
+func (fs *FileStorage) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	return fs.SaveLink(ctx, userID, Link{ShortURL: shortID, OriginalURL: originalURL})
+}
+
+func (fs *FileStorage) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
+	return fs.SaveBatchLinks(ctx, userID, linksFromMap(batch))
+}
+
+func (fs *FileStorage) LoadLink(ctx context.Context, id string) (Link, error) {
 	fs.RLock()
 	defer fs.RUnlock()
 	entry, ok := fs.store[id]
 	if !ok {
-		return "", ErrURLNotFound
+		return Link{}, ErrURLNotFound
 	}
 	if entry.IsDeleted {
-		return "", ErrURLDeleted
+		return Link{}, ErrURLDeleted
 	}
-	return entry.OriginalURL, nil
+	link := entry.link()
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	return link, nil
 }
 
-func (fs *FileStorage) FindIDByURL(url string) (string, error) {
+// ConsumeLink переход по ссылке с лимитом пишем в журнал до ответа, чтобы после
+// рестарта не выдать его еще раз; ссылки без лимита журнал не трогают.
+// Счетчик меняем до записи, как и остальные операции: запись может свернуть
+// журнал в снапшот, и в снапшот должен попасть уже уменьшенный счетчик
+func (fs *FileStorage) ConsumeLink(ctx context.Context, id string) (Link, error) {
 	fs.RLock()
-	defer fs.RUnlock()
-	for id, entry := range fs.store {
-		if entry.OriginalURL == url {
-			return id, nil
-		}
+	entry, ok := fs.store[id]
+	limited := ok && entry.ClicksLeft != nil
+	fs.RUnlock()
+	if !limited {
+		return fs.LoadLink(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
 	}
-	return "", ErrURLNotFound
-}
 
-func (fs *FileStorage) Ping() error  { return errors.New("there is no connection: fs001") }
-func (fs *FileStorage) Close() error { return nil }
+	fs.Lock()
+	defer fs.Unlock()
+	// пока ждали Lock ссылку могли удалить или перезаписать - проверяем заново
+	entry, ok = fs.store[id]
+	if !ok {
+		return Link{}, ErrURLNotFound
+	}
+	if entry.IsDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	link := entry.link()
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	if link.ClicksLeft == nil {
+		return link, nil
+	}
 
-// -- методы с юид --
+	link = link.consumed()
+	if err := fs.appendJournal(journalRecord{
+		Op:        opClick,
+		fileEntry: fileEntry{ShortURL: id, ClicksLeft: link.ClicksLeft},
+	}); err != nil {
+		return Link{}, err
+	}
+	entry.ClicksLeft = link.ClicksLeft
+	fs.maybeCompact()
+	return link, nil
+}
+
+func (fs *FileStorage) SaveLink(ctx context.Context, userID string, link Link) error {
+	shortID, originalURL := link.ShortURL, link.OriginalURL
+	// запись на диск - не начинаем, если запрос уже отменили
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-func (fs *FileStorage) SaveUserURL(userID, shortID, originalURL string) error {
 	fs.Lock()
 	defer fs.Unlock()
 
-	// если уже есть такой originalURL у другого shortID - вернём конфликт
-	for existID, entry := range fs.store {
-		if entry.OriginalURL == originalURL && existID != shortID {
+	// если уже есть такой originalURL у другого shortID (в пределах dedup) - вернём конфликт
+	if fs.dedup != DedupNone {
+		if existID, ok := fs.urlIndex[fs.dedup.key(userID, originalURL)]; ok && existID != shortID {
 			return ErrURLConflict
 		}
 	}
-	// проверка на конфликт shortID
-	if oldEntry, ok := fs.store[shortID]; ok && oldEntry.OriginalURL != originalURL {
+	// shortID уже занят - конфликт, даже если там та же ссылка: как и бд, не перезаписываем,
+	// иначе повторное сохранение вернуло бы удаленную ссылку и сбросило лимит переходов
+	if _, ok := fs.store[shortID]; ok {
 		return ErrShortIDConflict
 	}
 
-	fs.store[shortID] = &fileEntry{
-		ShortURL:    shortID,
-		OriginalURL: originalURL,
-		UserID:      userID,
-		IsDeleted:   false,
+	entry := fileEntry{
+		ShortURL:     shortID,
+		OriginalURL:  originalURL,
+		UserID:       userID,
+		IsDeleted:    false,
+		ExpiresAt:    link.ExpiresAt,
+		ClicksLeft:   link.ClicksLeft,
+		PasswordHash: link.PasswordHash,
 	}
-	if userID != "" {
-		fs.userLinks[userID] = append(fs.userLinks[userID], shortID)
+	if err := fs.appendJournal(journalRecord{Op: opCreate, fileEntry: entry}); err != nil {
+		return err
 	}
-
-	return fs.save()
+	fs.applyCreate(entry)
+	fs.maybeCompact()
+	return nil
 }
 
-func (fs *FileStorage) SaveBatchUserURLs(userID string, batch map[string]string) error {
+func (fs *FileStorage) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	fs.Lock()
 	defer fs.Unlock()
 
-	for shortID, originalURL := range batch {
-		fs.store[shortID] = &fileEntry{
-			ShortURL:    shortID,
-			OriginalURL: originalURL,
-			UserID:      userID,
-			IsDeleted:   false,
+	// в store батч попадет только после журнала, а до того свои же ссылки смотрим здесь
+	var (
+		conflicts []int
+		existing  = make(map[int]string)
+		entries   = make([]fileEntry, 0, len(links))
+		batchIDs  = make(map[string]int)    // short_id -> номер в entries
+		batchURLs = make(map[string]string) // ключ dedup -> short_id
+	)
+	for i, link := range links {
+		key := fs.dedup.key(userID, link.OriginalURL)
+		// url уже сокращен (в том числе раньше в этом батче) - отдаем его short_id
+		if fs.dedup != DedupNone {
+			existID, ok := fs.urlIndex[key]
+			if !ok {
+				existID, ok = batchURLs[key]
+			}
+			if ok && existID != link.ShortURL {
+				existing[i] = existID
+				continue
+			}
 		}
-		if userID != "" {
-			fs.userLinks[userID] = append(fs.userLinks[userID], shortID)
+
+		old, ok := fs.store[link.ShortURL]
+		if n, inBatch := batchIDs[link.ShortURL]; inBatch {
+			old, ok = &entries[n], true
 		}
+		if ok {
+			if old.OriginalURL != link.OriginalURL || old.UserID != userID {
+				conflicts = append(conflicts, i)
+			}
+			// та же ссылка уже сохранена - как и бд, не перезаписываем
+			continue
+		}
+
+		batchIDs[link.ShortURL] = len(entries)
+		if _, ok := batchURLs[key]; !ok {
+			batchURLs[key] = link.ShortURL
+		}
+		entries = append(entries, fileEntry{
+			ShortURL:     link.ShortURL,
+			OriginalURL:  link.OriginalURL,
+			UserID:       userID,
+			IsDeleted:    false,
+			ExpiresAt:    link.ExpiresAt,
+			ClicksLeft:   link.ClicksLeft,
+			PasswordHash: link.PasswordHash,
+		})
 	}
 
-	return fs.save()
+	records := make([]journalRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, journalRecord{Op: opCreate, fileEntry: entry})
+	}
+	if err := fs.appendJournal(records...); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fs.applyCreate(entry)
+	}
+	fs.maybeCompact()
+	return batchConflict(conflicts, existing)
 }
 
-func (fs *FileStorage) GetUserURLs(userID string) ([]UserURL, error) {
+func (fs *FileStorage) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
 	fs.RLock()
 	defer fs.RUnlock()
 
@@ -139,6 +385,9 @@ func (fs *FileStorage) GetUserURLs(userID string) ([]UserURL, error) {
 			result = append(result, UserURL{
 				ShortURL:    sid,
 				OriginalURL: entry.OriginalURL,
+				ExpiresAt:   entry.ExpiresAt,
+				ClicksLeft:  entry.ClicksLeft,
+				Protected:   entry.PasswordHash != "",
 			})
 		}
 	}
@@ -146,67 +395,718 @@ func (fs *FileStorage) GetUserURLs(userID string) ([]UserURL, error) {
 }
 
 // MarkUserURLsDeleted - множественное обновление для userID и списка shortIDs
-func (fs *FileStorage) MarkUserURLsDeleted(userID string, shortIDs []string) error {
+func (fs *FileStorage) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	fs.Lock()
 	defer fs.Unlock()
 
+	var records []journalRecord
+	now := time.Now().UTC()
+	seen := make(map[string]struct{}, len(shortIDs))
 	for _, sid := range shortIDs {
+		if _, dup := seen[sid]; dup {
+			continue
+		}
+		seen[sid] = struct{}{}
 		entry, ok := fs.store[sid]
-		if ok && entry.UserID == userID {
-			entry.IsDeleted = true
+		if ok && entry.UserID == userID && !entry.IsDeleted {
+			records = append(records, journalRecord{
+				Op:        opDelete,
+				fileEntry: fileEntry{ShortURL: sid, UserID: userID, DeletedAt: &now},
+			})
+		}
+	}
+
+	// память меняем только после записи журнала, иначе удаление пропадет на рестарте
+	if err := fs.appendJournal(records...); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		entry := fs.store[rec.ShortURL]
+		entry.IsDeleted = true
+		entry.DeletedAt = &now
+	}
+	fs.maybeCompact()
+	return nil
+}
+
+// RestoreUserURLs снимаем флаг удаления и пишем это в журнал
+func (fs *FileStorage) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	result := make(map[string]RestoreStatus, len(shortIDs))
+	var records []journalRecord
+	for _, sid := range shortIDs {
+		if _, dup := result[sid]; dup {
+			continue
+		}
+		entry, ok := fs.store[sid]
+		switch {
+		case !ok || entry.UserID != userID:
+			result[sid] = RestoreNotFound
+		case !entry.IsDeleted:
+			result[sid] = RestoreNotDeleted
+		case entry.DeletedAt != nil && entry.DeletedAt.Before(since):
+			result[sid] = RestoreExpired
+		default:
+			result[sid] = RestoreOK
+			records = append(records, journalRecord{
+				Op:        opRestore,
+				fileEntry: fileEntry{ShortURL: sid, UserID: userID},
+			})
 		}
 	}
 
-	return fs.save()
+	if err := fs.appendJournal(records...); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		entry := fs.store[rec.ShortURL]
+		entry.IsDeleted = false
+		entry.DeletedAt = nil
+	}
+	fs.maybeCompact()
+	return result, nil
+}
+
+func (fs *FileStorage) Export(ctx context.Context, after string, fn func(Record) error) error {
+	// копируем под локом, колбэк зовем уже без него
+	fs.RLock()
+	records := make([]Record, 0, len(fs.store))
+	for sid, entry := range fs.store {
+		if sid > after {
+			records = append(records, Record(*entry))
+		}
+	}
+	fs.RUnlock()
+
+	return exportSorted(ctx, records, fn)
+}
+
+// Import пишем в журнал обычными create, при replay они перезапишут старые записи
+func (fs *FileStorage) Import(ctx context.Context, records []Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	journal := make([]journalRecord, 0, len(records))
+	for _, rec := range records {
+		entry := fileEntry(rec)
+		stampDeleted(&entry, time.Now().UTC())
+		journal = append(journal, journalRecord{Op: opCreate, fileEntry: entry})
+	}
+	if err := fs.appendJournal(journal...); err != nil {
+		return err
+	}
+	orphans := make(map[string]struct{})
+	for _, rec := range journal {
+		if key := fs.applyCreate(rec.fileEntry); key != "" {
+			orphans[key] = struct{}{}
+		}
+	}
+	fs.reindex(orphans)
+	fs.maybeCompact()
+	return nil
+}
+
+func (fs *FileStorage) Count(ctx context.Context) (int64, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return int64(len(fs.store)), nil
+}
+
+// PurgeDeleted чистим store и сразу переписываем снапшот - очистка редкая и массовая,
+// так что отдельная операция в журнале не нужна
+func (fs *FileStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	var (
+		purged       int64
+		purgedClicks bool
+	)
+	orphans := make(map[string]struct{})
+	for sid, entry := range fs.store {
+		if entry.IsDeleted && entry.DeletedAt != nil && entry.DeletedAt.Before(before) {
+			if key := fs.remove(sid); key != "" {
+				orphans[key] = struct{}{}
+			}
+			if fs.clicks.drop(sid) {
+				purgedClicks = true
+			}
+			purged++
+		}
+	}
+	fs.reindex(orphans)
+	if purged == 0 {
+		return 0, nil
+	}
+	if purgedClicks {
+		if err := fs.rewriteRollups(); err != nil {
+			return 0, err
+		}
+		if err := fs.rewriteClicks(); err != nil {
+			return 0, err
+		}
+	}
+	return purged, fs.rewrite()
+}
+
+// SaveClicks дописываем переходы в свой файл; журнал ссылок не трогаем, чтобы
+// поток переходов не гонял сворачивание снапшота
+func (fs *FileStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.clicksFile == nil {
+		file, err := os.OpenFile(fs.clicksPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open clicks file: %w", err)
+		}
+		fs.clicksFile = file
+	}
+
+	var buf []byte
+	for _, c := range clicks {
+		line, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to encode click: %w", err)
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	// fsync на каждую пачку не делаем: потерять последние переходы при падении
+	// не страшно, а недописанную строку load отложит в карантин
+	if n, err := fs.clicksFile.Write(buf); err != nil {
+		if info, statErr := fs.clicksFile.Stat(); statErr == nil {
+			fs.clicksFile.Truncate(info.Size() - int64(n))
+		}
+		return fmt.Errorf("failed to write clicks: %w", err)
+	}
+	fs.clicks.add(clicks)
+	return nil
+}
+
+func (fs *FileStorage) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	entry, ok := fs.store[shortID]
+	if !ok || entry.UserID != userID {
+		return ClickStats{}, ErrURLNotFound
+	}
+	return fs.clicks.stats(shortID, q), nil
+}
+
+// RollupClicks файл переходов все равно переписываем целиком, так что сворачиваем
+// все старше before за раз, limit не смотрим. Агрегаты пишем до сырых: если упадем
+// между ними, load по агрегатам поймет, какие сырые уже свернуты
+func (fs *FileStorage) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	rolled := fs.clicks.rollup(before, 0)
+	if rolled == 0 {
+		return 0, nil
+	}
+	if err := fs.rewriteRollups(); err != nil {
+		return 0, err
+	}
+	return rolled, fs.rewriteClicks()
+}
+
+// rewriteRollups переписываем агрегаты из памяти, вызывать под Lock
+func (fs *FileStorage) rewriteRollups() error {
+	return writeFileAtomic(fs.rollupsPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, r := range fs.clicks.allRollups() {
+			if err := enc.Encode(r); err != nil {
+				return fmt.Errorf("failed to encode click rollup: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// rewriteClicks переписываем файл переходов из памяти, вызывать под Lock
+func (fs *FileStorage) rewriteClicks() error {
+	if fs.clicksFile != nil {
+		// дескриптор смотрит на старый файл, после rename откроем новый
+		fs.clicksFile.Close()
+		fs.clicksFile = nil
+	}
+	return writeFileAtomic(fs.clicksPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, events := range fs.clicks.events {
+			for _, c := range events {
+				if err := enc.Encode(c); err != nil {
+					return fmt.Errorf("failed to encode click: %w", err)
+				}
+			}
+		}
+		return nil
+	})
 }
 
 // ----------------- Внутренние методы -----------------
 
-func (fs *FileStorage) save() error {
-	file, err := os.Create(fs.filePath)
+// appendJournal дописываем операции в журнал и ждем fsync; store меняем только после
+// успешной записи, а потом зовем maybeCompact. Вызывать под Lock
+func (fs *FileStorage) appendJournal(records ...journalRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	if fs.journal == nil {
+		file, err := os.OpenFile(fs.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open journal: %w", err)
+		}
+		fs.journal = file
+	}
+
+	// пишем одной пачкой, чтобы батч не разъезжался на несколько write
+	var buf []byte
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal record: %w", err)
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if n, err := fs.journal.Write(buf); err != nil {
+		// недописанный хвост не оставляем, иначе следующая запись приклеится к обрывку
+		if info, statErr := fs.journal.Stat(); statErr == nil {
+			fs.journal.Truncate(info.Size() - int64(n))
+		}
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := fs.journal.Sync(); err != nil {
+		// в памяти операции не будет - убираем её и из журнала, чтобы не всплыла на рестарте
+		if info, statErr := fs.journal.Stat(); statErr == nil {
+			fs.journal.Truncate(info.Size() - int64(len(buf)))
+		}
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	fs.journalOps += len(records)
+	return nil
+}
+
+// maybeCompact журнал переполнился - сворачиваем в снапшот. Запись уже в журнале,
+// так что неудачное сворачивание не ошибка операции: журнал остается и накатится
+// при загрузке, а свернуть попробуем на следующей записи. Вызывать под Lock
+func (fs *FileStorage) maybeCompact() {
+	if fs.journalOps < fs.compactThreshold {
+		return
+	}
+	if err := fs.compact(); err != nil {
+		log.Printf("FileStorage: failed to compact journal %s: %v", fs.journalPath, err)
+	}
+}
+
+// compact переписываем снапшот целиком из store и обнуляем журнал
+// вызывать под Lock
+func (fs *FileStorage) compact() error {
+	if fs.journalOps == 0 {
+		return nil
+	}
+	return fs.rewrite()
+}
+
+// rewrite атомарно пишем снапшот и только потом обнуляем журнал, если упадем
+// между этими шагами - журнал просто накатится повторно, операции идемпотентны
+func (fs *FileStorage) rewrite() error {
+	err := writeFileAtomic(fs.filePath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, entry := range fs.store {
+			if entry == nil {
+				continue
+			}
+			if err := enc.Encode(entry); err != nil {
+				return fmt.Errorf("failed to encode entry: %w", err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	enc := json.NewEncoder(file)
+	// снапшот актуален - журнал больше не нужен
+	if err := fs.truncateJournal(); err != nil {
+		return err
+	}
+	fs.journalOps = 0
+	return nil
+}
+
+// truncateJournal обнуляем журнал, файл оставляем открытым для дозаписи
+func (fs *FileStorage) truncateJournal() error {
+	if fs.journal != nil {
+		if err := fs.journal.Truncate(0); err != nil {
+			return err
+		}
+		return fs.journal.Sync()
+	}
+	err := os.Truncate(fs.journalPath, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic пишем во временный файл рядом, fsync, rename поверх живого
+// и fsync каталога - либо старый файл, либо новый целиком, обрезков не бывает
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	// после успешного rename удалять уже нечего, ошибку игнорим
+	defer os.Remove(tmp.Name())
 
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	// rename попадет на диск только вместе с каталогом
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// load читаем снапшот, поверх накатываем журнал; битые записи не валят старт,
+// а уезжают в карантин рядом с файлом
+func (fs *FileStorage) load() error {
+	snapshotOK, snapshotBad, err := readRecords(fs.filePath, func(line []byte) error {
+		var entry fileEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if entry.ShortURL == "" {
+			return errors.New("empty short_url")
+		}
+		fs.applyCreate(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// перезаписи из импорта в журнале могут оставить url без ссылки в индексе
+	orphans := make(map[string]struct{})
+	journalOK, journalBad, err := readRecords(fs.journalPath, func(line []byte) error {
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		switch rec.Op {
+		case opCreate:
+			if key := fs.applyCreate(rec.fileEntry); key != "" {
+				orphans[key] = struct{}{}
+			}
+		case opDelete:
+			if entry, ok := fs.store[rec.ShortURL]; ok && entry.UserID == rec.UserID {
+				entry.IsDeleted = true
+				entry.DeletedAt = rec.DeletedAt
+			}
+		case opRestore:
+			if entry, ok := fs.store[rec.ShortURL]; ok && entry.UserID == rec.UserID {
+				entry.IsDeleted = false
+				entry.DeletedAt = nil
+			}
+		case opClick:
+			if entry, ok := fs.store[rec.ShortURL]; ok {
+				entry.ClicksLeft = rec.ClicksLeft
+			}
+		default:
+			return fmt.Errorf("unknown journal op %q", rec.Op)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fs.reindex(orphans)
+	fs.journalOps = journalOK
+
+	// записи из старых файлов без даты удаления - отсчитываем срок хранения с загрузки
+	// и сразу сохраняем дату, иначе каждый рестарт начинал бы отсчет заново
+	var stamped int
+	now := time.Now().UTC()
 	for _, entry := range fs.store {
-		if entry == nil {
+		if stampDeleted(entry, now) {
+			stamped++
+		}
+	}
+
+	// агрегаты и переходы грузим после ссылок: события уже очищенных ссылок просто пропускаем
+	var rolledUntil time.Time
+	_, rollupsBad, err := readRecords(fs.rollupsPath, func(line []byte) error {
+		var r ClickRollup
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		r.Day = r.Day.UTC()
+		if next := r.Day.AddDate(0, 0, 1); next.After(rolledUntil) {
+			rolledUntil = next
+		}
+		if _, ok := fs.store[r.ShortURL]; ok {
+			fs.clicks.addRollup(&r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(rollupsBad) > 0 {
+		qPath, err := quarantine(fs.rollupsPath, rollupsBad)
+		if err != nil {
+			return err
+		}
+		log.Printf("FileStorage: quarantined %d corrupted click rollups from %s to %s", len(rollupsBad), fs.rollupsPath, qPath)
+		if err := fs.rewriteRollups(); err != nil {
+			return err
+		}
+	}
+
+	// сворачиваем все старше границы сразу, и переходы пишутся с текущим временем -
+	// сырой переход раньше последних свернутых суток остался от прерванной свертки
+	var alreadyRolled int
+	_, clicksBad, err := readRecords(fs.clicksPath, func(line []byte) error {
+		var c Click
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		if c.At.Before(rolledUntil) {
+			alreadyRolled++
+			return nil
+		}
+		if _, ok := fs.store[c.ShortURL]; ok {
+			fs.clicks.add([]Click{c})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(clicksBad) > 0 {
+		qPath, err := quarantine(fs.clicksPath, clicksBad)
+		if err != nil {
+			return err
+		}
+		log.Printf("FileStorage: quarantined %d corrupted clicks from %s to %s", len(clicksBad), fs.clicksPath, qPath)
+	}
+	if len(clicksBad) > 0 || alreadyRolled > 0 {
+		if err := fs.rewriteClicks(); err != nil {
+			return err
+		}
+	}
+
+	fs.recovered = snapshotOK + journalOK
+	fs.quarantined = len(snapshotBad) + len(journalBad)
+
+	if fs.quarantined == 0 {
+		if stamped > 0 {
+			return fs.rewrite()
+		}
+		// после рестарта сразу сворачиваем накопленное, чтобы снапшот был свежим
+		return fs.compact()
+	}
+
+	for path, bad := range map[string][][]byte{fs.filePath: snapshotBad, fs.journalPath: journalBad} {
+		if len(bad) == 0 {
 			continue
 		}
-		if err := enc.Encode(entry); err != nil {
-			fmt.Printf("Failed to encode entry: %v\n", err)
+		qPath, err := quarantine(path, bad)
+		if err != nil {
+			return err
 		}
+		log.Printf("FileStorage: quarantined %d corrupted records from %s to %s", len(bad), path, qPath)
 	}
+	log.Printf("FileStorage: recovered %d records from %s", fs.recovered, fs.filePath)
 
-	return nil
+	// битые строки уже в карантине - переписываем снапшот начисто, журнал обнуляем
+	return fs.rewrite()
 }
 
-func (fs *FileStorage) load() error {
-	file, err := os.Open(fs.filePath)
+// readRecords построчно читает NDJSON файл и отдает строки в apply; строки,
+// которые не разобрались (обычно оборванная последняя запись после падения),
+// возвращаются отдельно, а не роняют загрузку
+func readRecords(path string, apply func(line []byte) error) (int, [][]byte, error) {
+	file, err := os.Open(path)
 	if os.IsNotExist(err) {
-		return nil
+		return 0, nil, nil
 	}
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	defer file.Close()
 
-	dec := json.NewDecoder(file)
+	var (
+		ok  int
+		bad [][]byte
+	)
+	reader := bufio.NewReader(file)
 	for {
-		var entry fileEntry
-		if err := dec.Decode(&entry); err != nil {
-			if err.Error() == "EOF" {
-				break
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return ok, bad, fmt.Errorf("failed to read %s: %w", path, readErr)
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if err := apply(trimmed); err != nil {
+				bad = append(bad, trimmed)
+			} else {
+				ok++
 			}
-			return fmt.Errorf("failed to decode JSON: %w", err)
 		}
 
-		fs.store[entry.ShortURL] = &entry
-		if entry.UserID != "" {
-			fs.userLinks[entry.UserID] = append(fs.userLinks[entry.UserID], entry.ShortURL)
+		if readErr != nil {
+			break
+		}
+	}
+	return ok, bad, nil
+}
+
+// quarantine складываем битые записи в отдельный файл, чтобы их можно было разобрать руками
+func quarantine(path string, records [][]byte) (string, error) {
+	qPath := fmt.Sprintf("%s.corrupt-%d", path, time.Now().UnixNano())
+	data := append(bytes.Join(records, []byte("\n")), '\n')
+	if err := os.WriteFile(qPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to quarantine corrupted records: %w", err)
+	}
+	return qPath, nil
+}
+
+// applyCreate кладем запись в store и поддерживаем индексы пользователя и url;
+// вернем ключ url перезаписанной записи, если индекс указывал на нее - см. reindex
+func (fs *FileStorage) applyCreate(entry fileEntry) string {
+	var orphan string
+	old, exists := fs.store[entry.ShortURL]
+	if exists && old.UserID != entry.UserID {
+		// сменился владелец (импорт) - проще выкинуть старую запись целиком
+		orphan = fs.remove(entry.ShortURL)
+		exists = false
+	}
+	if !exists && entry.UserID != "" {
+		fs.userLinks[entry.UserID] = append(fs.userLinks[entry.UserID], entry.ShortURL)
+	}
+	// short_id перезаписали - старый url больше никуда не ведет
+	if exists {
+		oldKey := fs.dedup.key(old.UserID, old.OriginalURL)
+		if fs.urlIndex[oldKey] == entry.ShortURL {
+			delete(fs.urlIndex, oldKey)
+			orphan = oldKey
+		}
+	}
+
+	e := entry
+	fs.store[entry.ShortURL] = &e
+
+	// первый сохранивший url остается его владельцем, как и с unique в бд
+	key := fs.dedup.key(entry.UserID, entry.OriginalURL)
+	if _, ok := fs.urlIndex[key]; !ok {
+		fs.urlIndex[key] = entry.ShortURL
+	}
+	return orphan
+}
+
+// stampDeleted удаленной записи без даты удаления проставляем now, вернем проставили ли
+func stampDeleted(entry *fileEntry, now time.Time) bool {
+	if !entry.IsDeleted || entry.DeletedAt != nil {
+		return false
+	}
+	entry.DeletedAt = &now
+	return true
+}
+
+// remove выкидываем запись вместе с индексами, вызывать под Lock; вернем ключ url,
+// если индекс указывал на эту запись, иначе ""
+func (fs *FileStorage) remove(shortID string) string {
+	entry, ok := fs.store[shortID]
+	if !ok {
+		return ""
+	}
+	delete(fs.store, shortID)
+
+	fs.userLinks[entry.UserID] = removeID(fs.userLinks[entry.UserID], shortID)
+	if len(fs.userLinks[entry.UserID]) == 0 {
+		delete(fs.userLinks, entry.UserID)
+	}
+	key := fs.dedup.key(entry.UserID, entry.OriginalURL)
+	if fs.urlIndex[key] != shortID {
+		return ""
+	}
+	delete(fs.urlIndex, key)
+	return key
+}
+
+// reindex как у MemoryStorage: ключ url без ссылки в индексе отдаем оставшейся записи
+// с меньшим short_id; вызывать под Lock
+func (fs *FileStorage) reindex(orphans map[string]struct{}) {
+	for key := range orphans {
+		if _, ok := fs.urlIndex[key]; ok {
+			delete(orphans, key)
+		}
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	for sid, entry := range fs.store {
+		key := fs.dedup.key(entry.UserID, entry.OriginalURL)
+		if _, ok := orphans[key]; !ok {
+			continue
+		}
+		if cur, ok := fs.urlIndex[key]; !ok || sid < cur {
+			fs.urlIndex[key] = sid
 		}
 	}
-	return nil
 }
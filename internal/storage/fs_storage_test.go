@@ -1,31 +1,42 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestFileStorage_SaveLoad - тестируем сохранение и загрузку
 func TestFileStorage_SaveLoad(t *testing.T) {
+	ctx := context.Background()
 	tempFile, err := os.CreateTemp("", "storage.json")
 	assert.NoError(t, err)
 	defer os.Remove(tempFile.Name())
+	defer os.Remove(tempFile.Name() + journalSuffix)
 
 	fs, err := NewFileStorage(tempFile.Name())
 	assert.NoError(t, err)
 
-	err = fs.Save("testID", "http://ya.ru")
+	err = fs.Save(ctx, "testID", "http://ya.ru")
 	assert.NoError(t, err)
 
-	url, foundErr := fs.Load("testID")
+	url, foundErr := fs.Load(ctx, "testID")
 	assert.Nil(t, foundErr)
 	assert.Equal(t, "http://ya.ru", url)
 }
 
 // TestFileStorage_EmptyFile - проверка работы с пустым файлом
 func TestFileStorage_EmptyFile(t *testing.T) {
+	ctx := context.Background()
 	tempFile, err := os.CreateTemp("", "empty.json")
 	assert.NoError(t, err)
 	defer os.Remove(tempFile.Name())
@@ -33,25 +44,91 @@ func TestFileStorage_EmptyFile(t *testing.T) {
 	fs, err := NewFileStorage(tempFile.Name())
 	assert.NoError(t, err)
 
-	url, foundErr := fs.Load("testID")
+	url, foundErr := fs.Load(ctx, "testID")
 	assert.ErrorIs(t, foundErr, ErrURLNotFound)
 	assert.Empty(t, url)
 }
 
+// TestFileStorage_CorruptFile - битый файл не роняет старт, мусор уезжает в карантин
 func TestFileStorage_CorruptFile(t *testing.T) {
-	testFile := "../../test_corrupt.json"
-	defer os.Remove(testFile)
+	testFile := filepath.Join(t.TempDir(), "test_corrupt.json")
 
 	err := os.WriteFile(testFile, []byte("invalid json"), 0644)
 	assert.NoError(t, err, "Ошибка при создании тестового файла")
 
-	_, err = NewFileStorage(testFile)
-	assert.Error(t, err, "Ожидалась ошибка на поврежденном файле")
+	fs, err := NewFileStorage(testFile)
+	require.NoError(t, err, "Поврежденный файл не должен ронять загрузку")
+	assert.Equal(t, 0, fs.recovered)
+	assert.Equal(t, 1, fs.quarantined)
+
+	quarantined, err := filepath.Glob(testFile + ".corrupt-*")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	data, err := os.ReadFile(quarantined[0])
+	require.NoError(t, err)
+	assert.Equal(t, "invalid json\n", string(data))
+}
+
+// TestFileStorage_TornRecord - оборванная последняя запись журнала (упали посреди записи)
+func TestFileStorage_TornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+
+	// отрезаем хвост последней записи
+	info, err := os.Stat(path + journalSuffix)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path+journalSuffix, info.Size()-10))
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.recovered)
+	assert.Equal(t, 1, restored.quarantined)
+
+	url, err := restored.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+	_, err = restored.Load(ctx, "id2")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+
+	// после восстановления снапшот чистый, журнал пустой и в него можно писать дальше
+	require.NoError(t, restored.SaveUserURL(ctx, "u1", "id3", "http://go.dev"))
+	again, err := NewFileStorage(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.recovered)
+	assert.Equal(t, 0, again.quarantined)
+}
+
+// TestWriteFileAtomic - при ошибке записи старый файл остается целым
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0644))
+
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		w.Write([]byte("half"))
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old\n", string(data))
+
+	// временные файлы за собой не оставляем
+	leftovers, err := filepath.Glob(path + ".tmp-*")
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
 }
 
 func TestFileStorage_Permissions(t *testing.T) {
+	ctx := context.Background()
 	testFile := "test_permissions.json"
 	defer os.Remove(testFile)
+	defer os.Remove(testFile + journalSuffix)
 
 	file, err := os.Create(testFile)
 	assert.NoError(t, err)
@@ -62,17 +139,529 @@ func TestFileStorage_Permissions(t *testing.T) {
 
 	storage, _ := NewFileStorage(testFile)
 
-	storage.Save("test", "http://ya.ru")
+	storage.Save(ctx, "test", "http://ya.ru")
 
 	os.Chmod(testFile, 0644)
 }
 
+// TestFileStorage_JournalReplay - операции пишутся в журнал и переживают рестарт
+func TestFileStorage_JournalReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveBatchUserURLs(ctx, "u1", map[string]string{"id2": "http://github.com"}))
+	require.NoError(t, fs.MarkUserURLsDeleted(ctx, "u1", []string{"id2"}))
+
+	// снапшот еще не писали, все только в журнале
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	journal, err := os.ReadFile(path + journalSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 3, bytes.Count(journal, []byte("\n")))
+
+	// "рестарт" без Close
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	url, err := restored.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+	_, err = restored.Load(ctx, "id2")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+
+	// при загрузке журнал свернулся в снапшот
+	journal, err = os.ReadFile(path + journalSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, journal)
+}
+
+// TestFileStorage_BatchConflict - занятый short_id батч не перезаписывает ни в памяти, ни в журнале
+func TestFileStorage_BatchConflict(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+
+	var conflict *BatchConflictError
+	require.ErrorAs(t, fs.SaveBatchLinks(ctx, "u2", []Link{
+		{ShortURL: "id1", OriginalURL: "http://github.com"},
+		{ShortURL: "id2", OriginalURL: "http://go.dev"},
+		{ShortURL: "id2", OriginalURL: "http://golang.org"},
+	}), &conflict)
+	assert.Equal(t, []int{0, 2}, conflict.Indexes)
+	assert.ErrorIs(t, conflict, ErrShortIDConflict)
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	url, err := restored.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+	url, err = restored.Load(ctx, "id2")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://go.dev", url)
+}
+
+// TestFileStorage_Compact - по порогу журнал сворачивается в снапшот старого формата
+func TestFileStorage_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	fs.compactThreshold = 2
+
+	require.NoError(t, fs.Save(ctx, "id1", "http://ya.ru"))
+	require.NoError(t, fs.Save(ctx, "id2", "http://github.com"))
+
+	journal, err := os.ReadFile(path + journalSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, journal)
+
+	// снапшот читается построчно как раньше
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	entries := make(map[string]string)
+	for dec.More() {
+		var entry fileEntry
+		require.NoError(t, dec.Decode(&entry))
+		entries[entry.ShortURL] = entry.OriginalURL
+	}
+	assert.Equal(t, map[string]string{"id1": "http://ya.ru", "id2": "http://github.com"}, entries)
+
+	require.NoError(t, fs.Save(ctx, "id3", "http://go.dev"))
+	require.NoError(t, fs.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	url, err := restored.Load(ctx, "id3")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://go.dev", url)
+}
+
 func TestFileStorage_PingClose(t *testing.T) {
 	ms := NewMemoryStorage()
 
-	ping := ms.Ping()
+	ping := ms.Ping(context.Background())
 	assert.Error(t, ping)
 
 	close := ms.Close()
 	assert.Nil(t, close)
 }
+
+// TestFileStorage_URLIndex - индекс восстанавливается из снапшота и журнала
+func TestFileStorage_URLIndex(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveBatchUserURLs(ctx, "u1", map[string]string{"id2": "http://github.com"}))
+	assert.ErrorIs(t, fs.SaveUserURL(ctx, "u2", "id3", "http://github.com"), ErrURLConflict)
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	id, err := restored.FindIDByURL(ctx, "", "http://github.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "id2", id)
+	assert.ErrorIs(t, restored.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"), ErrURLConflict)
+}
+
+// TestFileStorage_DedupPerUser - у каждого пользователя своя ссылка, и после рестарта тоже
+func TestFileStorage_DedupPerUser(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path, WithDedupScope(DedupPerUser))
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u2", "id2", "http://ya.ru"))
+
+	restored, err := NewFileStorage(path, WithDedupScope(DedupPerUser))
+	require.NoError(t, err)
+	assert.ErrorIs(t, restored.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"), ErrURLConflict)
+
+	id, err := restored.FindIDByURL(ctx, "u1", "http://ya.ru")
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", id)
+}
+
+// TestFileStorage_PurgeDeleted - дата удаления переживает рестарт, очистка освобождает url
+func TestFileStorage_PurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+	require.NoError(t, fs.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NotNil(t, restored.store["id1"].DeletedAt)
+
+	purged, err := restored.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+
+	purged, err = restored.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	again, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = again.Load(ctx, "id1")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+	assert.NoError(t, again.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"))
+
+	urls, err := again.GetUserURLs(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, []UserURL{{ShortURL: "id2", OriginalURL: "http://github.com"}}, urls)
+}
+
+// TestFileStorage_UndatedDeleted - дату удаления для старых записей ставим один раз,
+// рестарт не сбрасывает срок хранения
+func TestFileStorage_UndatedDeleted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"short_url":"id1","original_url":"http://ya.ru","user_id":"u1","is_deleted":true}`+"\n"), 0644))
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NotNil(t, fs.store["id1"].DeletedAt)
+	first := *fs.store["id1"].DeletedAt
+
+	time.Sleep(10 * time.Millisecond)
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NotNil(t, restored.store["id1"].DeletedAt)
+	assert.True(t, first.Equal(*restored.store["id1"].DeletedAt))
+
+	purged, err := restored.PurgeDeleted(ctx, first.Add(time.Millisecond))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, purged)
+}
+
+// TestFileStorage_Restore - восстановление попадает в журнал и переживает рестарт
+func TestFileStorage_Restore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+	require.NoError(t, fs.MarkUserURLsDeleted(ctx, "u1", []string{"id1", "id2"}))
+
+	res, err := fs.RestoreUserURLs(ctx, "u2", []string{"id1"}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, RestoreNotFound, res["id1"])
+
+	res, err = fs.RestoreUserURLs(ctx, "u1", []string{"id1"}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, RestoreOK, res["id1"])
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	url, err := restored.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+	_, err = restored.Load(ctx, "id2")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+
+	// удалили снова - снова можно очистить
+	require.NoError(t, restored.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+	purged, err := restored.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
+
+// TestFileStorage_Expiry - срок жизни переживает рестарт
+func TestFileStorage_Expiry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	past := time.Now().Add(-time.Second).UTC()
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveLink(ctx, "u1", Link{ShortURL: "old", OriginalURL: "http://old.ru", ExpiresAt: &past}))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "forever", "http://forever.ru"))
+	require.NoError(t, fs.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = restored.Load(ctx, "old")
+	assert.ErrorIs(t, err, ErrURLExpired)
+	link, err := restored.LoadLink(ctx, "forever")
+	assert.NoError(t, err)
+	assert.Nil(t, link.ExpiresAt)
+}
+
+// TestFileStorage_ConsumeLink - потраченные переходы переживают рестарт
+func TestFileStorage_ConsumeLink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	limit := int64(2)
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveLink(ctx, "u1", Link{ShortURL: "dl", OriginalURL: "http://dl.ru", ClicksLeft: &limit}))
+	link, err := fs.ConsumeLink(ctx, "dl")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, *link.ClicksLeft)
+	// закрываем без сворачивания - переход должен подняться из журнала
+	require.NoError(t, fs.journal.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = restored.ConsumeLink(ctx, "dl")
+	require.NoError(t, err)
+	_, err = restored.ConsumeLink(ctx, "dl")
+	assert.ErrorIs(t, err, ErrURLExhausted)
+	require.NoError(t, restored.Close())
+
+	again, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = again.Load(ctx, "dl")
+	assert.ErrorIs(t, err, ErrURLExhausted)
+}
+
+// TestFileStorage_ConsumeLinkCompact - переход, на котором журнал свернулся в снапшот,
+// попадает в снапшот, а не теряется вместе с журналом
+func TestFileStorage_ConsumeLinkCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	limit := int64(1)
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	fs.compactThreshold = 2
+	require.NoError(t, fs.SaveLink(ctx, "u1", Link{ShortURL: "once", OriginalURL: "http://once.ru", ClicksLeft: &limit}))
+	// вторая запись в журнале - ровно на пороге
+	_, err = fs.ConsumeLink(ctx, "once")
+	require.NoError(t, err)
+
+	journal, err := os.ReadFile(path + journalSuffix)
+	require.NoError(t, err)
+	require.Empty(t, journal)
+	require.NoError(t, fs.journal.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = restored.ConsumeLink(ctx, "once")
+	assert.ErrorIs(t, err, ErrURLExhausted)
+}
+
+// TestFileStorage_JournalWriteFailure - не записали журнал - не меняем и память,
+// иначе отдавали бы то, что пропадет на рестарте
+func TestFileStorage_JournalWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	limit := int64(1)
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "gone", "http://gone.ru"))
+	require.NoError(t, fs.SaveLink(ctx, "u1", Link{ShortURL: "once", OriginalURL: "http://once.ru", ClicksLeft: &limit}))
+	require.NoError(t, fs.MarkUserURLsDeleted(ctx, "u1", []string{"gone"}))
+
+	// журнал только на чтение - любая запись в него падает
+	require.NoError(t, fs.journal.Close())
+	fs.journal, err = os.Open(path + journalSuffix)
+	require.NoError(t, err)
+
+	assert.Error(t, fs.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+	assert.Error(t, fs.SaveBatchLinks(ctx, "u1", []Link{{ShortURL: "id3", OriginalURL: "http://go.dev"}}))
+	assert.Error(t, fs.Import(ctx, []Record{{ShortURL: "id4", OriginalURL: "http://golang.org", UserID: "u1"}}))
+	assert.Error(t, fs.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+	_, err = fs.RestoreUserURLs(ctx, "u1", []string{"gone"}, time.Now().Add(-time.Hour))
+	assert.Error(t, err)
+	_, err = fs.ConsumeLink(ctx, "once")
+	assert.Error(t, err)
+
+	check := func(t *testing.T, fs *FileStorage) {
+		for _, id := range []string{"id2", "id3", "id4"} {
+			_, err := fs.Load(ctx, id)
+			assert.ErrorIs(t, err, ErrURLNotFound, id)
+		}
+		_, err := fs.FindIDByURL(ctx, "u1", "http://github.com")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		_, err = fs.Load(ctx, "id1")
+		assert.NoError(t, err)
+		_, err = fs.Load(ctx, "gone")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+		link, err := fs.LoadLink(ctx, "once")
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, *link.ClicksLeft)
+	}
+	check(t, fs)
+
+	require.NoError(t, fs.journal.Close())
+	fs.journal = nil
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	check(t, restored)
+}
+
+// TestFileStorage_CompactFailure - запись уже в журнале, так что упавшее сворачивание
+// не ошибка операции: ссылка отдается и переживает рестарт
+func TestFileStorage_CompactFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	fs.compactThreshold = 1
+	// снапшот некуда писать
+	fs.filePath = filepath.Join(t.TempDir(), "missing", "storage.json")
+
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	_, err = fs.Load(ctx, "id1")
+	assert.NoError(t, err)
+	require.NoError(t, fs.journal.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	url, err := restored.Load(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", url)
+}
+
+// BenchmarkFileStorage_Conflict - проверка конфликта не зависит от размера хранилища
+func BenchmarkFileStorage_Conflict(b *testing.B) {
+	for _, size := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			fs, err := NewFileStorage(filepath.Join(b.TempDir(), "storage.json"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			batch := make(map[string]string, size)
+			for i := 0; i < size; i++ {
+				batch[fmt.Sprintf("id%d", i)] = fmt.Sprintf("http://example.com/%d", i)
+			}
+			if err := fs.SaveBatchUserURLs(ctx, "u", batch); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				url := fmt.Sprintf("http://example.com/%d", i%size)
+				if err := fs.SaveUserURL(ctx, "u", "new", url); err != ErrURLConflict {
+					b.Fatalf("expected conflict, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestFileStorage_Clicks - переходы в своем файле переживают рестарт, битая строка уходит в карантин
+func TestFileStorage_Clicks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "storage.json")
+	at := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+	require.NoError(t, fs.SaveClicks(ctx, []Click{
+		{ShortURL: "id1", At: at, Referrer: "http://t.me", IPHash: "abc"},
+		{ShortURL: "id1", At: at.Add(time.Minute)},
+		{ShortURL: "id2", At: at},
+	}))
+	require.NoError(t, fs.Close())
+
+	// оборванная запись в конце, как после падения посреди write
+	file, err := os.OpenFile(path+clicksSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"short_url":"id1","at":"2026-`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	q := ClickQuery{From: at.Add(-time.Hour), To: at.Add(time.Hour), Bucket: BucketHour}
+	stats, err := restored.ClickStats(ctx, "u1", "id1", q)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stats.Total)
+	assert.Equal(t, []ClickBucket{{Start: at.Truncate(time.Hour), Clicks: 2}}, stats.Buckets)
+	// скетчи уникальных собираются заново из файла переходов
+	require.Len(t, stats.Visitors, 1)
+	assert.EqualValues(t, 1, stats.Visitors[0].Visitors.Count())
+	assert.Equal(t, "http://t.me", restored.clicks.events["id1"][0].Referrer)
+
+	quarantined, err := filepath.Glob(filepath.Join(dir, "storage.json.clicks.corrupt-*"))
+	require.NoError(t, err)
+	assert.Len(t, quarantined, 1)
+
+	// очистка ссылки переписывает файл переходов без нее
+	require.NoError(t, restored.MarkUserURLsDeleted(ctx, "u1", []string{"id2"}))
+	_, err = restored.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.NoError(t, restored.SaveClicks(ctx, []Click{{ShortURL: "id1", At: at}}))
+	require.NoError(t, restored.Close())
+
+	again, err := NewFileStorage(path)
+	require.NoError(t, err)
+	assert.Len(t, again.clicks.events["id1"], 3)
+	assert.NotContains(t, again.clicks.events, "id2")
+}
+
+// TestFileStorage_RollupClicks - агрегаты переживают рестарт, а сырые переходы, оставшиеся
+// от прерванной свертки, второй раз не считаются
+func TestFileStorage_RollupClicks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "storage.json")
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	old := Click{ShortURL: "id1", At: day.Add(time.Hour), IPHash: "aa", Referrer: "https://t.me/chan"}
+
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, fs.SaveClicks(ctx, []Click{
+		old,
+		{ShortURL: "id1", At: day.Add(2 * time.Hour), IPHash: "bb"},
+		{ShortURL: "id1", At: day.Add(26 * time.Hour), IPHash: "aa"},
+	}))
+	rolled, err := fs.RollupClicks(ctx, day.Add(24*time.Hour), 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, rolled, "файл сворачивается целиком, limit не важен")
+	require.NoError(t, fs.Close())
+
+	// как будто упали после записи агрегатов, но до перезаписи сырых
+	line, err := json.Marshal(old)
+	require.NoError(t, err)
+	file, err := os.OpenFile(path+clicksSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write(append(line, '\n'))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+	assert.Len(t, restored.clicks.events["id1"], 1)
+
+	stats, err := restored.ClickStats(ctx, "u1", "id1", ClickQuery{From: day, To: day.Add(48 * time.Hour), Bucket: BucketDay})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, stats.Total)
+	assert.Equal(t, []ClickBucket{{Start: day, Clicks: 2}, {Start: day.Add(24 * time.Hour), Clicks: 1}}, stats.Buckets)
+	assert.EqualValues(t, 1, stats.Referrers["t.me"])
+	// уникальные свернутых суток подняли из агрегата
+	require.Len(t, stats.Visitors, 2)
+	assert.EqualValues(t, 2, stats.Visitors[0].Visitors.Count())
+}
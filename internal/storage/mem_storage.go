@@ -1,15 +1,125 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/config"
 )
 
+const memScheme = "mem://"
+
+func init() {
+	// mem://?shards=N - шардированный вариант для конкурентной записи
+	Register("mem", func(location string, cfg *config.Config, opts ...Option) (Storager, error) {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mem storage location %q: %w", location, err)
+		}
+		raw := u.Query().Get("shards")
+		if raw == "" {
+			return NewMemoryStorage(opts...), nil
+		}
+		shards, err := strconv.Atoi(raw)
+		if err != nil || shards <= 0 {
+			return nil, fmt.Errorf("invalid shards value %q: want positive integer", raw)
+		}
+		return NewShardedMemoryStorage(shards, opts...), nil
+	})
+}
+
 // memEntry локальная структура хранения
 type memEntry struct {
 	originalURL string
 	userID      string
 	isDeleted   bool
+	deletedAt   time.Time
+	expiresAt   *time.Time
+	clicksLeft  *int64
+	password    string // bcrypt хэш
+}
+
+// link ссылка из записи, счетчик копируем - наружу указатель на живой не отдаем
+func (e *memEntry) link(shortID string) Link {
+	return Link{
+		ShortURL:     shortID,
+		OriginalURL:  e.originalURL,
+		ExpiresAt:    e.expiresAt,
+		ClicksLeft:   copyInt64(e.clicksLeft),
+		PasswordHash: e.password,
+	}
+}
+
+// newMemEntry запись из ссылки, счетчик тоже копируем
+func newMemEntry(userID string, link Link) *memEntry {
+	return &memEntry{
+		originalURL: link.OriginalURL,
+		userID:      userID,
+		expiresAt:   link.ExpiresAt,
+		clicksLeft:  copyInt64(link.ClicksLeft),
+		password:    link.PasswordHash,
+	}
+}
+
+// userURL запись для списка ссылок пользователя
+func (e *memEntry) userURL(shortID string) UserURL {
+	return UserURL{
+		ShortURL:    shortID,
+		OriginalURL: e.originalURL,
+		ExpiresAt:   e.expiresAt,
+		ClicksLeft:  copyInt64(e.clicksLeft),
+		Protected:   e.password != "",
+	}
+}
+
+// record переносимая запись для Export
+func (e *memEntry) record(shortID string) Record {
+	rec := Record{
+		ShortURL:     shortID,
+		OriginalURL:  e.originalURL,
+		UserID:       e.userID,
+		IsDeleted:    e.isDeleted,
+		ExpiresAt:    e.expiresAt,
+		ClicksLeft:   copyInt64(e.clicksLeft),
+		PasswordHash: e.password,
+	}
+	if e.isDeleted {
+		deletedAt := e.deletedAt
+		rec.DeletedAt = &deletedAt
+	}
+	return rec
+}
+
+// memEntryFromRecord запись из Import; удаленная без даты - отсчитываем срок хранения с импорта
+func memEntryFromRecord(rec Record) *memEntry {
+	entry := newMemEntry(rec.UserID, Link{
+		OriginalURL:  rec.OriginalURL,
+		ExpiresAt:    rec.ExpiresAt,
+		ClicksLeft:   rec.ClicksLeft,
+		PasswordHash: rec.PasswordHash,
+	})
+	entry.isDeleted = rec.IsDeleted
+	if rec.IsDeleted {
+		entry.deletedAt = time.Now()
+		if rec.DeletedAt != nil {
+			entry.deletedAt = *rec.DeletedAt
+		}
+	}
+	return entry
+}
+
+func copyInt64(v *int64) *int64 {
+	if v == nil {
+		return nil
+	}
+	n := *v
+	return &n
 }
 
 // MemoryStorage реализация in-memory хранилища
@@ -17,104 +127,158 @@ type MemoryStorage struct {
 	sync.RWMutex
 	store     map[string]*memEntry // short -> данные
 	userLinks map[string][]string  // user->[]shortIDs
+	urlIndex  map[string]string    // original -> short, обратный индекс для конфликтов
+	clicks    clickLog
+	dedup     DedupScope
+	seq       atomic.Int64
 }
 
 // NewMemoryStorage запускатор "соединения" с памятью, аналогия на NewDatabase
-func NewMemoryStorage() *MemoryStorage {
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	o := newOptions(opts)
 	return &MemoryStorage{
 		store:     make(map[string]*memEntry),
 		userLinks: make(map[string][]string),
+		urlIndex:  make(map[string]string),
+		clicks:    newClickLog(),
+		dedup:     o.dedup,
 	}
 }
 
 // -- методы из старого интерфейса --
 
-func (ms *MemoryStorage) Save(id, url string) error {
-	return ms.SaveUserURL("", id, url)
+func (ms *MemoryStorage) Save(ctx context.Context, id, url string) error {
+	return ms.SaveUserURL(ctx, "", id, url)
 }
 
-func (ms *MemoryStorage) SaveBatch(urls map[string]string) error {
-	return ms.SaveBatchUserURLs("", urls)
+func (ms *MemoryStorage) SaveBatch(ctx context.Context, urls map[string]string) error {
+	return ms.SaveBatchUserURLs(ctx, "", urls)
 }
 
-func (ms *MemoryStorage) Load(id string) (string, error) {
-	ms.RLock()
-	defer ms.RUnlock()
-	entry, exists := ms.store[id]
-	if !exists {
-		return "", ErrURLNotFound
-	}
-	if entry.isDeleted {
-		return "", ErrURLDeleted
-	}
-	return entry.originalURL, nil
+func (ms *MemoryStorage) Load(ctx context.Context, id string) (string, error) {
+	link, err := ms.LoadLink(ctx, id)
+	return link.OriginalURL, err
 }
 
-func (ms *MemoryStorage) FindIDByURL(url string) (string, error) {
+func (ms *MemoryStorage) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
 	ms.RLock()
 	defer ms.RUnlock()
-	for shortID, entry := range ms.store {
-		if entry.originalURL == url {
-			return shortID, nil
-		}
+	if shortID, ok := ms.urlIndex[ms.dedup.key(userID, url)]; ok {
+		return shortID, nil
 	}
 	return "", ErrURLNotFound
 }
 
 // ну, тут тоже как бы странно было бы закрывать память, но можно че-нить
 // по OOM и прочим приколам попробовать реализовать, но наверно, такое не случится
-func (ms *MemoryStorage) Ping() error  { return errors.New("there is no connection: mem001") }
+func (ms *MemoryStorage) Ping(ctx context.Context) error {
+	return errors.New("there is no connection: mem001")
+}
 func (ms *MemoryStorage) Close() error { return nil }
 
+// NextSequence счетчик живет вместе с данными, с нуля после рестарта - как и они
+func (ms *MemoryStorage) NextSequence(ctx context.Context) (int64, error) {
+	return ms.seq.Add(1), nil
+}
+
 // -- методы с юид --
 
-func (ms *MemoryStorage) SaveUserURL(userID, shortID, originalURL string) error {
+func (ms *MemoryStorage) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	return ms.SaveLink(ctx, userID, Link{ShortURL: shortID, OriginalURL: originalURL})
+}
+
+func (ms *MemoryStorage) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
+	return ms.SaveBatchLinks(ctx, userID, linksFromMap(batch))
+}
+
+func (ms *MemoryStorage) LoadLink(ctx context.Context, id string) (Link, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	entry, exists := ms.store[id]
+	if !exists {
+		return Link{}, ErrURLNotFound
+	}
+	if entry.isDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	link := entry.link(id)
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// ConsumeLink проверка и списание под одним Lock, так что лишний переход не проскочит
+func (ms *MemoryStorage) ConsumeLink(ctx context.Context, id string) (Link, error) {
 	ms.Lock()
 	defer ms.Unlock()
+	entry, exists := ms.store[id]
+	if !exists {
+		return Link{}, ErrURLNotFound
+	}
+	if entry.isDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	link := entry.link(id)
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	link = link.consumed()
+	entry.clicksLeft = copyInt64(link.ClicksLeft)
+	return link, nil
+}
 
-	// если какой-то другой shortID уже хранит этот url - вернём конфликт
-	for existID, entry := range ms.store {
-		if entry.originalURL == originalURL && existID != shortID {
+func (ms *MemoryStorage) SaveLink(ctx context.Context, userID string, link Link) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	shortID, originalURL := link.ShortURL, link.OriginalURL
+
+	// если какой-то другой shortID уже хранит этот url (в пределах dedup) - вернём конфликт
+	if ms.dedup != DedupNone {
+		if existID, ok := ms.urlIndex[ms.dedup.key(userID, originalURL)]; ok && existID != shortID {
 			return ErrURLConflict
 		}
 	}
 
-	// если такой shortID уже есть - это конфликт по short_id
-	if oldEntry, ok := ms.store[shortID]; ok && oldEntry.originalURL != originalURL {
+	// shortID уже занят - конфликт, даже если там та же ссылка: как и бд, не перезаписываем,
+	// иначе повторное сохранение вернуло бы удаленную ссылку и сбросило лимит переходов
+	if _, ok := ms.store[shortID]; ok {
 		return ErrShortIDConflict
 	}
 
-	ms.store[shortID] = &memEntry{
-		originalURL: originalURL,
-		userID:      userID,
-		isDeleted:   false,
-	}
-
-	if userID != "" {
-		ms.userLinks[userID] = append(ms.userLinks[userID], shortID)
-	}
+	ms.put(shortID, newMemEntry(userID, link))
 
 	return nil
 }
 
-func (ms *MemoryStorage) SaveBatchUserURLs(userID string, batch map[string]string) error {
+func (ms *MemoryStorage) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
 	ms.Lock()
 	defer ms.Unlock()
 
-	for shortID, originalURL := range batch {
-		ms.store[shortID] = &memEntry{
-			originalURL: originalURL,
-			userID:      userID,
-			isDeleted:   false,
+	var conflicts []int
+	existing := make(map[int]string)
+	for i, link := range links {
+		// url уже сокращен (в том числе раньше в этом батче) - отдаем его short_id
+		if ms.dedup != DedupNone {
+			if existID, ok := ms.urlIndex[ms.dedup.key(userID, link.OriginalURL)]; ok && existID != link.ShortURL {
+				existing[i] = existID
+				continue
+			}
 		}
-		if userID != "" {
-			ms.userLinks[userID] = append(ms.userLinks[userID], shortID)
+		if old, ok := ms.store[link.ShortURL]; ok {
+			if old.originalURL != link.OriginalURL || old.userID != userID {
+				conflicts = append(conflicts, i)
+			}
+			// та же ссылка уже сохранена - как и бд, не перезаписываем
+			continue
 		}
+		ms.put(link.ShortURL, newMemEntry(userID, link))
 	}
-	return nil
+	return batchConflict(conflicts, existing)
 }
 
-func (ms *MemoryStorage) GetUserURLs(userID string) ([]UserURL, error) {
+func (ms *MemoryStorage) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
 	ms.RLock()
 	defer ms.RUnlock()
 
@@ -127,21 +291,213 @@ func (ms *MemoryStorage) GetUserURLs(userID string) ([]UserURL, error) {
 	for _, sid := range shortIDs {
 		entry := ms.store[sid]
 		if entry != nil && !entry.isDeleted {
-			result = append(result, UserURL{ShortURL: sid, OriginalURL: entry.originalURL})
+			result = append(result, entry.userURL(sid))
 		}
 	}
 	return result, nil
 }
 
-func (ms *MemoryStorage) MarkUserURLsDeleted(userID string, shortIDs []string) error {
+func (ms *MemoryStorage) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
 	ms.Lock()
 	defer ms.Unlock()
 
 	for _, sid := range shortIDs {
 		entry, exists := ms.store[sid]
-		if exists && entry.userID == userID {
+		if exists && entry.userID == userID && !entry.isDeleted {
 			entry.isDeleted = true
+			entry.deletedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	result := make(map[string]RestoreStatus, len(shortIDs))
+	for _, sid := range shortIDs {
+		entry, exists := ms.store[sid]
+		switch {
+		case !exists || entry.userID != userID:
+			result[sid] = RestoreNotFound
+		case !entry.isDeleted:
+			result[sid] = RestoreNotDeleted
+		case entry.deletedAt.Before(since):
+			result[sid] = RestoreExpired
+		default:
+			entry.isDeleted = false
+			entry.deletedAt = time.Time{}
+			result[sid] = RestoreOK
+		}
+	}
+	return result, nil
+}
+
+func (ms *MemoryStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var purged int64
+	orphans := make(map[string]struct{})
+	for sid, entry := range ms.store {
+		if entry.isDeleted && entry.deletedAt.Before(before) {
+			if key := ms.remove(sid); key != "" {
+				orphans[key] = struct{}{}
+			}
+			// id снова свободен - чужая статистика новой ссылке не нужна
+			ms.clicks.drop(sid)
+			purged++
+		}
+	}
+	ms.reindex(orphans)
+	return purged, nil
+}
+
+func (ms *MemoryStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.clicks.add(clicks)
+	return nil
+}
+
+// ClickStats статистику видит только владелец, удаленные ссылки тоже считаем
+func (ms *MemoryStorage) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	entry, ok := ms.store[shortID]
+	if !ok || entry.userID != userID {
+		return ClickStats{}, ErrURLNotFound
+	}
+	return ms.clicks.stats(shortID, q), nil
+}
+
+func (ms *MemoryStorage) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.clicks.rollup(before, limit), nil
+}
+
+func (ms *MemoryStorage) Export(ctx context.Context, after string, fn func(Record) error) error {
+	// копируем под локом, колбэк зовем уже без него
+	ms.RLock()
+	records := make([]Record, 0, len(ms.store))
+	for sid, entry := range ms.store {
+		if sid > after {
+			records = append(records, entry.record(sid))
+		}
+	}
+	ms.RUnlock()
+
+	return exportSorted(ctx, records, fn)
+}
+
+func (ms *MemoryStorage) Import(ctx context.Context, records []Record) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	orphans := make(map[string]struct{})
+	for _, rec := range records {
+		// перезапись: сначала убираем старую запись со всеми индексами
+		if key := ms.remove(rec.ShortURL); key != "" {
+			orphans[key] = struct{}{}
 		}
+		ms.put(rec.ShortURL, memEntryFromRecord(rec))
 	}
+	ms.reindex(orphans)
 	return nil
 }
+
+func (ms *MemoryStorage) Count(ctx context.Context) (int64, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return int64(len(ms.store)), nil
+}
+
+// put кладем запись и поддерживаем индексы, вызывать под Lock
+func (ms *MemoryStorage) put(shortID string, entry *memEntry) {
+	old, exists := ms.store[shortID]
+	if exists {
+		// short_id перезаписали - старый url больше никуда не ведет
+		oldKey := ms.dedup.key(old.userID, old.originalURL)
+		if ms.urlIndex[oldKey] == shortID {
+			delete(ms.urlIndex, oldKey)
+		}
+	}
+	ms.store[shortID] = entry
+
+	// первый сохранивший url остается его владельцем, как и с unique в бд
+	key := ms.dedup.key(entry.userID, entry.originalURL)
+	if _, ok := ms.urlIndex[key]; !ok {
+		ms.urlIndex[key] = shortID
+	}
+
+	// повторное сохранение тем же владельцем не дублирует id в его списке
+	sameOwner := exists && old.userID == entry.userID
+	if exists && !sameOwner {
+		ms.unlinkUser(old.userID, shortID)
+	}
+	if entry.userID != "" && !sameOwner {
+		ms.userLinks[entry.userID] = append(ms.userLinks[entry.userID], shortID)
+	}
+}
+
+// remove выкидываем запись вместе с индексами, вызывать под Lock
+// вернем ключ url, если индекс указывал на эту запись, иначе "" - см. reindex
+func (ms *MemoryStorage) remove(shortID string) string {
+	entry, ok := ms.store[shortID]
+	if !ok {
+		return ""
+	}
+	delete(ms.store, shortID)
+	ms.unlinkUser(entry.userID, shortID)
+
+	key := ms.dedup.key(entry.userID, entry.originalURL)
+	if ms.urlIndex[key] != shortID {
+		return ""
+	}
+	delete(ms.urlIndex, key)
+	return key
+}
+
+// reindex ключи url, чья ссылка из индекса пропала, отдаем оставшейся записи с тем же
+// ключом (при dedup none или после Import их бывает несколько), иначе FindIDByURL
+// ее не найдет; из нескольких берем меньший short_id. Вызывать под Lock
+func (ms *MemoryStorage) reindex(orphans map[string]struct{}) {
+	for key := range orphans {
+		if _, ok := ms.urlIndex[key]; ok {
+			delete(orphans, key)
+		}
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	for sid, entry := range ms.store {
+		key := ms.dedup.key(entry.userID, entry.originalURL)
+		if _, ok := orphans[key]; !ok {
+			continue
+		}
+		if cur, ok := ms.urlIndex[key]; !ok || sid < cur {
+			ms.urlIndex[key] = sid
+		}
+	}
+}
+
+// unlinkUser убираем id из списка ссылок пользователя, вызывать под Lock
+func (ms *MemoryStorage) unlinkUser(userID, shortID string) {
+	ms.userLinks[userID] = removeID(ms.userLinks[userID], shortID)
+	if len(ms.userLinks[userID]) == 0 {
+		delete(ms.userLinks, userID)
+	}
+}
+
+// removeID выкидываем id из списка ссылок пользователя
+func removeID(ids []string, id string) []string {
+	out := ids[:0]
+	for _, sid := range ids {
+		if sid != id {
+			out = append(out, sid)
+		}
+	}
+	return out
+}
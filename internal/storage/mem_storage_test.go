@@ -1,20 +1,27 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestMemoryStorage_SaveLoad - тестируем сохранение и загрузку
 func TestMemoryStorage_SaveLoad(t *testing.T) {
 	ms := NewMemoryStorage()
 
-	err := ms.Save("testKey", "http://ya.ru")
+	err := ms.Save(context.Background(), "testKey", "http://ya.ru")
 	assert.NoError(t, err)
 
 	// Загружаем обратно
-	url, foundErr := ms.Load("testKey")
+	url, foundErr := ms.Load(context.Background(), "testKey")
 	assert.Nil(t, foundErr)
 	assert.Equal(t, "http://ya.ru", url)
 }
@@ -24,7 +31,7 @@ func TestMemoryStorage_NotFound(t *testing.T) {
 	ms := NewMemoryStorage()
 
 	// Загружаем несуществующий ключ
-	url, foundErr := ms.Load("missing")
+	url, foundErr := ms.Load(context.Background(), "missing")
 	assert.ErrorIs(t, foundErr, ErrURLNotFound)
 	assert.Empty(t, url)
 }
@@ -32,9 +39,404 @@ func TestMemoryStorage_NotFound(t *testing.T) {
 func TestMemoryStorage_PingClose(t *testing.T) {
 	ms := NewMemoryStorage()
 
-	ping := ms.Ping()
+	ping := ms.Ping(context.Background())
 	assert.Error(t, ping)
 
 	close := ms.Close()
 	assert.Nil(t, close)
 }
+
+// TestMemoryStorage_URLIndex - обратный индекс живет вместе с сохранениями и удалениями
+func TestMemoryStorage_URLIndex(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryStorage()
+
+	assert.NoError(t, ms.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	assert.ErrorIs(t, ms.SaveUserURL(ctx, "u2", "id2", "http://ya.ru"), ErrURLConflict)
+
+	id, err := ms.FindIDByURL(ctx, "", "http://ya.ru")
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", id)
+
+	// батч занятый short_id не перезаписывает, а возвращает в конфликте
+	var conflict *BatchConflictError
+	require.ErrorAs(t, ms.SaveBatchUserURLs(ctx, "u1", map[string]string{"id1": "http://github.com"}), &conflict)
+	assert.Equal(t, []int{0}, conflict.Indexes)
+	_, err = ms.FindIDByURL(ctx, "", "http://github.com")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+	id, err = ms.FindIDByURL(ctx, "", "http://ya.ru")
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", id)
+
+	// удаленная ссылка url не освобождает, как и unique в бд
+	assert.NoError(t, ms.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+	assert.ErrorIs(t, ms.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"), ErrURLConflict)
+}
+
+// TestMemoryStorage_Resave - повторное сохранение не дублирует ссылку в списке владельца,
+// перезапись импортом переносит ее к новому владельцу
+func TestMemoryStorage_Resave(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+			assert.ErrorIs(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"), ErrShortIDConflict)
+			assert.NoError(t, store.SaveBatchLinks(ctx, "u1", []Link{{ShortURL: "id1", OriginalURL: "http://ya.ru"}}))
+			urls, err := store.GetUserURLs(ctx, "u1")
+			assert.NoError(t, err)
+			assert.Equal(t, []UserURL{{ShortURL: "id1", OriginalURL: "http://ya.ru"}}, urls)
+
+			imp := store.(Importer)
+			assert.NoError(t, imp.Import(ctx, []Record{{ShortURL: "id1", OriginalURL: "http://ya.ru", UserID: "u1"}}))
+			urls, err = store.GetUserURLs(ctx, "u1")
+			assert.NoError(t, err)
+			assert.Len(t, urls, 1)
+
+			assert.NoError(t, imp.Import(ctx, []Record{{ShortURL: "id1", OriginalURL: "http://ya.ru", UserID: "u2"}}))
+			urls, err = store.GetUserURLs(ctx, "u1")
+			assert.NoError(t, err)
+			assert.Empty(t, urls)
+			urls, err = store.GetUserURLs(ctx, "u2")
+			assert.NoError(t, err)
+			assert.Len(t, urls, 1)
+		})
+	}
+}
+
+// TestMemoryStorage_DedupScope - конфликты по url в разных областях дедупликации
+func TestMemoryStorage_DedupScope(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("per-user", func(t *testing.T) {
+		ms := NewMemoryStorage(WithDedupScope(DedupPerUser))
+
+		assert.NoError(t, ms.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+		assert.NoError(t, ms.SaveUserURL(ctx, "u2", "id2", "http://ya.ru"))
+		assert.ErrorIs(t, ms.SaveUserURL(ctx, "u1", "id3", "http://ya.ru"), ErrURLConflict)
+
+		id, err := ms.FindIDByURL(ctx, "u2", "http://ya.ru")
+		assert.NoError(t, err)
+		assert.Equal(t, "id2", id)
+
+		// удаление одного пользователя не трогает ссылку другого
+		assert.NoError(t, ms.MarkUserURLsDeleted(ctx, "u1", []string{"id1", "id2"}))
+		url, err := ms.Load(ctx, "id2")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://ya.ru", url)
+
+		urls, err := ms.GetUserURLs(ctx, "u2")
+		assert.NoError(t, err)
+		assert.Equal(t, []UserURL{{ShortURL: "id2", OriginalURL: "http://ya.ru"}}, urls)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		ms := NewMemoryStorage(WithDedupScope(DedupNone))
+
+		assert.NoError(t, ms.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+		assert.NoError(t, ms.SaveUserURL(ctx, "u1", "id2", "http://ya.ru"))
+	})
+
+	t.Run("short id of another user", func(t *testing.T) {
+		ms := NewMemoryStorage(WithDedupScope(DedupPerUser))
+
+		assert.NoError(t, ms.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+		assert.ErrorIs(t, ms.SaveUserURL(ctx, "u2", "id1", "http://ya.ru"), ErrShortIDConflict)
+	})
+}
+
+// memBackends обе in-memory реализации, поведение у них должно совпадать
+func memBackends() map[string]Storager {
+	return map[string]Storager{
+		"single":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+	}
+}
+
+// backdate сдвигаем дату удаления ссылки в прошлое
+func backdate(t *testing.T, store Storager, shortID string, at time.Time) {
+	t.Helper()
+	switch s := store.(type) {
+	case *MemoryStorage:
+		s.store[shortID].deletedAt = at
+	case *ShardedMemoryStorage:
+		es := s.entryShard(shortID)
+		es.Lock()
+		es.m[shortID].deletedAt = at
+		es.Unlock()
+	default:
+		t.Fatalf("backdate: unexpected storage %T", store)
+	}
+}
+
+// TestMemoryStorage_Restore - восстанавливаем только своё и только в пределах окна,
+// очистка забирает только удаленные раньше before
+func TestMemoryStorage_Restore(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "id2", "http://github.com"))
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "old", "http://old.ru"))
+			assert.NoError(t, store.SaveUserURL(ctx, "u2", "other", "http://other.ru"))
+			assert.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"id1", "old"}))
+			assert.NoError(t, store.MarkUserURLsDeleted(ctx, "u2", []string{"other"}))
+			backdate(t, store, "old", time.Now().Add(-48*time.Hour))
+
+			res, err := store.RestoreUserURLs(ctx, "u1", []string{"id1", "id2", "old", "other", "nope"}, time.Now().Add(-24*time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]RestoreStatus{
+				"id1":   RestoreOK,
+				"id2":   RestoreNotDeleted,
+				"old":   RestoreExpired,
+				"other": RestoreNotFound,
+				"nope":  RestoreNotFound,
+			}, res)
+
+			url, err := store.Load(ctx, "id1")
+			assert.NoError(t, err)
+			assert.Equal(t, "http://ya.ru", url)
+			_, err = store.Load(ctx, "other")
+			assert.ErrorIs(t, err, ErrURLDeleted)
+
+			purged, err := store.PurgeDeleted(ctx, time.Now().Add(-24*time.Hour))
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, purged)
+			_, err = store.Load(ctx, "old")
+			assert.ErrorIs(t, err, ErrURLNotFound)
+			_, err = store.Load(ctx, "other")
+			assert.ErrorIs(t, err, ErrURLDeleted)
+			assert.NoError(t, store.SaveUserURL(ctx, "u2", "new", "http://old.ru"))
+
+			urls, err := store.GetUserURLs(ctx, "u1")
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []UserURL{
+				{ShortURL: "id1", OriginalURL: "http://ya.ru"},
+				{ShortURL: "id2", OriginalURL: "http://github.com"},
+			}, urls)
+		})
+	}
+}
+
+// TestMemoryStorage_Expiry - просроченная ссылка не отдается, но видна владельцу со сроком
+func TestMemoryStorage_Expiry(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+
+			assert.NoError(t, store.SaveLink(ctx, "u1", Link{ShortURL: "old", OriginalURL: "http://old.ru", ExpiresAt: &past}))
+			assert.NoError(t, store.SaveBatchLinks(ctx, "u1", []Link{{ShortURL: "new", OriginalURL: "http://new.ru", ExpiresAt: &future}}))
+
+			_, err := store.Load(ctx, "old")
+			assert.ErrorIs(t, err, ErrURLExpired)
+			_, err = store.ConsumeLink(ctx, "old")
+			assert.ErrorIs(t, err, ErrURLExpired)
+			link, err := store.LoadLink(ctx, "new")
+			assert.NoError(t, err)
+			assert.Equal(t, future, *link.ExpiresAt)
+
+			urls, err := store.GetUserURLs(ctx, "u1")
+			assert.NoError(t, err)
+			assert.Len(t, urls, 2)
+			for _, u := range urls {
+				assert.NotNil(t, u.ExpiresAt)
+			}
+		})
+	}
+}
+
+// TestMemoryStorage_ConsumeLink - параллельные переходы не тратят больше лимита,
+// для обеих in-memory реализаций
+func TestMemoryStorage_ConsumeLink(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limit := int64(10)
+			assert.NoError(t, store.SaveLink(ctx, "u1", Link{ShortURL: "dl", OriginalURL: "http://dl.ru", ClicksLeft: &limit}))
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "free", "http://free.ru"))
+
+			var ok, gone atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := store.ConsumeLink(ctx, "dl")
+					switch {
+					case err == nil:
+						ok.Add(1)
+					case errors.Is(err, ErrURLExhausted):
+						gone.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.EqualValues(t, 10, ok.Load())
+			assert.EqualValues(t, 40, gone.Load())
+
+			// снаружи счетчик не меняется, исчерпанная ссылка и в Load недоступна
+			assert.EqualValues(t, 10, limit)
+			_, err := store.Load(ctx, "dl")
+			assert.ErrorIs(t, err, ErrURLExhausted)
+
+			link, err := store.ConsumeLink(ctx, "free")
+			assert.NoError(t, err)
+			assert.Nil(t, link.ClicksLeft)
+		})
+	}
+}
+
+// TestMemoryStorage_Clicks - статистика по отрезкам и суточные уникальные только владельцу,
+// очистка ссылки забирает переходы
+func TestMemoryStorage_Clicks(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+			assert.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+
+			assert.NoError(t, store.SaveClicks(ctx, []Click{
+				{ShortURL: "id1", At: day.Add(time.Hour), IPHash: "aa"},
+				{ShortURL: "id1", At: day.Add(23 * time.Hour), IPHash: "aa"},
+				{ShortURL: "id1", At: day.Add(25 * time.Hour), IPHash: "bb"},
+				{ShortURL: "id1", At: day.Add(-time.Hour), IPHash: "cc"}, // до отрезка, только в Total
+				{ShortURL: "id1", At: day.Add(time.Hour), IPHash: "dd", Bot: "user_agent"},
+			}))
+
+			q := ClickQuery{From: day, To: day.Add(48 * time.Hour), Bucket: BucketDay}
+			stats, err := store.ClickStats(ctx, "u1", "id1", q)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 4, stats.Total)
+			// бот только в своем счетчике: ни в отрезках, ни в уникальных его нет
+			assert.EqualValues(t, 1, stats.BotTotal)
+			assert.Equal(t, []ClickBucket{
+				{Start: day, Clicks: 2},
+				{Start: day.Add(24 * time.Hour), Clicks: 1},
+			}, stats.Buckets)
+			require.Len(t, stats.Visitors, 2)
+			assert.Equal(t, day, stats.Visitors[0].Day)
+			assert.EqualValues(t, 1, stats.Visitors[0].Visitors.Count())
+			assert.Equal(t, day.Add(24*time.Hour), stats.Visitors[1].Day)
+
+			_, err = store.ClickStats(ctx, "u2", "id1", q)
+			assert.ErrorIs(t, err, ErrURLNotFound)
+
+			assert.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+			stats, err = store.ClickStats(ctx, "u1", "id1", q)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 4, stats.Total)
+
+			_, err = store.PurgeDeleted(ctx, time.Now().Add(time.Second))
+			assert.NoError(t, err)
+			assert.NoError(t, store.SaveUserURL(ctx, "u2", "id1", "http://github.com"))
+			stats, err = store.ClickStats(ctx, "u2", "id1", q)
+			assert.NoError(t, err)
+			assert.Zero(t, stats.Total)
+			assert.Empty(t, stats.Visitors)
+		})
+	}
+}
+
+// BenchmarkMemoryStorage_Conflict - проверка конфликта не зависит от размера хранилища
+func BenchmarkMemoryStorage_Conflict(b *testing.B) {
+	for _, size := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			ms := NewMemoryStorage()
+			batch := make(map[string]string, size)
+			for i := 0; i < size; i++ {
+				batch[fmt.Sprintf("id%d", i)] = fmt.Sprintf("http://example.com/%d", i)
+			}
+			if err := ms.SaveBatchUserURLs(ctx, "u", batch); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				url := fmt.Sprintf("http://example.com/%d", i%size)
+				if err := ms.SaveUserURL(ctx, "u", "new", url); err != ErrURLConflict {
+					b.Fatalf("expected conflict, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestMemoryStorage_RollupClicks - старые переходы сворачиваются в сутки, итоги по дням
+// и уникальные не меняются, часы за свернутые сутки пропадают
+func TestMemoryStorage_RollupClicks(t *testing.T) {
+	for name, store := range memBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+			require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+			require.NoError(t, store.SaveClicks(ctx, []Click{
+				{ShortURL: "id1", At: day.Add(time.Hour), IPHash: "aa", Referrer: "https://www.google.com/search?q=1", UserAgent: "Mozilla/5.0 Chrome/130.0 Safari/537.36", Country: "RU", Region: "MOW"},
+				{ShortURL: "id1", At: day.Add(2 * time.Hour), IPHash: "bb", Referrer: "https://t.me/chan"},
+				{ShortURL: "id1", At: day.Add(3 * time.Hour), IPHash: "cc", Bot: "user_agent"},
+				{ShortURL: "id1", At: day.Add(25 * time.Hour), IPHash: "aa", Country: "RU"},
+			}))
+
+			q := ClickQuery{From: day, To: day.Add(48 * time.Hour), Bucket: BucketDay}
+			before, err := store.ClickStats(ctx, "u1", "id1", q)
+			require.NoError(t, err)
+
+			rolled, err := store.RollupClicks(ctx, day.Add(24*time.Hour), 0)
+			require.NoError(t, err)
+			assert.EqualValues(t, 3, rolled)
+			rolled, err = store.RollupClicks(ctx, day.Add(24*time.Hour), 0)
+			require.NoError(t, err)
+			assert.Zero(t, rolled, "второй проход сворачивать нечего")
+
+			after, err := store.ClickStats(ctx, "u1", "id1", q)
+			require.NoError(t, err)
+			assert.Equal(t, before.Total, after.Total)
+			assert.Equal(t, before.BotTotal, after.BotTotal)
+			assert.Equal(t, before.Buckets, after.Buckets)
+			assert.Equal(t, map[string]int64{"google.com": 1, "t.me": 1, DirectReferrer: 1}, after.Referrers)
+			assert.Equal(t, map[string]int64{"Chrome": 1, "other": 2}, after.UserAgents)
+			assert.Equal(t, map[string]int64{"RU": 2, UnknownCountry: 1}, after.Countries)
+			require.Len(t, after.Visitors, 2)
+			assert.EqualValues(t, 2, after.Visitors[0].Visitors.Count())
+
+			hours, err := store.ClickStats(ctx, "u1", "id1", ClickQuery{From: day, To: day.Add(48 * time.Hour), Bucket: BucketHour})
+			require.NoError(t, err)
+			assert.Equal(t, []ClickBucket{{Start: day.Add(25 * time.Hour), Clicks: 1}}, hours.Buckets)
+
+			// очистка ссылки забирает и агрегаты
+			require.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+			_, err = store.PurgeDeleted(ctx, time.Now().Add(time.Second))
+			require.NoError(t, err)
+			require.NoError(t, store.SaveUserURL(ctx, "u2", "id1", "http://github.com"))
+			stats, err := store.ClickStats(ctx, "u2", "id1", q)
+			require.NoError(t, err)
+			assert.Zero(t, stats.Total)
+			assert.Zero(t, stats.BotTotal)
+		})
+	}
+}
+
+func TestClickRollup_Families(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/130.0 Safari/537.36 Edg/130.0": "Edge",
+		"Mozilla/5.0 (X11; Linux x86_64) Chrome/130.0 Safari/537.36":                            "Chrome",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Version/17.0 Mobile/15E148 Safari/604.1":      "Safari",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0":                "Firefox",
+		"": "other",
+	} {
+		assert.Equal(t, want, userAgentFamily(ua), ua)
+	}
+	for ref, want := range map[string]string{
+		"":                                      DirectReferrer,
+		"https://WWW.Example.com/x":             "example.com",
+		"android-app://org.telegram.messenger/": "org.telegram.messenger",
+		"not a url":                             "other",
+	} {
+		assert.Equal(t, want, referrerHost(ref), ref)
+	}
+
+	counts := map[string]int64{"a": 3, "b": 3, "c": 1, "d": 2}
+	assert.Equal(t, map[string]int64{"a": 3, "b": 3}, topCounts(counts, 2))
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationsFS миграции вшиваются в бинарь, файлы вида 0001_name.up.sql / 0001_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID ключ advisory lock, чтобы два инстанса не катили миграции одновременно
+const migrationLockID = 720_117_001
+
+var (
+	// ErrSchemaTooNew - база мигрирована более новым бинарем, работать с ней нельзя
+	ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+	// ErrSchemaOutdated - в базе не хватает миграций, а автоприменение выключено
+	ErrSchemaOutdated = errors.New("database schema is outdated")
+	// ErrSchemaNotInitialised - миграции в базу еще не катили, а автоприменение выключено
+	ErrSchemaNotInitialised = errors.New("database schema is not initialised")
+)
+
+// migration одна версия схемы
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator накатывает и откатывает миграции, состояние хранится в schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+// NewMigrator собираем мигратор со вшитым набором миграций
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest последняя версия, которую знает бинарь
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version текущая версия схемы в базе, 0 - миграций еще не было; базу не трогает
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	exists, err := m.tableExists(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return currentVersion(ctx, m.db)
+}
+
+// Check проверяем что база совпадает с бинарем, ничего не меняя
+func (m *Migrator) Check(ctx context.Context) error {
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: no schema_migrations table, run migrate up or start with -auto-migrate", ErrSchemaNotInitialised)
+	}
+	version, err := currentVersion(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: db version %d, binary knows up to %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	if version < m.Latest() {
+		return fmt.Errorf("%w: db version %d, binary expects %d", ErrSchemaOutdated, version, m.Latest())
+	}
+	return nil
+}
+
+// Up накатываем все недостающие миграции, вернем сколько применили
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var applied int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: db version %d, binary knows up to %d", ErrSchemaTooNew, version, m.Latest())
+		}
+
+		for _, mig := range m.migrations {
+			if mig.version <= version {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig.up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.version, mig.name)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s up: %w", mig.version, mig.name, err)
+			}
+			log.Printf("DB Info: applied migration %04d_%s", mig.version, mig.name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатываем steps последних миграций
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var reverted int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: db version %d, binary knows up to %d", ErrSchemaTooNew, version, m.Latest())
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := m.migrations[i]
+			if mig.version > version {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig.down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", mig.version, mig.name, err)
+			}
+			log.Printf("DB Info: reverted migration %04d_%s", mig.version, mig.name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// apply выполняем тело миграции и отметку о ней в одной транзакции
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, body string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// withLock держим advisory lock на отдельном соединении, пока работаем с миграциями
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	return fn(conn)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// tableExists есть ли schema_migrations, ищем так же по search_path, как и CREATE TABLE
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check schema_migrations: %w", err)
+	}
+	return exists, nil
+}
+
+// queryer общий кусок *sql.DB и *sql.Conn
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func currentVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// loadMigrations читаем пары up/down из каталога, версии должны идти подряд с 1
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		version, name, direction, err := parseMigrationName(f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: name}
+			byVersion[version] = mig
+		}
+		if mig.name != name {
+			return nil, fmt.Errorf("migration %04d has different names: %q and %q", version, mig.name, name)
+		}
+		if direction == "up" {
+			mig.up = string(body)
+		} else {
+			mig.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.up == "" || mig.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down steps", mig.version, mig.name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	for i, mig := range migrations {
+		if mig.version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential, got %04d at position %d", mig.version, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// parseMigrationName 0001_create_urls.up.sql => 1, create_urls, up
+func parseMigrationName(file string) (int, string, string, error) {
+	base := strings.TrimSuffix(file, ".sql")
+	if base == file {
+		return 0, "", "", fmt.Errorf("bad migration file name %q", file)
+	}
+
+	var direction string
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		direction = "up"
+	case strings.HasSuffix(base, ".down"):
+		direction = "down"
+	default:
+		return 0, "", "", fmt.Errorf("bad migration file name %q: want .up.sql or .down.sql", file)
+	}
+	base = strings.TrimSuffix(base, "."+direction)
+
+	rawVersion, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", "", fmt.Errorf("bad migration file name %q: want NNNN_name", file)
+	}
+	version, err := strconv.Atoi(rawVersion)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("bad migration version in %q", file)
+	}
+	return version, name, direction, nil
+}
@@ -0,0 +1,8 @@
+-- базовая таблица, IF NOT EXISTS чтобы подхватить базы, созданные до миграций
+CREATE TABLE IF NOT EXISTS urls (
+	id SERIAL PRIMARY KEY,
+	short_id VARCHAR(8) UNIQUE NOT NULL,
+	original_url TEXT UNIQUE NOT NULL,
+	user_id TEXT NOT NULL,
+	is_deleted BOOLEAN NOT NULL DEFAULT false
+);
@@ -0,0 +1,4 @@
+-- упадет, если в базе уже есть одинаковые url у разных пользователей - это ожидаемо
+DROP INDEX IF EXISTS urls_user_id_original_url_idx;
+DROP INDEX IF EXISTS urls_original_url_idx;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
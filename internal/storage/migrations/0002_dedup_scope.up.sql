@@ -0,0 +1,5 @@
+-- уникальность original_url теперь зависит от настройки dedup и проверяется приложением
+-- под advisory lock, поэтому глобальный unique убираем, а поиск оставляем на индексах
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+CREATE INDEX IF NOT EXISTS urls_original_url_idx ON urls (original_url);
+CREATE INDEX IF NOT EXISTS urls_user_id_original_url_idx ON urls (user_id, original_url);
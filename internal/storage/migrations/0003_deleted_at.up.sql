@@ -0,0 +1,5 @@
+-- когда ссылку пометили удаленной, от этого момента считается срок хранения до очистки
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+-- уже удаленным ссылкам срок хранения отсчитываем с момента миграции
+UPDATE urls SET deleted_at = now() WHERE is_deleted = true AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls (deleted_at) WHERE is_deleted = true;
@@ -0,0 +1,2 @@
+-- срок жизни ссылки, NULL - бессрочная; проверяется при редиректе
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
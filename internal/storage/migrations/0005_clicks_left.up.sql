@@ -0,0 +1,2 @@
+-- сколько переходов осталось, NULL - без ограничения; списывается при редиректе
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks_left BIGINT;
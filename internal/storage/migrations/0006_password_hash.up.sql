@@ -0,0 +1,2 @@
+-- bcrypt хэш пароля ссылки, NULL - ссылка открытая
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT;
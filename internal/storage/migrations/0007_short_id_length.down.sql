@@ -0,0 +1,3 @@
+DROP SEQUENCE IF EXISTS short_id_seq;
+-- упадет, если уже есть id длиннее 8 знаков - их надо сначала убрать руками
+ALTER TABLE urls ALTER COLUMN short_id TYPE VARCHAR(8);
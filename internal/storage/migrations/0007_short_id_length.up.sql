@@ -0,0 +1,4 @@
+-- алиасы и id других стратегий длиннее 8 знаков
+ALTER TABLE urls ALTER COLUMN short_id TYPE VARCHAR(128);
+-- номера для стратегии counter
+CREATE SEQUENCE IF NOT EXISTS short_id_seq;
@@ -0,0 +1,11 @@
+-- переходы по ссылкам для статистики владельцу, без внешнего ключа: пачка переходов
+-- не должна падать из-за ссылки, которую успели очистить, их чистит PurgeDeleted
+CREATE TABLE IF NOT EXISTS clicks (
+	id BIGSERIAL PRIMARY KEY,
+	short_id VARCHAR(128) NOT NULL,
+	clicked_at TIMESTAMPTZ NOT NULL,
+	referrer TEXT,
+	user_agent TEXT,
+	ip_hash TEXT
+);
+CREATE INDEX IF NOT EXISTS clicks_short_id_clicked_at_idx ON clicks (short_id, clicked_at);
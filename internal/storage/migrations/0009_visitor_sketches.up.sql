@@ -0,0 +1,8 @@
+-- суточные HyperLogLog скетчи уникальных посетителей ссылки, day - полночь UTC;
+-- недели и месяцы складываются из суток при чтении
+CREATE TABLE IF NOT EXISTS visitor_sketches (
+	short_id VARCHAR(128) NOT NULL,
+	day TIMESTAMPTZ NOT NULL,
+	sketch BYTEA NOT NULL,
+	PRIMARY KEY (short_id, day)
+);
@@ -0,0 +1,2 @@
+-- почему переход посчитан ботом, NULL - человек; в статистику людей боты не идут
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS bot TEXT;
@@ -0,0 +1,12 @@
+-- суточные агрегаты переходов, в которые сворачиваются сырые clicks старше срока хранения;
+-- visitors - HyperLogLog скетч, referrers/user_agents - топ хостов и семейств UA
+CREATE TABLE IF NOT EXISTS click_rollups (
+	short_id VARCHAR(128) NOT NULL,
+	day TIMESTAMPTZ NOT NULL,
+	clicks BIGINT NOT NULL DEFAULT 0,
+	bot_clicks BIGINT NOT NULL DEFAULT 0,
+	visitors BYTEA,
+	referrers JSONB NOT NULL DEFAULT '{}',
+	user_agents JSONB NOT NULL DEFAULT '{}',
+	PRIMARY KEY (short_id, day)
+);
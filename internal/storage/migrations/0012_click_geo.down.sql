@@ -0,0 +1,3 @@
+ALTER TABLE click_rollups DROP COLUMN IF EXISTS countries;
+ALTER TABLE clicks DROP COLUMN IF EXISTS region;
+ALTER TABLE clicks DROP COLUMN IF EXISTS country;
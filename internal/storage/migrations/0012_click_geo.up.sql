@@ -0,0 +1,5 @@
+-- страна и регион по IP из GeoIP базы (ISO 3166), NULL - не определили
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS region TEXT;
+-- переходы людей по странам в суточных агрегатах
+ALTER TABLE click_rollups ADD COLUMN IF NOT EXISTS countries JSONB NOT NULL DEFAULT '{}';
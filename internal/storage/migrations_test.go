@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadMigrations_Embedded - вшитый набор миграций валидный и идет по порядку
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, mig := range migrations {
+		assert.Equal(t, i+1, mig.version)
+		assert.NotEmpty(t, mig.up)
+		assert.NotEmpty(t, mig.down)
+	}
+	assert.Equal(t, "create_urls", migrations[0].name)
+}
+
+// TestLoadMigrations_Invalid - кривые наборы миграций не принимаем
+func TestLoadMigrations_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"no down", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1")},
+		}},
+		{"gap", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1")}, "m/0001_a.down.sql": {Data: []byte("SELECT 1")},
+			"m/0003_c.up.sql": {Data: []byte("SELECT 1")}, "m/0003_c.down.sql": {Data: []byte("SELECT 1")},
+		}},
+		{"bad name", fstest.MapFS{
+			"m/first.up.sql": {Data: []byte("SELECT 1")},
+		}},
+		{"bad direction", fstest.MapFS{
+			"m/0001_a.sideways.sql": {Data: []byte("SELECT 1")},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadMigrations(tc.files, "m")
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestParseMigrationName - разбор имени файла миграции
+func TestParseMigrationName(t *testing.T) {
+	version, name, direction, err := parseMigrationName("0012_add_expiry_to_urls.down.sql")
+	require.NoError(t, err)
+	assert.Equal(t, 12, version)
+	assert.Equal(t, "add_expiry_to_urls", name)
+	assert.Equal(t, "down", direction)
+}
+
+// TestMigrator_CheckReadOnly - без schema_migrations Check сообщает о пустой схеме
+// и ничего в базе не создает
+func TestMigrator_CheckReadOnly(t *testing.T) {
+	conn := &recordingConn{}
+	db := sql.OpenDB(recordingConnector{conn})
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, migrator.Check(context.Background()), ErrSchemaNotInitialised)
+	version, err := migrator.Version(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, version)
+
+	for _, query := range conn.queries {
+		assert.NotContains(t, query, "CREATE")
+	}
+	assert.NotEmpty(t, conn.queries)
+}
+
+// recordingConn запоминает запросы, на любой SELECT отвечает одной строкой false
+type recordingConn struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.mu.Lock()
+	c.queries = append(c.queries, query)
+	c.mu.Unlock()
+	return recordingStmt{}, nil
+}
+
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type recordingStmt struct{}
+
+func (recordingStmt) Close() error  { return nil }
+func (recordingStmt) NumInput() int { return -1 }
+func (recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &falseRows{}, nil
+}
+
+type falseRows struct{ done bool }
+
+func (r *falseRows) Columns() []string { return []string{"exists"} }
+func (r *falseRows) Close() error      { return nil }
+func (r *falseRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = false
+	return nil
+}
+
+type recordingConnector struct{ conn *recordingConn }
+
+func (c recordingConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c recordingConnector) Driver() driver.Driver                        { return nil }
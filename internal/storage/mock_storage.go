@@ -5,7 +5,9 @@
 package storage
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -33,6 +35,21 @@ func (m *MockStorager) EXPECT() *MockStoragerMockRecorder {
 	return m.recorder
 }
 
+// ClickStats mocks base method.
+func (m *MockStorager) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClickStats", ctx, userID, shortID, q)
+	ret0, _ := ret[0].(ClickStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClickStats indicates an expected call of ClickStats.
+func (mr *MockStoragerMockRecorder) ClickStats(ctx, userID, shortID, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClickStats", reflect.TypeOf((*MockStorager)(nil).ClickStats), ctx, userID, shortID, q)
+}
+
 // Close mocks base method.
 func (m *MockStorager) Close() error {
 	m.ctrl.T.Helper()
@@ -47,131 +64,263 @@ func (mr *MockStoragerMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorager)(nil).Close))
 }
 
+// ConsumeLink mocks base method.
+func (m *MockStorager) ConsumeLink(ctx context.Context, id string) (Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeLink", ctx, id)
+	ret0, _ := ret[0].(Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeLink indicates an expected call of ConsumeLink.
+func (mr *MockStoragerMockRecorder) ConsumeLink(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeLink", reflect.TypeOf((*MockStorager)(nil).ConsumeLink), ctx, id)
+}
+
 // FindIDByURL mocks base method.
-func (m *MockStorager) FindIDByURL(url string) (string, error) {
+func (m *MockStorager) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindIDByURL", url)
+	ret := m.ctrl.Call(m, "FindIDByURL", ctx, userID, url)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindIDByURL indicates an expected call of FindIDByURL.
-func (mr *MockStoragerMockRecorder) FindIDByURL(url interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) FindIDByURL(ctx, userID, url interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindIDByURL", reflect.TypeOf((*MockStorager)(nil).FindIDByURL), url)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindIDByURL", reflect.TypeOf((*MockStorager)(nil).FindIDByURL), ctx, userID, url)
 }
 
 // GetUserURLs mocks base method.
-func (m *MockStorager) GetUserURLs(userID string) ([]UserURL, error) {
+func (m *MockStorager) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserURLs", userID)
+	ret := m.ctrl.Call(m, "GetUserURLs", ctx, userID)
 	ret0, _ := ret[0].([]UserURL)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserURLs indicates an expected call of GetUserURLs.
-func (mr *MockStoragerMockRecorder) GetUserURLs(userID interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) GetUserURLs(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockStorager)(nil).GetUserURLs), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockStorager)(nil).GetUserURLs), ctx, userID)
 }
 
 // Load mocks base method.
-func (m *MockStorager) Load(id string) (string, error) {
+func (m *MockStorager) Load(ctx context.Context, id string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Load", id)
+	ret := m.ctrl.Call(m, "Load", ctx, id)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Load indicates an expected call of Load.
-func (mr *MockStoragerMockRecorder) Load(id interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) Load(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockStorager)(nil).Load), ctx, id)
+}
+
+// LoadLink mocks base method.
+func (m *MockStorager) LoadLink(ctx context.Context, id string) (Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadLink", ctx, id)
+	ret0, _ := ret[0].(Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadLink indicates an expected call of LoadLink.
+func (mr *MockStoragerMockRecorder) LoadLink(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockStorager)(nil).Load), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadLink", reflect.TypeOf((*MockStorager)(nil).LoadLink), ctx, id)
 }
 
 // MarkUserURLsDeleted mocks base method.
-func (m *MockStorager) MarkUserURLsDeleted(userID string, shortIDs []string) error {
+func (m *MockStorager) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "MarkUserURLsDeleted", userID, shortIDs)
+	ret := m.ctrl.Call(m, "MarkUserURLsDeleted", ctx, userID, shortIDs)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // MarkUserURLsDeleted indicates an expected call of MarkUserURLsDeleted.
-func (mr *MockStoragerMockRecorder) MarkUserURLsDeleted(userID, shortIDs interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) MarkUserURLsDeleted(ctx, userID, shortIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUserURLsDeleted", reflect.TypeOf((*MockStorager)(nil).MarkUserURLsDeleted), ctx, userID, shortIDs)
+}
+
+// NextSequence mocks base method.
+func (m *MockStorager) NextSequence(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextSequence", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextSequence indicates an expected call of NextSequence.
+func (mr *MockStoragerMockRecorder) NextSequence(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUserURLsDeleted", reflect.TypeOf((*MockStorager)(nil).MarkUserURLsDeleted), userID, shortIDs)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextSequence", reflect.TypeOf((*MockStorager)(nil).NextSequence), ctx)
 }
 
 // Ping mocks base method.
-func (m *MockStorager) Ping() error {
+func (m *MockStorager) Ping(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Ping")
+	ret := m.ctrl.Call(m, "Ping", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Ping indicates an expected call of Ping.
-func (mr *MockStoragerMockRecorder) Ping() *gomock.Call {
+func (mr *MockStoragerMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorager)(nil).Ping), ctx)
+}
+
+// PurgeDeleted mocks base method.
+func (m *MockStorager) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeleted", ctx, before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeleted indicates an expected call of PurgeDeleted.
+func (mr *MockStoragerMockRecorder) PurgeDeleted(ctx, before interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorager)(nil).Ping))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeleted", reflect.TypeOf((*MockStorager)(nil).PurgeDeleted), ctx, before)
+}
+
+// RestoreUserURLs mocks base method.
+func (m *MockStorager) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreUserURLs", ctx, userID, shortIDs, since)
+	ret0, _ := ret[0].(map[string]RestoreStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreUserURLs indicates an expected call of RestoreUserURLs.
+func (mr *MockStoragerMockRecorder) RestoreUserURLs(ctx, userID, shortIDs, since interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreUserURLs", reflect.TypeOf((*MockStorager)(nil).RestoreUserURLs), ctx, userID, shortIDs, since)
+}
+
+// RollupClicks mocks base method.
+func (m *MockStorager) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollupClicks", ctx, before, limit)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RollupClicks indicates an expected call of RollupClicks.
+func (mr *MockStoragerMockRecorder) RollupClicks(ctx, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollupClicks", reflect.TypeOf((*MockStorager)(nil).RollupClicks), ctx, before, limit)
 }
 
 // Save mocks base method.
-func (m *MockStorager) Save(id, url string) error {
+func (m *MockStorager) Save(ctx context.Context, id, url string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Save", id, url)
+	ret := m.ctrl.Call(m, "Save", ctx, id, url)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Save indicates an expected call of Save.
-func (mr *MockStoragerMockRecorder) Save(id, url interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) Save(ctx, id, url interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockStorager)(nil).Save), id, url)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockStorager)(nil).Save), ctx, id, url)
 }
 
 // SaveBatch mocks base method.
-func (m *MockStorager) SaveBatch(urls map[string]string) error {
+func (m *MockStorager) SaveBatch(ctx context.Context, urls map[string]string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveBatch", urls)
+	ret := m.ctrl.Call(m, "SaveBatch", ctx, urls)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveBatch indicates an expected call of SaveBatch.
-func (mr *MockStoragerMockRecorder) SaveBatch(urls interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) SaveBatch(ctx, urls interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockStorager)(nil).SaveBatch), ctx, urls)
+}
+
+// SaveBatchLinks mocks base method.
+func (m *MockStorager) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBatchLinks", ctx, userID, links)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveBatchLinks indicates an expected call of SaveBatchLinks.
+func (mr *MockStoragerMockRecorder) SaveBatchLinks(ctx, userID, links interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockStorager)(nil).SaveBatch), urls)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatchLinks", reflect.TypeOf((*MockStorager)(nil).SaveBatchLinks), ctx, userID, links)
 }
 
 // SaveBatchUserURLs mocks base method.
-func (m *MockStorager) SaveBatchUserURLs(userID string, batch map[string]string) error {
+func (m *MockStorager) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveBatchUserURLs", userID, batch)
+	ret := m.ctrl.Call(m, "SaveBatchUserURLs", ctx, userID, batch)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveBatchUserURLs indicates an expected call of SaveBatchUserURLs.
-func (mr *MockStoragerMockRecorder) SaveBatchUserURLs(userID, batch interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) SaveBatchUserURLs(ctx, userID, batch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatchUserURLs", reflect.TypeOf((*MockStorager)(nil).SaveBatchUserURLs), ctx, userID, batch)
+}
+
+// SaveClicks mocks base method.
+func (m *MockStorager) SaveClicks(ctx context.Context, clicks []Click) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveClicks", ctx, clicks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveClicks indicates an expected call of SaveClicks.
+func (mr *MockStoragerMockRecorder) SaveClicks(ctx, clicks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveClicks", reflect.TypeOf((*MockStorager)(nil).SaveClicks), ctx, clicks)
+}
+
+// SaveLink mocks base method.
+func (m *MockStorager) SaveLink(ctx context.Context, userID string, link Link) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveLink", ctx, userID, link)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveLink indicates an expected call of SaveLink.
+func (mr *MockStoragerMockRecorder) SaveLink(ctx, userID, link interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatchUserURLs", reflect.TypeOf((*MockStorager)(nil).SaveBatchUserURLs), userID, batch)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveLink", reflect.TypeOf((*MockStorager)(nil).SaveLink), ctx, userID, link)
 }
 
 // SaveUserURL mocks base method.
-func (m *MockStorager) SaveUserURL(userID, shortID, originalURL string) error {
+func (m *MockStorager) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveUserURL", userID, shortID, originalURL)
+	ret := m.ctrl.Call(m, "SaveUserURL", ctx, userID, shortID, originalURL)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveUserURL indicates an expected call of SaveUserURL.
-func (mr *MockStoragerMockRecorder) SaveUserURL(userID, shortID, originalURL interface{}) *gomock.Call {
+func (mr *MockStoragerMockRecorder) SaveUserURL(ctx, userID, shortID, originalURL interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUserURL", reflect.TypeOf((*MockStorager)(nil).SaveUserURL), userID, shortID, originalURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUserURL", reflect.TypeOf((*MockStorager)(nil).SaveUserURL), ctx, userID, shortID, originalURL)
 }
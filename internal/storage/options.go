@@ -0,0 +1,67 @@
+package storage
+
+import "fmt"
+
+// DedupScope в каких пределах original_url должен быть уникальным
+type DedupScope string
+
+const (
+	// DedupGlobal один url - одна короткая ссылка на весь сервис (старое поведение)
+	DedupGlobal DedupScope = "global"
+	// DedupPerUser у каждого пользователя своя короткая ссылка на url
+	DedupPerUser DedupScope = "per-user"
+	// DedupNone никакой дедупликации, каждый запрос - новая ссылка
+	DedupNone DedupScope = "none"
+)
+
+// ParseDedupScope разбираем значение из конфига, пустое - global
+func ParseDedupScope(s string) (DedupScope, error) {
+	switch scope := DedupScope(s); scope {
+	case "":
+		return DedupGlobal, nil
+	case DedupGlobal, DedupPerUser, DedupNone:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown dedup scope %q", s)
+	}
+}
+
+// key ключ обратного индекса url -> short_id с учетом области дедупликации;
+// для none ключ тот же что и для per-user, чтобы FindIDByURL находил ссылку пользователя
+func (s DedupScope) key(userID, url string) string {
+	if s == DedupGlobal {
+		return url
+	}
+	return userID + "\x00" + url
+}
+
+// options общие настройки бэкендов
+type options struct {
+	dedup    DedupScope
+	replicas []string
+}
+
+// Option настройка бэкенда при создании
+type Option func(*options)
+
+// WithDedupScope область дедупликации original_url, по умолчанию global
+func WithDedupScope(scope DedupScope) Option {
+	return func(o *options) {
+		o.dedup = scope
+	}
+}
+
+// WithReplicas DSN реплик для чтения, понимает только postgres
+func WithReplicas(dsns ...string) Option {
+	return func(o *options) {
+		o.replicas = append(o.replicas, dsns...)
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{dedup: DedupGlobal}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
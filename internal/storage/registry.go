@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mkukarin01/snort/internal/config"
+)
+
+// Factory создает бэкенд по адресу хранилища; location - строка целиком,
+// вместе со схемой (mem://, file:///path, postgres://...)
+type Factory func(location string, cfg *config.Config, opts ...Option) (Storager, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register регистрируем бэкенд под схемой, по аналогии с database/sql.Register
+// вызывается из init() реализаций, повторная регистрация - ошибка программиста
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := registry[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = factory
+}
+
+// Schemes зарегистрированные схемы, отсортированы - для сообщений об ошибках
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open создаем бэкенд по адресу вида scheme://...
+func Open(location string, cfg *config.Config) (Storager, error) {
+	scheme, _, ok := strings.Cut(location, "://")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("invalid storage location %q: want scheme://..., available schemes: %s",
+			location, strings.Join(Schemes(), ", "))
+	}
+	return openScheme(strings.ToLower(scheme), location, cfg)
+}
+
+func openScheme(scheme, location string, cfg *config.Config) (Storager, error) {
+	registryMu.RLock()
+	factory, ok := registry[scheme]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage scheme %q, available schemes: %s",
+			scheme, strings.Join(Schemes(), ", "))
+	}
+
+	opts, err := optionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return factory(location, cfg, opts...)
+}
+
+// optionsFromConfig общие настройки бэкендов из конфига
+func optionsFromConfig(cfg *config.Config) ([]Option, error) {
+	dedup, err := ParseDedupScope(cfg.DedupScope)
+	if err != nil {
+		return nil, err
+	}
+	opts := []Option{WithDedupScope(dedup)}
+	if replicas := splitList(cfg.DatabaseReplicas); len(replicas) > 0 {
+		opts = append(opts, WithReplicas(replicas...))
+	}
+	return opts, nil
+}
+
+// splitList список через запятую, пустые куски выкидываем
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
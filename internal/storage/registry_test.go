@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkukarin01/snort/internal/config"
+)
+
+// TestOpen_Schemes - бэкенд выбирается по схеме адреса
+func TestOpen_Schemes(t *testing.T) {
+	cfg := &config.Config{DedupScope: "per-user"}
+
+	store, err := Open("mem://", cfg)
+	require.NoError(t, err)
+	ms, ok := store.(*MemoryStorage)
+	require.True(t, ok)
+	assert.Equal(t, DedupPerUser, ms.dedup)
+
+	path := filepath.Join(t.TempDir(), "storage.json")
+	store, err = Open("file://"+path, cfg)
+	require.NoError(t, err)
+	fs, ok := store.(*FileStorage)
+	require.True(t, ok)
+	assert.Equal(t, path, fs.filePath)
+}
+
+// TestOpen_Errors - понятные ошибки для неизвестных схем и кривых адресов
+func TestOpen_Errors(t *testing.T) {
+	cfg := &config.Config{}
+
+	_, err := Open("redis://localhost:6379", cfg)
+	assert.ErrorContains(t, err, `unknown storage scheme "redis"`)
+	assert.ErrorContains(t, err, "file, mem, postgres, postgresql")
+
+	_, err = Open("./storage.json", cfg)
+	assert.ErrorContains(t, err, "want scheme://")
+
+	_, err = Open("file://", cfg)
+	assert.Error(t, err)
+
+	_, err = Open("mem://", &config.Config{DedupScope: "per-team"})
+	assert.Error(t, err)
+}
+
+// TestRegister_Duplicate - вторая регистрация той же схемы - паника, как в database/sql
+func TestRegister_Duplicate(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("mem", func(string, *config.Config, ...Option) (Storager, error) { return nil, nil })
+	})
+}
+
+// TestNewStorage_Legacy - без -storage работают старые -d / -f, а -storage важнее их
+func TestNewStorage_Legacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	store, err := newBackend(&config.Config{FileStoragePath: path})
+	require.NoError(t, err)
+	assert.IsType(t, &FileStorage{}, store)
+
+	store, err = newBackend(&config.Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryStorage{}, store)
+
+	store, err = newBackend(&config.Config{FileStoragePath: path, Storage: "mem://"})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryStorage{}, store)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// replicaCheckInterval как часто пингуем реплики, выпавшие возвращаем в ротацию
+const replicaCheckInterval = 5 * time.Second
+
+type primaryKey struct{}
+
+// WithPrimary помечаем контекст: читать только с primary.
+// Нужно для read-after-write, реплика может еще не догнать только что записанное
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// replica одна реплика и её здоровье
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet реплики для чтения: round-robin по живым, фоном проверяем здоровье
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+	interval time.Duration
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newReplicaSet открываем реплики; лежащая реплика старт не валит, просто не в ротации
+func newReplicaSet(dsns []string) (*replicaSet, error) {
+	rs := &replicaSet{
+		interval: replicaCheckInterval,
+		stopCh:   make(chan struct{}),
+	}
+	for _, dsn := range dsns {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		rs.replicas = append(rs.replicas, &replica{db: db})
+	}
+	rs.check()
+	return rs, nil
+}
+
+// pick следующая живая реплика, nil - читаем с primary
+func (rs *replicaSet) pick() *replica {
+	n := uint32(len(rs.replicas))
+	start := rs.next.Add(1)
+	for i := uint32(0); i < n; i++ {
+		r := rs.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// check пингуем все реплики и обновляем здоровье
+func (rs *replicaSet) check() {
+	for i, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.interval)
+		err := r.db.PingContext(ctx)
+		cancel()
+
+		was := r.healthy.Swap(err == nil)
+		if was && err != nil {
+			log.Printf("DB Error: replica #%d is down: %v", i, err)
+		}
+		if !was && err == nil {
+			log.Printf("DB: replica #%d is up", i)
+		}
+	}
+}
+
+// run фоновые проверки до close
+func (rs *replicaSet) run() {
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+		ticker := time.NewTicker(rs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rs.check()
+			case <-rs.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (rs *replicaSet) close() error {
+	close(rs.stopCh)
+	rs.wg.Wait()
+
+	var errs []error
+	for _, r := range rs.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// isConnError ошибка соединения, а не ответ сервера - реплику выводим из ротации до проверки
+func isConnError(err error) bool {
+	var pqErr *pq.Error
+	return !errors.As(err, &pqErr)
+}
+
+// read выполняем чтение на реплике, при проблемах - на primary.
+// ErrURLNotFound с реплики тоже перепроверяем на primary: скорее всего реплика отстала
+func (d *Database) read(ctx context.Context, query func(db *sql.DB) error) error {
+	if d.replicas == nil || usePrimary(ctx) {
+		return query(d.db)
+	}
+	r := d.replicas.pick()
+	if r == nil {
+		return query(d.db)
+	}
+
+	err := query(r.db)
+	switch {
+	case err == nil, errors.Is(err, ErrURLDeleted):
+		return err
+	case ctx.Err() != nil:
+		return err
+	case errors.Is(err, ErrURLNotFound):
+	default:
+		if isConnError(err) && r.healthy.Swap(false) {
+			log.Printf("DB Error: replica failed, fallback to primary: %v", err)
+		}
+	}
+	return query(d.db)
+}
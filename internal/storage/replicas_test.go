@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openLazy pq не ходит в сеть до первого запроса, хватает как метки "какая база"
+func openLazy(t *testing.T) *sql.DB {
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/snort?sslmode=disable&connect_timeout=1")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestReplicaSet_Unreachable - лежащая реплика не валит старт и не попадает в ротацию
+func TestReplicaSet_Unreachable(t *testing.T) {
+	rs, err := newReplicaSet([]string{"postgres://127.0.0.1:1/snort?sslmode=disable&connect_timeout=1"})
+	require.NoError(t, err)
+	defer rs.close()
+
+	assert.False(t, rs.replicas[0].healthy.Load())
+	assert.Nil(t, rs.pick())
+}
+
+// TestDatabase_ReadRouting - куда уходит чтение и когда откатываемся на primary
+func TestDatabase_ReadRouting(t *testing.T) {
+	ctx := context.Background()
+	primary := openLazy(t)
+	r1 := &replica{db: openLazy(t)}
+	r1.healthy.Store(true)
+	d := &Database{db: primary, replicas: &replicaSet{replicas: []*replica{r1}}}
+
+	run := func(ctx context.Context, replicaErr error) ([]*sql.DB, error) {
+		var calls []*sql.DB
+		err := d.read(ctx, func(db *sql.DB) error {
+			calls = append(calls, db)
+			if db == r1.db {
+				return replicaErr
+			}
+			return nil
+		})
+		return calls, err
+	}
+
+	calls, err := run(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{r1.db}, calls)
+
+	// удаленная ссылка - ответ окончательный
+	calls, err = run(ctx, ErrURLDeleted)
+	assert.ErrorIs(t, err, ErrURLDeleted)
+	assert.Equal(t, []*sql.DB{r1.db}, calls)
+
+	// не нашли на реплике - перепроверяем на primary, реплика остается в ротации
+	calls, err = run(ctx, ErrURLNotFound)
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{r1.db, primary}, calls)
+	assert.True(t, r1.healthy.Load())
+
+	// ошибка сервера - на primary, но реплику не выкидываем
+	calls, err = run(ctx, &pq.Error{Code: "40001"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{r1.db, primary}, calls)
+	assert.True(t, r1.healthy.Load())
+
+	// read-after-write - только primary
+	calls, err = run(WithPrimary(ctx), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{primary}, calls)
+
+	// ошибка соединения - реплика выпадает до следующей проверки
+	calls, err = run(ctx, errors.New("connection refused"))
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{r1.db, primary}, calls)
+	assert.False(t, r1.healthy.Load())
+
+	calls, err = run(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*sql.DB{primary}, calls)
+}
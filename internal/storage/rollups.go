@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mkukarin01/snort/internal/hll"
+)
+
+// rollupTop сколько рефереров и семейств UA держим в суточном агрегате
+const rollupTop = 10
+
+// DirectReferrer переходы без Referer
+const DirectReferrer = "(direct)"
+
+// ClickRollup переходы ссылки за сутки [Day, Day+1d) UTC, свернутые из сырых событий:
+// счетчики, скетч уникальных, топы рефереров (по хосту) и семейств UA и переходы по странам
+// (их немного, храним все), только люди
+type ClickRollup struct {
+	ShortURL   string           `json:"short_url"`
+	Day        time.Time        `json:"day"`
+	Clicks     int64            `json:"clicks"`
+	BotClicks  int64            `json:"bot_clicks,omitempty"`
+	Visitors   *hll.Sketch      `json:"visitors"`
+	Referrers  map[string]int64 `json:"referrers,omitempty"`
+	UserAgents map[string]int64 `json:"user_agents,omitempty"`
+	Countries  map[string]int64 `json:"countries,omitempty"`
+}
+
+func newClickRollup(shortID string, day time.Time) *ClickRollup {
+	return &ClickRollup{
+		ShortURL:   shortID,
+		Day:        day,
+		Visitors:   hll.New(),
+		Referrers:  make(map[string]int64),
+		UserAgents: make(map[string]int64),
+		Countries:  make(map[string]int64),
+	}
+}
+
+// add учитываем сырой переход; топы не режем - это делает trim после пачки
+func (r *ClickRollup) add(c Click) {
+	if c.Bot != "" {
+		r.BotClicks++
+		return
+	}
+	r.Clicks++
+	if key := visitorKey(c); key != nil {
+		r.Visitors.Add(key)
+	}
+	r.Referrers[referrerHost(c.Referrer)]++
+	r.UserAgents[userAgentFamily(c.UserAgent)]++
+	r.Countries[clickCountry(c)]++
+}
+
+// merge складываем агрегаты одних суток; топы после слияния приблизительные:
+// что не вошло в топ раньше, уже не посчитать
+func (r *ClickRollup) merge(other *ClickRollup) {
+	r.Clicks += other.Clicks
+	r.BotClicks += other.BotClicks
+	if other.Visitors != nil {
+		if r.Visitors == nil {
+			r.Visitors = hll.New()
+		}
+		// скетч другой точности не складывается - уникальные за эти сутки чуть занизим
+		_ = r.Visitors.Merge(other.Visitors)
+	}
+	addCounts(&r.Referrers, other.Referrers)
+	addCounts(&r.UserAgents, other.UserAgents)
+	addCounts(&r.Countries, other.Countries)
+}
+
+func (r *ClickRollup) trim() {
+	r.Referrers = topCounts(r.Referrers, rollupTop)
+	r.UserAgents = topCounts(r.UserAgents, rollupTop)
+}
+
+func addCounts(dst *map[string]int64, src map[string]int64) {
+	if *dst == nil {
+		*dst = make(map[string]int64, len(src))
+	}
+	for k, n := range src {
+		(*dst)[k] += n
+	}
+}
+
+// topCounts n самых частых, при равенстве - по имени, чтобы результат не зависел от обхода map
+func topCounts(m map[string]int64, n int) map[string]int64 {
+	if len(m) <= n {
+		return m
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := make(map[string]int64, n)
+	for _, k := range keys[:n] {
+		top[k] = m[k]
+	}
+	return top
+}
+
+// referrerHost реферер до хоста: полные адреса с метками и токенами в топе не нужны
+func referrerHost(ref string) string {
+	if ref == "" {
+		return DirectReferrer
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Hostname() == "" {
+		return "other"
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// uaFamilies порядок важен: Edge и Opera пишут в UA и Chrome, Chrome - и Safari
+var uaFamilies = []struct {
+	marker, family string
+}{
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"yabrowser/", "Yandex"},
+	{"samsungbrowser/", "Samsung"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"chrome/", "Chrome"},
+	{"crios/", "Chrome"},
+	{"safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+// userAgentFamily семейство браузера по UA, остальное - other
+func userAgentFamily(ua string) string {
+	ua = strings.ToLower(ua)
+	for _, f := range uaFamilies {
+		if strings.Contains(ua, f.marker) {
+			return f.family
+		}
+	}
+	return "other"
+}
+
+// rollupKey агрегат ссылки за сутки, day - unix секунды полуночи
+type rollupKey struct {
+	shortID string
+	day     int64
+}
+
+// rollupClicks сворачиваем пачку в агрегаты по (ссылка, сутки), ключи по порядку
+func rollupClicks(clicks []Click) ([]rollupKey, map[rollupKey]*ClickRollup) {
+	rollups := make(map[rollupKey]*ClickRollup)
+	var keys []rollupKey
+	for _, c := range clicks {
+		day := BucketDay.Truncate(c.At)
+		k := rollupKey{shortID: c.ShortURL, day: day.Unix()}
+		if rollups[k] == nil {
+			rollups[k] = newClickRollup(c.ShortURL, day)
+			keys = append(keys, k)
+		}
+		rollups[k].add(c)
+	}
+	for _, r := range rollups {
+		r.trim()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].shortID != keys[j].shortID {
+			return keys[i].shortID < keys[j].shortID
+		}
+		return keys[i].day < keys[j].day
+	})
+	return keys, rollups
+}
@@ -0,0 +1,589 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShards сколько шардов берем, если не сказали
+const DefaultShards = 32
+
+// ShardedMemoryStorage тот же in-memory, но с блокировками по шардам:
+// записи шардируем по short_id, ссылки пользователя по user_id,
+// индекс url по ключу дедупликации.
+//
+// Порядок захвата, чтобы не словить дедлок: url-шарды (по возрастанию номера),
+// потом шард записи, потом шард пользователя. Одновременно берем не больше
+// одного шарда записи и одного шарда пользователя.
+type ShardedMemoryStorage struct {
+	entries []entryShard
+	users   []userShard
+	urls    []urlShard
+	mask    uint32
+	dedup   DedupScope
+	seq     atomic.Int64
+}
+
+type entryShard struct {
+	sync.RWMutex
+	m      map[string]*memEntry // short -> данные
+	clicks clickLog             // переходы ссылок этого шарда
+}
+
+type userShard struct {
+	sync.RWMutex
+	m map[string][]string // user -> []shortIDs
+}
+
+type urlShard struct {
+	sync.Mutex
+	m map[string]string // ключ dedup -> short
+}
+
+// NewShardedMemoryStorage shards округляем вверх до степени двойки
+func NewShardedMemoryStorage(shards int, opts ...Option) *ShardedMemoryStorage {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	o := newOptions(opts)
+	s := &ShardedMemoryStorage{
+		entries: make([]entryShard, n),
+		users:   make([]userShard, n),
+		urls:    make([]urlShard, n),
+		mask:    uint32(n - 1),
+		dedup:   o.dedup,
+	}
+	for i := 0; i < n; i++ {
+		s.entries[i].m = make(map[string]*memEntry)
+		s.entries[i].clicks = newClickLog()
+		s.users[i].m = make(map[string][]string)
+		s.urls[i].m = make(map[string]string)
+	}
+	return s
+}
+
+// fnv32 fnv-1a без аллокаций, hash/fnv хочет []byte
+func fnv32(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (s *ShardedMemoryStorage) entryShard(shortID string) *entryShard {
+	return &s.entries[fnv32(shortID)&s.mask]
+}
+
+func (s *ShardedMemoryStorage) userShard(userID string) *userShard {
+	return &s.users[fnv32(userID)&s.mask]
+}
+
+// lockURLs берем url-шарды для ключей по возрастанию номера, возвращаем unlock
+func (s *ShardedMemoryStorage) lockURLs(keys ...string) func() {
+	idx := make([]int, 0, len(keys))
+	for _, k := range keys {
+		i := int(fnv32(k) & s.mask)
+		dup := false
+		for _, j := range idx {
+			if j == i {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	for _, i := range idx {
+		s.urls[i].Lock()
+	}
+	return func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			s.urls[idx[j]].Unlock()
+		}
+	}
+}
+
+func (s *ShardedMemoryStorage) urlShard(key string) *urlShard {
+	return &s.urls[fnv32(key)&s.mask]
+}
+
+// -- методы из старого интерфейса --
+
+func (s *ShardedMemoryStorage) Save(ctx context.Context, id, url string) error {
+	return s.SaveUserURL(ctx, "", id, url)
+}
+
+func (s *ShardedMemoryStorage) SaveBatch(ctx context.Context, urls map[string]string) error {
+	return s.SaveBatchUserURLs(ctx, "", urls)
+}
+
+func (s *ShardedMemoryStorage) Load(ctx context.Context, id string) (string, error) {
+	link, err := s.LoadLink(ctx, id)
+	return link.OriginalURL, err
+}
+
+func (s *ShardedMemoryStorage) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
+	key := s.dedup.key(userID, url)
+	us := s.urlShard(key)
+	us.Lock()
+	defer us.Unlock()
+	if shortID, ok := us.m[key]; ok {
+		return shortID, nil
+	}
+	return "", ErrURLNotFound
+}
+
+// Ping как и у MemoryStorage - соединения нет
+func (s *ShardedMemoryStorage) Ping(ctx context.Context) error {
+	return errors.New("there is no connection: mem001")
+}
+func (s *ShardedMemoryStorage) Close() error { return nil }
+
+func (s *ShardedMemoryStorage) NextSequence(ctx context.Context) (int64, error) {
+	return s.seq.Add(1), nil
+}
+
+// -- методы с юид --
+
+func (s *ShardedMemoryStorage) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	return s.SaveLink(ctx, userID, Link{ShortURL: shortID, OriginalURL: originalURL})
+}
+
+func (s *ShardedMemoryStorage) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
+	return s.SaveBatchLinks(ctx, userID, linksFromMap(batch))
+}
+
+func (s *ShardedMemoryStorage) LoadLink(ctx context.Context, id string) (Link, error) {
+	es := s.entryShard(id)
+	es.RLock()
+	defer es.RUnlock()
+	entry, exists := es.m[id]
+	if !exists {
+		return Link{}, ErrURLNotFound
+	}
+	if entry.isDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	link := entry.link(id)
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// ConsumeLink списываем под Lock шарда записи
+func (s *ShardedMemoryStorage) ConsumeLink(ctx context.Context, id string) (Link, error) {
+	es := s.entryShard(id)
+	es.Lock()
+	defer es.Unlock()
+	entry, exists := es.m[id]
+	if !exists {
+		return Link{}, ErrURLNotFound
+	}
+	if entry.isDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	link := entry.link(id)
+	if err := link.check(time.Now()); err != nil {
+		return Link{}, err
+	}
+	link = link.consumed()
+	entry.clicksLeft = copyInt64(link.ClicksLeft)
+	return link, nil
+}
+
+func (s *ShardedMemoryStorage) SaveLink(ctx context.Context, userID string, link Link) error {
+	_, err := s.put(link.ShortURL, newMemEntry(userID, link), putSave)
+	return err
+}
+
+func (s *ShardedMemoryStorage) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
+	var conflicts []int
+	existing := make(map[int]string)
+	for i, link := range links {
+		existID, err := s.put(link.ShortURL, newMemEntry(userID, link), putBatch)
+		switch {
+		case errors.Is(err, ErrURLConflict):
+			existing[i] = existID
+		case errors.Is(err, ErrShortIDConflict):
+			conflicts = append(conflicts, i)
+		case err != nil:
+			return err
+		}
+	}
+	return batchConflict(conflicts, existing)
+}
+
+func (s *ShardedMemoryStorage) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
+	us := s.userShard(userID)
+	us.RLock()
+	shortIDs := append([]string(nil), us.m[userID]...)
+	us.RUnlock()
+
+	if len(shortIDs) == 0 {
+		return []UserURL{}, nil
+	}
+
+	var result []UserURL
+	for _, sid := range shortIDs {
+		es := s.entryShard(sid)
+		es.RLock()
+		entry := es.m[sid]
+		if entry != nil && entry.userID == userID && !entry.isDeleted {
+			result = append(result, entry.userURL(sid))
+		}
+		es.RUnlock()
+	}
+	return result, nil
+}
+
+func (s *ShardedMemoryStorage) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
+	now := time.Now()
+	for _, sid := range shortIDs {
+		es := s.entryShard(sid)
+		es.Lock()
+		if entry, exists := es.m[sid]; exists && entry.userID == userID && !entry.isDeleted {
+			entry.isDeleted = true
+			entry.deletedAt = now
+		}
+		es.Unlock()
+	}
+	return nil
+}
+
+func (s *ShardedMemoryStorage) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	result := make(map[string]RestoreStatus, len(shortIDs))
+	for _, sid := range shortIDs {
+		es := s.entryShard(sid)
+		es.Lock()
+		entry, exists := es.m[sid]
+		switch {
+		case !exists || entry.userID != userID:
+			result[sid] = RestoreNotFound
+		case !entry.isDeleted:
+			result[sid] = RestoreNotDeleted
+		case entry.deletedAt.Before(since):
+			result[sid] = RestoreExpired
+		default:
+			entry.isDeleted = false
+			entry.deletedAt = time.Time{}
+			result[sid] = RestoreOK
+		}
+		es.Unlock()
+	}
+	return result, nil
+}
+
+func (s *ShardedMemoryStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	orphans := make(map[string]struct{})
+	for i := range s.entries {
+		// сначала собираем кандидатов, удаляем уже в правильном порядке блокировок
+		es := &s.entries[i]
+		var candidates []string
+		es.RLock()
+		for sid, entry := range es.m {
+			if entry.isDeleted && entry.deletedAt.Before(before) {
+				candidates = append(candidates, sid)
+			}
+		}
+		es.RUnlock()
+
+		for _, sid := range candidates {
+			if ok, key := s.remove(sid, before); ok {
+				purged++
+				if key != "" {
+					orphans[key] = struct{}{}
+				}
+			}
+		}
+	}
+	s.reindex(orphans)
+	return purged, nil
+}
+
+// SaveClicks раскладываем переходы по шардам их ссылок
+func (s *ShardedMemoryStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	byShard := make(map[*entryShard][]Click)
+	for _, c := range clicks {
+		es := s.entryShard(c.ShortURL)
+		byShard[es] = append(byShard[es], c)
+	}
+	for es, batch := range byShard {
+		es.Lock()
+		es.clicks.add(batch)
+		es.Unlock()
+	}
+	return nil
+}
+
+// RollupClicks по шардам по очереди, каждый под своим локом
+func (s *ShardedMemoryStorage) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var rolled int64
+	for i := range s.entries {
+		if limit > 0 && rolled >= int64(limit) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return rolled, err
+		}
+		es := &s.entries[i]
+		es.Lock()
+		left := 0
+		if limit > 0 {
+			left = limit - int(rolled)
+		}
+		rolled += es.clicks.rollup(before, left)
+		es.Unlock()
+	}
+	return rolled, nil
+}
+
+func (s *ShardedMemoryStorage) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	es := s.entryShard(shortID)
+	es.RLock()
+	defer es.RUnlock()
+	entry, ok := es.m[shortID]
+	if !ok || entry.userID != userID {
+		return ClickStats{}, ErrURLNotFound
+	}
+	return es.clicks.stats(shortID, q), nil
+}
+
+func (s *ShardedMemoryStorage) Export(ctx context.Context, after string, fn func(Record) error) error {
+	// копируем по шарду под его локом, колбэк зовем уже без них
+	var records []Record
+	for i := range s.entries {
+		es := &s.entries[i]
+		es.RLock()
+		for sid, entry := range es.m {
+			if sid > after {
+				records = append(records, entry.record(sid))
+			}
+		}
+		es.RUnlock()
+	}
+	return exportSorted(ctx, records, fn)
+}
+
+// Import перезаписываем как есть, индексы поправит put; url перезаписанных записей
+// потом отдаем оставшимся ссылкам - см. reindex
+func (s *ShardedMemoryStorage) Import(ctx context.Context, records []Record) error {
+	orphans := make(map[string]struct{})
+	defer s.reindex(orphans)
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		es := s.entryShard(rec.ShortURL)
+		es.RLock()
+		if old := es.m[rec.ShortURL]; old != nil {
+			orphans[s.dedup.key(old.userID, old.originalURL)] = struct{}{}
+		}
+		es.RUnlock()
+		if _, err := s.put(rec.ShortURL, memEntryFromRecord(rec), putImport); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShardedMemoryStorage) Count(ctx context.Context) (int64, error) {
+	var n int64
+	for i := range s.entries {
+		es := &s.entries[i]
+		es.RLock()
+		n += int64(len(es.m))
+		es.RUnlock()
+	}
+	return n, nil
+}
+
+// putMode как put относится к уже занятому short_id
+type putMode int
+
+const (
+	// putSave как SaveLink: конфликт url, любой занятый short_id - конфликт
+	putSave putMode = iota
+	// putBatch как SaveBatchLinks: те же конфликты, но ту же ссылку не трогаем
+	putBatch
+	// putImport как Import: без проверок перезаписываем что есть
+	putImport
+)
+
+// put кладем запись, mode - как проверять конфликты; на ErrURLConflict вернем
+// short_id, который уже хранит этот url. Старую запись читаем заранее, чтобы взять и её url-шард; если пока брали
+// блокировки запись поменялась - пробуем заново
+func (s *ShardedMemoryStorage) put(shortID string, entry *memEntry, mode putMode) (string, error) {
+	es := s.entryShard(shortID)
+	key := s.dedup.key(entry.userID, entry.originalURL)
+
+	for {
+		es.RLock()
+		old := es.m[shortID]
+		es.RUnlock()
+
+		keys := []string{key}
+		var oldKey string
+		if old != nil {
+			oldKey = s.dedup.key(old.userID, old.originalURL)
+			keys = append(keys, oldKey)
+		}
+		unlock := s.lockURLs(keys...)
+		es.Lock()
+		if es.m[shortID] != old {
+			es.Unlock()
+			unlock()
+			continue
+		}
+
+		existID, err := s.putLocked(es, shortID, entry, key, old, oldKey, mode)
+		es.Unlock()
+		unlock()
+		return existID, err
+	}
+}
+
+// putLocked вызывать под url-шардами key/oldKey и шардом записи
+func (s *ShardedMemoryStorage) putLocked(es *entryShard, shortID string, entry *memEntry, key string, old *memEntry, oldKey string, mode putMode) (string, error) {
+	urls := s.urlShard(key)
+	// если какой-то другой shortID уже хранит этот url (в пределах dedup) - вернём конфликт
+	if mode != putImport && s.dedup != DedupNone {
+		if existID, ok := urls.m[key]; ok && existID != shortID {
+			return existID, ErrURLConflict
+		}
+	}
+	// если такой shortID уже есть у другого url или пользователя - это конфликт по short_id;
+	// SaveLink, как и бд, не перезаписывает и ту же ссылку
+	if old != nil && (mode == putSave || (mode == putBatch && (old.originalURL != entry.originalURL || old.userID != entry.userID))) {
+		return "", ErrShortIDConflict
+	}
+	if old != nil && mode == putBatch {
+		// та же ссылка уже сохранена - как и бд, не перезаписываем
+		return "", nil
+	}
+
+	if old != nil {
+		// short_id перезаписали - старый url больше никуда не ведет
+		if olds := s.urlShard(oldKey); olds.m[oldKey] == shortID {
+			delete(olds.m, oldKey)
+		}
+	}
+	es.m[shortID] = entry
+
+	// первый сохранивший url остается его владельцем
+	if _, ok := urls.m[key]; !ok {
+		urls.m[key] = shortID
+	}
+
+	sameOwner := old != nil && old.userID == entry.userID
+	if old != nil && !sameOwner && old.userID != "" {
+		s.unlinkUser(old.userID, shortID)
+	}
+	if entry.userID != "" && !sameOwner {
+		us := s.userShard(entry.userID)
+		us.Lock()
+		us.m[entry.userID] = append(us.m[entry.userID], shortID)
+		us.Unlock()
+	}
+	return "", nil
+}
+
+// remove выкидываем удаленную до before запись со всеми индексами; вторым вернем
+// ключ url, если индекс указывал на эту запись - см. reindex
+func (s *ShardedMemoryStorage) remove(shortID string, before time.Time) (bool, string) {
+	es := s.entryShard(shortID)
+	es.RLock()
+	entry := es.m[shortID]
+	es.RUnlock()
+	if entry == nil {
+		return false, ""
+	}
+
+	key := s.dedup.key(entry.userID, entry.originalURL)
+	unlock := s.lockURLs(key)
+	defer unlock()
+	es.Lock()
+	defer es.Unlock()
+
+	// пока брали блокировки запись могли восстановить или перезаписать
+	if es.m[shortID] != entry || !entry.isDeleted || !entry.deletedAt.Before(before) {
+		return false, ""
+	}
+	delete(es.m, shortID)
+	es.clicks.drop(shortID)
+	if entry.userID != "" {
+		s.unlinkUser(entry.userID, shortID)
+	}
+	urls := s.urlShard(key)
+	if urls.m[key] != shortID {
+		return true, ""
+	}
+	delete(urls.m, key)
+	return true, key
+}
+
+// reindex ключи url, чья ссылка из индекса пропала, отдаем оставшейся записи с тем же
+// ключом (при dedup none или после Import их бывает несколько), иначе FindIDByURL
+// ее не найдет; из нескольких берем меньший short_id. Url-шарды держим весь обход,
+// чтобы никто не занял ключ и не удалил кандидата, шарды записей берем по одному
+func (s *ShardedMemoryStorage) reindex(orphans map[string]struct{}) {
+	if len(orphans) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(orphans))
+	for key := range orphans {
+		keys = append(keys, key)
+	}
+	unlock := s.lockURLs(keys...)
+	defer unlock()
+
+	missing := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := s.urlShard(key).m[key]; !ok {
+			missing[key] = struct{}{}
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	for i := range s.entries {
+		es := &s.entries[i]
+		es.RLock()
+		for sid, entry := range es.m {
+			key := s.dedup.key(entry.userID, entry.originalURL)
+			if _, ok := missing[key]; !ok {
+				continue
+			}
+			urls := s.urlShard(key)
+			if cur, ok := urls.m[key]; !ok || sid < cur {
+				urls.m[key] = sid
+			}
+		}
+		es.RUnlock()
+	}
+}
+
+// unlinkUser убираем id из ссылок пользователя
+func (s *ShardedMemoryStorage) unlinkUser(userID, shortID string) {
+	us := s.userShard(userID)
+	us.Lock()
+	defer us.Unlock()
+	us.m[userID] = removeID(us.m[userID], shortID)
+	if len(us.m[userID]) == 0 {
+		delete(us.m, userID)
+	}
+}
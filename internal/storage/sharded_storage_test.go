@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkukarin01/snort/internal/config"
+)
+
+// TestShardedMemoryStorage_Semantics - конфликты те же, что и у MemoryStorage
+func TestShardedMemoryStorage_Semantics(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemoryStorage(4)
+
+	assert.NoError(t, s.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"), ErrShortIDConflict)
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u2", "id2", "http://ya.ru"), ErrURLConflict)
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u1", "id1", "http://github.com"), ErrShortIDConflict)
+
+	id, err := s.FindIDByURL(ctx, "", "http://ya.ru")
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", id)
+
+	// батч занятый short_id не перезаписывает, остальное сохраняет
+	var conflict *BatchConflictError
+	require.ErrorAs(t, s.SaveBatchLinks(ctx, "u1", []Link{
+		{ShortURL: "id1", OriginalURL: "http://github.com"},
+		{ShortURL: "id2", OriginalURL: "http://go.dev"},
+	}), &conflict)
+	assert.Equal(t, []int{0}, conflict.Indexes)
+
+	urls, err := s.GetUserURLs(ctx, "u1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []UserURL{
+		{ShortURL: "id1", OriginalURL: "http://ya.ru"},
+		{ShortURL: "id2", OriginalURL: "http://go.dev"},
+	}, urls)
+
+	assert.NoError(t, s.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+	_, err = s.Load(ctx, "id1")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"), ErrURLConflict)
+
+	purged, err := s.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.NoError(t, s.SaveUserURL(ctx, "u2", "id3", "http://ya.ru"))
+
+	urls, err = s.GetUserURLs(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, []UserURL{{ShortURL: "id2", OriginalURL: "http://go.dev"}}, urls)
+}
+
+// TestShardedMemoryStorage_DedupPerUser - область дедупликации тоже работает
+func TestShardedMemoryStorage_DedupPerUser(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemoryStorage(4, WithDedupScope(DedupPerUser))
+
+	assert.NoError(t, s.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	assert.NoError(t, s.SaveUserURL(ctx, "u2", "id2", "http://ya.ru"))
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u1", "id3", "http://ya.ru"), ErrURLConflict)
+	assert.ErrorIs(t, s.SaveUserURL(ctx, "u2", "id1", "http://go.dev"), ErrShortIDConflict)
+}
+
+// TestShardedMemoryStorage_ConcurrentConflict - из гонки за один url выигрывает ровно один
+func TestShardedMemoryStorage_ConcurrentConflict(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemoryStorage(8)
+
+	var (
+		wg  sync.WaitGroup
+		won atomic.Int32
+	)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.SaveUserURL(ctx, fmt.Sprintf("u%d", i), fmt.Sprintf("id%d", i), "http://ya.ru")
+			if err == nil {
+				won.Add(1)
+				return
+			}
+			assert.ErrorIs(t, err, ErrURLConflict)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), won.Load())
+}
+
+// TestOpen_MemShards - mem://?shards=N включает шардированный вариант
+func TestOpen_MemShards(t *testing.T) {
+	store, err := Open("mem://?shards=10", &config.Config{})
+	require.NoError(t, err)
+	s, ok := store.(*ShardedMemoryStorage)
+	require.True(t, ok)
+	assert.Len(t, s.entries, 16)
+
+	_, err = Open("mem://?shards=zero", &config.Config{})
+	assert.Error(t, err)
+}
+
+// TestShardedMemoryStorage_ExportImport - mem://?shards=N годится snortctl и как источник, и как приемник
+func TestShardedMemoryStorage_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	limit := int64(3)
+	src := NewMemoryStorage()
+	require.NoError(t, src.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+	require.NoError(t, src.SaveLink(ctx, "u2", Link{ShortURL: "id2", OriginalURL: "http://go.dev", ClicksLeft: &limit}))
+	require.NoError(t, src.SaveUserURL(ctx, "u1", "id3", "http://github.com"))
+	require.NoError(t, src.MarkUserURLsDeleted(ctx, "u1", []string{"id3"}))
+
+	store, err := Open("mem://?shards=4", &config.Config{})
+	require.NoError(t, err)
+	dst, ok := store.(Importer)
+	require.True(t, ok)
+	exp, ok := store.(Exporter)
+	require.True(t, ok)
+
+	var records []Record
+	require.NoError(t, src.Export(ctx, "", func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	}))
+	require.NoError(t, dst.Import(ctx, records))
+	// повторный импорт ничего не дублирует
+	require.NoError(t, dst.Import(ctx, records))
+
+	count, err := dst.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	var exported []Record
+	require.NoError(t, exp.Export(ctx, "id1", func(rec Record) error {
+		exported = append(exported, rec)
+		return nil
+	}))
+	assert.Equal(t, records[1:], exported)
+
+	s := store.(*ShardedMemoryStorage)
+	_, err = s.Load(ctx, "id3")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+	urls, err := s.GetUserURLs(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []UserURL{{ShortURL: "id1", OriginalURL: "http://ya.ru"}}, urls)
+
+	// импорт перезаписывает и чужую ссылку, индексы пользователей и url за ним следуют
+	require.NoError(t, dst.Import(ctx, []Record{{ShortURL: "id1", OriginalURL: "http://yandex.ru", UserID: "u3"}}))
+	urls, err = s.GetUserURLs(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+	id, err := s.FindIDByURL(ctx, "", "http://yandex.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "id1", id)
+	_, err = s.FindIDByURL(ctx, "", "http://ya.ru")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+}
+
+// BenchmarkMemory_Parallel - сравниваем общий мьютекс и шарды под параллельной нагрузкой,
+// примерно как в нагрузочных: на одну запись несколько редиректов
+func BenchmarkMemory_Parallel(b *testing.B) {
+	backends := []struct {
+		name string
+		new  func() Storager
+	}{
+		{"single-lock", func() Storager { return NewMemoryStorage() }},
+		{"sharded", func() Storager { return NewShardedMemoryStorage(DefaultShards) }},
+	}
+
+	for _, be := range backends {
+		b.Run(be.name, func(b *testing.B) {
+			ctx := context.Background()
+			store := be.new()
+			for i := 0; i < 10_000; i++ {
+				if err := store.SaveUserURL(ctx, "seed", fmt.Sprintf("seed%d", i), fmt.Sprintf("http://seed.com/%d", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var seq atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := seq.Add(1)
+					if n%4 == 0 {
+						_ = store.SaveUserURL(ctx, fmt.Sprintf("u%d", n%100), fmt.Sprintf("id%d", n), fmt.Sprintf("http://example.com/%d", n))
+						continue
+					}
+					_, _ = store.Load(ctx, fmt.Sprintf("seed%d", n%10_000))
+				}
+			})
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/mkukarin01/snort/internal/config"
 )
@@ -10,7 +13,7 @@ import (
 var (
 	// ErrDBConnection - проблема связи с бд
 	ErrDBConnection = errors.New("db connection issue")
-	// ErrURLConflict - original_url уже есть в базе
+	// ErrURLConflict - original_url уже есть в базе (в пределах DedupScope)
 	ErrURLConflict = errors.New("url conflict")
 	// ErrShortIDConflict - короткий short_id уже занят
 	ErrShortIDConflict = errors.New("short_id conflict")
@@ -18,41 +21,202 @@ var (
 	ErrURLNotFound = errors.New("url not found")
 	// ErrURLDeleted - is_deleted=true
 	ErrURLDeleted = errors.New("url is deleted")
+	// ErrURLExpired - срок жизни ссылки вышел
+	ErrURLExpired = errors.New("url is expired")
+	// ErrURLExhausted - лимит переходов по ссылке исчерпан
+	ErrURLExhausted = errors.New("url click limit is exhausted")
 )
 
+// BatchConflictError - часть ссылок батча не сохранили: их short_id занят другой ссылкой
+// (чужой или с другим url, в том числе раньше в этом же батче) или их url уже сокращен
+// в пределах DedupScope; остальные ссылки сохранены. errors.Is сработает для
+// ErrShortIDConflict, если есть Indexes, и для ErrURLConflict, если есть Existing
+type BatchConflictError struct {
+	// Indexes номера ссылок батча с занятым short_id, по возрастанию
+	Indexes []int
+	// Existing номер ссылки батча -> short_id, под которым её url уже сохранен
+	Existing map[int]string
+}
+
+func (e *BatchConflictError) Error() string {
+	return fmt.Sprintf("batch conflict: %d short_id conflicts, %d url conflicts", len(e.Indexes), len(e.Existing))
+}
+
+func (e *BatchConflictError) Unwrap() []error {
+	var errs []error
+	if len(e.Indexes) > 0 {
+		errs = append(errs, ErrShortIDConflict)
+	}
+	if len(e.Existing) > 0 {
+		errs = append(errs, ErrURLConflict)
+	}
+	return errs
+}
+
+// batchConflict ошибка батча по номерам конфликтов, нет конфликтов - nil
+func batchConflict(indexes []int, existing map[int]string) error {
+	if len(indexes) == 0 && len(existing) == 0 {
+		return nil
+	}
+	return &BatchConflictError{Indexes: indexes, Existing: existing}
+}
+
+// RestoreStatus - результат восстановления одной ссылки
+type RestoreStatus string
+
+const (
+	// RestoreOK - флаг удаления снят
+	RestoreOK RestoreStatus = "restored"
+	// RestoreNotFound - нет такой ссылки у пользователя (в т.ч. уже очищена)
+	RestoreNotFound RestoreStatus = "not_found"
+	// RestoreNotDeleted - ссылка и так не удалена
+	RestoreNotDeleted RestoreStatus = "not_deleted"
+	// RestoreExpired - удалена раньше, чем разрешает период восстановления
+	RestoreExpired RestoreStatus = "expired"
+)
+
+// Link - ссылка со всеми свойствами, которые задаются при создании
+type Link struct {
+	ShortURL    string
+	OriginalURL string
+	// ExpiresAt с этого момента редирект не работает, nil - бессрочная
+	ExpiresAt *time.Time
+	// ClicksLeft сколько переходов осталось, nil - без ограничения
+	ClicksLeft *int64
+	// PasswordHash bcrypt хэш пароля (соль внутри), "" - ссылка открытая
+	PasswordHash string
+}
+
+// Expired истек ли срок жизни к моменту now
+func (l Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// Exhausted переходы по ссылке кончились
+func (l Link) Exhausted() bool {
+	return l.ClicksLeft != nil && *l.ClicksLeft <= 0
+}
+
+// check общая для бэкендов проверка ссылки перед отдачей, порядок ошибок как в LoadLink
+func (l Link) check(now time.Time) error {
+	if l.Expired(now) {
+		return ErrURLExpired
+	}
+	if l.Exhausted() {
+		return ErrURLExhausted
+	}
+	return nil
+}
+
+// consumed копия ссылки с одним потраченным переходом, счетчик не делим с хранилищем
+func (l Link) consumed() Link {
+	if l.ClicksLeft != nil {
+		left := *l.ClicksLeft - 1
+		l.ClicksLeft = &left
+	}
+	return l
+}
+
+// linksFromMap батч старого формата short_id -> url
+func linksFromMap(batch map[string]string) []Link {
+	links := make([]Link, 0, len(batch))
+	for shortID, originalURL := range batch {
+		links = append(links, Link{ShortURL: shortID, OriginalURL: originalURL})
+	}
+	return links
+}
+
 // UserURL - для возврата набора ссылок конкретного пользователя
 type UserURL struct {
 	ShortURL    string
 	OriginalURL string
+	ExpiresAt   *time.Time
+	ClicksLeft  *int64
+	// Protected у ссылки есть пароль, сам хэш наружу не отдаем
+	Protected bool
 }
 
 // Storager - интерфейс для работы с бд или другим хранилищем
+// все методы кроме Close принимают контекст запроса, отмена/дедлайн прерывают операцию
 type Storager interface {
-	Ping() error
+	Ping(ctx context.Context) error
 	Close() error
 
 	// Старые методы (без userID)
-	Save(id, url string) error
-	SaveBatch(urls map[string]string) error
-	Load(id string) (string, error)
-	FindIDByURL(url string) (string, error)
+	Save(ctx context.Context, id, url string) error
+	SaveBatch(ctx context.Context, urls map[string]string) error
+	Load(ctx context.Context, id string) (string, error)
+	// FindIDByURL userID учитывается, когда дедупликация не глобальная
+	FindIDByURL(ctx context.Context, userID, url string) (string, error)
 
 	// Новые методы для работы с userID
-	SaveUserURL(userID, shortID, originalURL string) error
-	SaveBatchUserURLs(userID string, batch map[string]string) error
-	GetUserURLs(userID string) ([]UserURL, error)
+	SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error
+	SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error
+	GetUserURLs(ctx context.Context, userID string) ([]UserURL, error)
+
+	// SaveLink то же что SaveUserURL, но со свойствами ссылки (срок жизни и т.п.)
+	SaveLink(ctx context.Context, userID string, link Link) error
+	// SaveBatchLinks short_id, который уже хранит ту же ссылку того же пользователя,
+	// не перезаписываем; занятые другой ссылкой short_id и уже сокращенные (в пределах
+	// DedupScope) url не сохраняем и возвращаем в *BatchConflictError
+	SaveBatchLinks(ctx context.Context, userID string, links []Link) error
+	// LoadLink ссылка целиком; как и Load вернет ErrURLDeleted/ErrURLExpired/ErrURLExhausted
+	LoadLink(ctx context.Context, id string) (Link, error)
+	// ConsumeLink то же что LoadLink, но атомарно тратит один переход, если у ссылки
+	// есть лимит; вернет ссылку с уже уменьшенным ClicksLeft
+	ConsumeLink(ctx context.Context, id string) (Link, error)
 
 	// Новый метод для проставления флага удаления
-	MarkUserURLsDeleted(userID string, shortIDs []string) error
+	MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error
+	// RestoreUserURLs снимаем флаг удаления со ссылок пользователя, удаленных не раньше since
+	RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error)
+	// NextSequence очередной номер для генератора short_id на счетчике, номера не повторяются
+	// в пределах хранилища (в памяти и файле - в пределах процесса, коллизии разрулит повтор)
+	NextSequence(ctx context.Context) (int64, error)
+	// PurgeDeleted окончательно удаляем ссылки, помеченные удаленными раньше before,
+	// short_id и url после этого снова свободны (вместе с переходами); вернем сколько удалили
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+
+	// SaveClicks пачка переходов по ссылкам, пишет фоновый ClickRecorder
+	SaveClicks(ctx context.Context, clicks []Click) error
+	// ClickStats статистика переходов ссылки для владельца: чужая или несуществующая
+	// ссылка - ErrURLNotFound, удаленную еще не очищенную считаем как обычно
+	ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error)
+	// RollupClicks сворачиваем сырые переходы старше before в суточные агрегаты ссылок
+	// и удаляем их; за вызов не больше примерно limit переходов, вернем сколько свернули
+	RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error)
 }
 
-// NewStorage определяет используемое хранилище
+// NewStorage определяет используемое хранилище и навешивает на него дедлайны и кэш из конфига
+// если задан -storage, бэкенд выбирается по схеме, иначе по старым -d / -f
 func NewStorage(cfg *config.Config) (Storager, error) {
+	store, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// кэш снаружи: попадание в кэш не тратит таймаут, а промах грузится уже с дедлайном
+	store = WithTimeouts(store, Timeouts{
+		Read:  cfg.StorageReadTimeout,
+		Write: cfg.StorageWriteTimeout,
+	})
+	return WithCache(store, CacheOptions{
+		Size:        cfg.CacheSize,
+		TTL:         cfg.CacheTTL,
+		NegativeTTL: cfg.CacheNegativeTTL,
+	}), nil
+}
+
+func newBackend(cfg *config.Config) (Storager, error) {
+	if cfg.Storage != "" {
+		return Open(cfg.Storage, cfg)
+	}
+
+	// DSN может быть и в key=value виде, так что схему не угадываем
 	if cfg.DatabaseDSN != "" {
-		return NewDatabase(cfg.DatabaseDSN)
+		return openScheme("postgres", cfg.DatabaseDSN, cfg)
 	}
 	if cfg.FileStoragePath != "" {
-		return NewFileStorage(cfg.FileStoragePath)
+		return openScheme("file", fileScheme+cfg.FileStoragePath, cfg)
 	}
-	return NewMemoryStorage(), nil
+	return openScheme("mem", memScheme, cfg)
 }
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backends все бэкенды, которые поднимаются без внешних сервисов, с одними опциями
+func backends(t *testing.T, opts ...Option) map[string]Storager {
+	t.Helper()
+	fs, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.json"), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { fs.Close() })
+
+	return map[string]Storager{
+		"memory":  NewMemoryStorage(opts...),
+		"sharded": NewShardedMemoryStorage(4, opts...),
+		"file":    fs,
+	}
+}
+
+// TestSaveBatchLinks_Dedup - уже сокращенный url батч не сохраняет второй раз,
+// а отдает его short_id; в пределах батча так же
+func TestSaveBatchLinks_Dedup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("global", func(t *testing.T) {
+		for name, store := range backends(t, WithDedupScope(DedupGlobal)) {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+
+				var conflict *BatchConflictError
+				require.ErrorAs(t, store.SaveBatchLinks(ctx, "u2", []Link{
+					{ShortURL: "id2", OriginalURL: "http://ya.ru"},
+					{ShortURL: "id3", OriginalURL: "http://go.dev"},
+					{ShortURL: "id4", OriginalURL: "http://go.dev"},
+				}), &conflict)
+				assert.Empty(t, conflict.Indexes)
+				assert.Equal(t, map[int]string{0: "id1", 2: "id3"}, conflict.Existing)
+
+				_, err := store.Load(ctx, "id2")
+				assert.ErrorIs(t, err, ErrURLNotFound)
+				_, err = store.Load(ctx, "id4")
+				assert.ErrorIs(t, err, ErrURLNotFound)
+				urls, err := store.GetUserURLs(ctx, "u2")
+				require.NoError(t, err)
+				assert.Equal(t, []UserURL{{ShortURL: "id3", OriginalURL: "http://go.dev"}}, urls)
+
+				// та же ссылка под тем же id - не конфликт
+				assert.NoError(t, store.SaveBatchLinks(ctx, "u2", []Link{{ShortURL: "id3", OriginalURL: "http://go.dev"}}))
+			})
+		}
+	})
+
+	t.Run("per-user", func(t *testing.T) {
+		for name, store := range backends(t, WithDedupScope(DedupPerUser)) {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+
+				// у другого пользователя тот же url - своя ссылка
+				assert.NoError(t, store.SaveBatchLinks(ctx, "u2", []Link{{ShortURL: "id2", OriginalURL: "http://ya.ru"}}))
+
+				var conflict *BatchConflictError
+				err := store.SaveBatchLinks(ctx, "u1", []Link{
+					{ShortURL: "id3", OriginalURL: "http://ya.ru"},
+					{ShortURL: "id2", OriginalURL: "http://go.dev"},
+				})
+				require.ErrorAs(t, err, &conflict)
+				assert.ErrorIs(t, err, ErrURLConflict)
+				assert.ErrorIs(t, err, ErrShortIDConflict)
+				assert.Equal(t, []int{1}, conflict.Indexes)
+				assert.Equal(t, map[int]string{0: "id1"}, conflict.Existing)
+
+				urls, err := store.GetUserURLs(ctx, "u1")
+				require.NoError(t, err)
+				assert.Equal(t, []UserURL{{ShortURL: "id1", OriginalURL: "http://ya.ru"}}, urls)
+			})
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		for name, store := range backends(t, WithDedupScope(DedupNone)) {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+				assert.NoError(t, store.SaveBatchLinks(ctx, "u1", []Link{
+					{ShortURL: "id2", OriginalURL: "http://ya.ru"},
+					{ShortURL: "id3", OriginalURL: "http://ya.ru"},
+				}))
+
+				urls, err := store.GetUserURLs(ctx, "u1")
+				require.NoError(t, err)
+				assert.Len(t, urls, 3)
+			})
+		}
+	})
+}
+
+// TestSaveLink_ExistingID - занятый short_id SaveLink не перезаписывает даже той же ссылкой,
+// как и бд: удаленная не оживает, лимит переходов, срок и пароль не сбрасываются;
+// повтор в батче ничего не меняет
+func TestSaveLink_ExistingID(t *testing.T) {
+	ctx := context.Background()
+	for _, scope := range []DedupScope{DedupGlobal, DedupPerUser, DedupNone} {
+		for name, store := range backends(t, WithDedupScope(scope)) {
+			t.Run(string(scope)+"/"+name, func(t *testing.T) {
+				once := int64(1)
+				require.NoError(t, store.SaveLink(ctx, "u1", Link{ShortURL: "once", OriginalURL: "http://once.ru", ClicksLeft: &once, PasswordHash: "hash"}))
+				_, err := store.ConsumeLink(ctx, "once")
+				require.NoError(t, err)
+
+				assert.ErrorIs(t, store.SaveLink(ctx, "u1", Link{ShortURL: "once", OriginalURL: "http://once.ru"}), ErrShortIDConflict)
+				assert.NoError(t, store.SaveBatchLinks(ctx, "u1", []Link{{ShortURL: "once", OriginalURL: "http://once.ru"}}))
+				_, err = store.Load(ctx, "once")
+				assert.ErrorIs(t, err, ErrURLExhausted)
+
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "gone", "http://gone.ru"))
+				require.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"gone"}))
+				assert.ErrorIs(t, store.SaveUserURL(ctx, "u1", "gone", "http://gone.ru"), ErrShortIDConflict)
+				assert.NoError(t, store.SaveBatchLinks(ctx, "u1", []Link{{ShortURL: "gone", OriginalURL: "http://gone.ru"}}))
+				_, err = store.Load(ctx, "gone")
+				assert.ErrorIs(t, err, ErrURLDeleted)
+
+				urls, err := store.GetUserURLs(ctx, "u1")
+				require.NoError(t, err)
+				require.Len(t, urls, 1)
+				assert.True(t, urls[0].Protected)
+				assert.EqualValues(t, 0, *urls[0].ClicksLeft)
+			})
+		}
+	}
+}
+
+// TestPurgeDeleted_Reindex - если удалили ссылку, на которую смотрел индекс url, а с тем же
+// url живет другая (dedup none или после Import), FindIDByURL находит оставшуюся
+func TestPurgeDeleted_Reindex(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("none", func(t *testing.T) {
+		for name, store := range backends(t, WithDedupScope(DedupNone)) {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id2", "http://ya.ru"))
+				id, err := store.FindIDByURL(ctx, "u1", "http://ya.ru")
+				require.NoError(t, err)
+				require.Equal(t, "id1", id)
+
+				require.NoError(t, store.MarkUserURLsDeleted(ctx, "u1", []string{"id1"}))
+				purged, err := store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+				require.NoError(t, err)
+				require.EqualValues(t, 1, purged)
+
+				id, err = store.FindIDByURL(ctx, "u1", "http://ya.ru")
+				require.NoError(t, err)
+				assert.Equal(t, "id2", id)
+			})
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		for name, store := range backends(t, WithDedupScope(DedupGlobal)) {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, store.SaveUserURL(ctx, "u1", "id1", "http://ya.ru"))
+				// импорт dedup не проверяет - второй id на тот же url
+				importer := store.(Importer)
+				require.NoError(t, importer.Import(ctx, []Record{{ShortURL: "id2", OriginalURL: "http://ya.ru", UserID: "u2"}}))
+
+				// id1 перезаписали другим url - ya.ru остался только у id2
+				require.NoError(t, importer.Import(ctx, []Record{{ShortURL: "id1", OriginalURL: "http://go.dev", UserID: "u1"}}))
+				id, err := store.FindIDByURL(ctx, "", "http://ya.ru")
+				require.NoError(t, err)
+				assert.Equal(t, "id2", id)
+
+				// и пурж держателя индекса отдает url оставшемуся id
+				require.NoError(t, importer.Import(ctx, []Record{{ShortURL: "id3", OriginalURL: "http://ya.ru", UserID: "u3"}}))
+				require.NoError(t, store.MarkUserURLsDeleted(ctx, "u2", []string{"id2"}))
+				_, err = store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+				require.NoError(t, err)
+				id, err = store.FindIDByURL(ctx, "", "http://ya.ru")
+				require.NoError(t, err)
+				assert.Equal(t, "id3", id)
+			})
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts дедлайны на операции с хранилищем, 0 - без дедлайна (только контекст запроса)
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+// timeoutStorage обертка над любым хранилищем, навешивает дедлайн на каждую операцию
+// поверх контекста запроса, чтобы подвисший бэкенд не держал хендлер бесконечно
+type timeoutStorage struct {
+	store    Storager
+	timeouts Timeouts
+}
+
+// WithTimeouts оборачиваем хранилище дедлайнами, пустые таймауты - возвращаем как есть
+func WithTimeouts(store Storager, timeouts Timeouts) Storager {
+	if timeouts.Read <= 0 && timeouts.Write <= 0 {
+		return store
+	}
+	return &timeoutStorage{store: store, timeouts: timeouts}
+}
+
+func (ts *timeoutStorage) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, ts.timeouts.Read)
+}
+
+func (ts *timeoutStorage) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, ts.timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (ts *timeoutStorage) Ping(ctx context.Context) error {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.Ping(ctx)
+}
+
+func (ts *timeoutStorage) Close() error {
+	return ts.store.Close()
+}
+
+func (ts *timeoutStorage) Save(ctx context.Context, id, url string) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.Save(ctx, id, url)
+}
+
+func (ts *timeoutStorage) SaveBatch(ctx context.Context, urls map[string]string) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveBatch(ctx, urls)
+}
+
+func (ts *timeoutStorage) Load(ctx context.Context, id string) (string, error) {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.Load(ctx, id)
+}
+
+func (ts *timeoutStorage) FindIDByURL(ctx context.Context, userID, url string) (string, error) {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.FindIDByURL(ctx, userID, url)
+}
+
+func (ts *timeoutStorage) SaveUserURL(ctx context.Context, userID, shortID, originalURL string) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveUserURL(ctx, userID, shortID, originalURL)
+}
+
+func (ts *timeoutStorage) SaveBatchUserURLs(ctx context.Context, userID string, batch map[string]string) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveBatchUserURLs(ctx, userID, batch)
+}
+
+func (ts *timeoutStorage) GetUserURLs(ctx context.Context, userID string) ([]UserURL, error) {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.GetUserURLs(ctx, userID)
+}
+
+func (ts *timeoutStorage) MarkUserURLsDeleted(ctx context.Context, userID string, shortIDs []string) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.MarkUserURLsDeleted(ctx, userID, shortIDs)
+}
+
+func (ts *timeoutStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.PurgeDeleted(ctx, before)
+}
+
+func (ts *timeoutStorage) RestoreUserURLs(ctx context.Context, userID string, shortIDs []string, since time.Time) (map[string]RestoreStatus, error) {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.RestoreUserURLs(ctx, userID, shortIDs, since)
+}
+
+func (ts *timeoutStorage) SaveLink(ctx context.Context, userID string, link Link) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveLink(ctx, userID, link)
+}
+
+func (ts *timeoutStorage) SaveBatchLinks(ctx context.Context, userID string, links []Link) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveBatchLinks(ctx, userID, links)
+}
+
+func (ts *timeoutStorage) LoadLink(ctx context.Context, id string) (Link, error) {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.LoadLink(ctx, id)
+}
+
+// NextSequence двигает последовательность - тоже запись
+func (ts *timeoutStorage) NextSequence(ctx context.Context) (int64, error) {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.NextSequence(ctx)
+}
+
+func (ts *timeoutStorage) RollupClicks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.RollupClicks(ctx, before, limit)
+}
+
+func (ts *timeoutStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.SaveClicks(ctx, clicks)
+}
+
+func (ts *timeoutStorage) ClickStats(ctx context.Context, userID, shortID string, q ClickQuery) (ClickStats, error) {
+	ctx, cancel := ts.read(ctx)
+	defer cancel()
+	return ts.store.ClickStats(ctx, userID, shortID, q)
+}
+
+// ConsumeLink пишет счетчик, поэтому таймаут как у записи
+func (ts *timeoutStorage) ConsumeLink(ctx context.Context, id string) (Link, error) {
+	ctx, cancel := ts.write(ctx)
+	defer cancel()
+	return ts.store.ConsumeLink(ctx, id)
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWithTimeouts_Deadlines - чтения и записи получают свой дедлайн
+func TestWithTimeouts_Deadlines(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := NewMockStorager(ctrl)
+	store := WithTimeouts(mockDB, Timeouts{Read: time.Second, Write: time.Minute})
+
+	mockDB.EXPECT().Load(gomock.Any(), "id").DoAndReturn(func(ctx context.Context, id string) (string, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+		return "http://ya.ru", nil
+	})
+	mockDB.EXPECT().SaveUserURL(gomock.Any(), "u", "id", "http://ya.ru").DoAndReturn(
+		func(ctx context.Context, userID, shortID, originalURL string) error {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 100*time.Millisecond)
+			return nil
+		})
+
+	_, err := store.Load(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveUserURL(context.Background(), "u", "id", "http://ya.ru"))
+}
+
+// TestWithTimeouts_Expired - зависший бэкенд отпускаем по дедлайну
+func TestWithTimeouts_Expired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := NewMockStorager(ctrl)
+	store := WithTimeouts(mockDB, Timeouts{Read: 10 * time.Millisecond})
+
+	mockDB.EXPECT().GetUserURLs(gomock.Any(), "u").DoAndReturn(func(ctx context.Context, userID string) ([]UserURL, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	_, err := store.GetUserURLs(context.Background(), "u")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestWithTimeouts_Disabled - без таймаутов обертки нет
+func TestWithTimeouts_Disabled(t *testing.T) {
+	ms := NewMemoryStorage()
+	assert.Same(t, ms, WithTimeouts(ms, Timeouts{}))
+}
@@ -0,0 +1,187 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// формат архива: по storage.Record на строку (NDJSON), поля как в storage.json,
+// записи по возрастанию short_id - иначе с checkpoint не продолжить
+
+// maxLineSize длинные url бывают, но не мегабайтные
+const maxLineSize = 1 << 20
+
+// ArchiveReader архив как источник переноса
+type ArchiveReader struct {
+	path string
+}
+
+// OpenArchive проверяем что файл есть, читаем уже в Export
+func OpenArchive(path string) (*ArchiveReader, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &ArchiveReader{path: path}, nil
+}
+
+// Export отдаем записи после after; неотсортированный архив читаем только с начала
+func (a *ArchiveReader) Export(ctx context.Context, after string, fn func(storage.Record) error) error {
+	var prev string
+	return a.scan(func(line int, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var rec storage.Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("%s:%d: %w", a.path, line, err)
+		}
+		if rec.ShortURL == "" {
+			return fmt.Errorf("%s:%d: empty short_url", a.path, line)
+		}
+		if after != "" && rec.ShortURL < prev {
+			return fmt.Errorf("%s:%d: archive is not sorted by short_url, can't resume after %q", a.path, line, after)
+		}
+		prev = rec.ShortURL
+		if rec.ShortURL <= after {
+			return nil
+		}
+		return fn(rec)
+	})
+}
+
+// Count сколько записей в архиве
+func (a *ArchiveReader) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := a.scan(func(int, []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// scan пустые строки пропускаем
+func (a *ArchiveReader) scan(fn func(line int, data []byte) error) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if err := fn(line, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ArchiveWriter архив как приемник переноса
+type ArchiveWriter struct {
+	f       *os.File
+	written int64
+}
+
+// CreateArchive after - short_id из checkpoint: архив обрезаем сразу после него
+// и дописываем дальше (хвост мог остаться от пачки, упавшей до checkpoint);
+// "" - начинаем файл заново
+func CreateArchive(path, after string) (*ArchiveWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	aw := &ArchiveWriter{f: f}
+	var size int64
+	if after != "" {
+		if size, aw.written, err = resumeOffset(f, after); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return aw, nil
+}
+
+// resumeOffset где заканчивается последняя запись с short_id <= after и сколько их
+func resumeOffset(f *os.File, after string) (offset, records int64, err error) {
+	reader := bufio.NewReader(f)
+	var pos int64
+	for {
+		raw, readErr := reader.ReadBytes('\n')
+		pos += int64(len(raw))
+		// недописанную строку без \n не считаем
+		if readErr == nil {
+			if data := bytes.TrimSpace(raw); len(data) > 0 {
+				var rec storage.Record
+				if err := json.Unmarshal(data, &rec); err != nil {
+					return 0, 0, err
+				}
+				if rec.ShortURL > after {
+					return offset, records, nil
+				}
+				records++
+			}
+			offset = pos
+		}
+		if readErr == io.EOF {
+			return offset, records, nil
+		}
+		if readErr != nil {
+			return 0, 0, readErr
+		}
+	}
+}
+
+// Import пачку пишем одним куском и fsync, только потом двигаем checkpoint
+func (a *ArchiveWriter) Import(ctx context.Context, records []storage.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(a.f, &buf); err != nil {
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.written += int64(len(records))
+	return nil
+}
+
+// Count сколько записей в архиве
+func (a *ArchiveWriter) Count(ctx context.Context) (int64, error) {
+	return a.written, nil
+}
+
+func (a *ArchiveWriter) Close() error {
+	return a.f.Close()
+}
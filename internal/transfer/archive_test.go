@@ -0,0 +1,83 @@
+package transfer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// TestArchive_RoundTrip - выгрузили в архив и загрузили в другое хранилище
+func TestArchive_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := seedFile(t, 7)
+	path := filepath.Join(t.TempDir(), "links.ndjson")
+
+	aw, err := CreateArchive(path, "")
+	require.NoError(t, err)
+	_, err = Copy(ctx, src, aw, Options{BatchSize: 2})
+	require.NoError(t, err)
+	require.NoError(t, aw.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 7)
+	assert.Contains(t, lines[0], `"short_url":"id000"`)
+	assert.Contains(t, lines[0], `"is_deleted":true`)
+
+	ar, err := OpenArchive(path)
+	require.NoError(t, err)
+	dst := storage.NewMemoryStorage()
+	stats, err := Copy(ctx, ar, dst, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), stats.SourceCount)
+	assert.Equal(t, int64(7), stats.DestCount)
+
+	_, err = dst.Load(ctx, "id006")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+}
+
+// TestArchive_ResumeTruncatesTail - хвост пачки, не попавшей в checkpoint, выкидываем
+func TestArchive_ResumeTruncatesTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.ndjson")
+	content := `{"short_url":"a","original_url":"http://a","user_id":"u","is_deleted":false}
+{"short_url":"b","original_url":"http://b","user_id":"u","is_deleted":false}
+{"short_url":"c","original_url":"http://c","user_id":"u","is_deleted":false}
+{"short_url":"d","orig`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	aw, err := CreateArchive(path, "b")
+	require.NoError(t, err)
+	count, err := aw.Count(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	require.NoError(t, aw.Import(context.Background(), []storage.Record{{ShortURL: "c", OriginalURL: "http://c", UserID: "u"}}))
+	require.NoError(t, aw.Close())
+
+	ar, err := OpenArchive(path)
+	require.NoError(t, err)
+	var ids []string
+	require.NoError(t, ar.Export(context.Background(), "", func(rec storage.Record) error {
+		ids = append(ids, rec.ShortURL)
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+}
+
+// TestArchive_BadLine - битая строка - ошибка с номером строки
+func TestArchive_BadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.ndjson")
+	require.NoError(t, os.WriteFile(path, []byte("{\"short_url\":\"a\"}\n\nnot json\n"), 0644))
+
+	ar, err := OpenArchive(path)
+	require.NoError(t, err)
+	_, err = Copy(context.Background(), ar, storage.NewMemoryStorage(), Options{})
+	assert.ErrorContains(t, err, "links.ndjson:3")
+}
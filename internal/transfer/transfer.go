@@ -0,0 +1,188 @@
+// Package transfer перенос ссылок между бэкендами и через NDJSON архив
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// DefaultBatchSize сколько записей пишем в приемник за раз
+const DefaultBatchSize = 500
+
+// ErrCountMismatch в приемнике после переноса меньше записей, чем в источнике
+var ErrCountMismatch = errors.New("record count mismatch")
+
+// Options настройки переноса
+type Options struct {
+	// BatchSize размер пачки, 0 - DefaultBatchSize
+	BatchSize int
+	// Checkpoint файл с последним перенесенным short_id, "" - без возобновления
+	Checkpoint string
+	// Source, Dest подписи источника и приемника, чтобы не продолжить чужой checkpoint
+	Source string
+	Dest   string
+	// Progress зовем после каждой пачки
+	Progress func(Stats)
+}
+
+// Stats итог переноса
+type Stats struct {
+	// ResumedAfter с какого short_id продолжили, "" - с начала
+	ResumedAfter string
+	// Copied сколько записей перенесено всего, вместе с прошлыми запусками
+	Copied      int64
+	SourceCount int64
+	DestCount   int64
+}
+
+// checkpoint состояние переноса на диске
+type checkpoint struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+	Last   string `json:"last"`
+	Copied int64  `json:"copied"`
+}
+
+// Copy переносим все записи src в dst пачками по возрастанию short_id.
+// После каждой пачки сохраняем checkpoint, повторный запуск продолжит с него;
+// приемник перезаписывает записи по short_id, так что повтор пачки безопасен.
+// В конце сверяем количество записей, при успехе checkpoint удаляем
+func Copy(ctx context.Context, src storage.Exporter, dst storage.Importer, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	var stats Stats
+	cp := checkpoint{Source: opts.Source, Dest: opts.Dest}
+	if opts.Checkpoint != "" {
+		saved, err := loadCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return stats, err
+		}
+		if saved != nil {
+			if saved.Source != opts.Source || saved.Dest != opts.Dest {
+				return stats, fmt.Errorf("checkpoint %s belongs to %s -> %s, remove it to start over",
+					opts.Checkpoint, saved.Source, saved.Dest)
+			}
+			cp = *saved
+			stats.ResumedAfter = cp.Last
+		}
+	}
+	stats.Copied = cp.Copied
+
+	batch := make([]storage.Record, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dst.Import(ctx, batch); err != nil {
+			return fmt.Errorf("import after %q: %w", cp.Last, err)
+		}
+		cp.Last = batch[len(batch)-1].ShortURL
+		cp.Copied += int64(len(batch))
+		stats.Copied = cp.Copied
+		batch = batch[:0]
+
+		if opts.Checkpoint != "" {
+			if err := saveCheckpoint(opts.Checkpoint, cp); err != nil {
+				return err
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+		return nil
+	}
+
+	err := src.Export(ctx, cp.Last, func(rec storage.Record) error {
+		batch = append(batch, rec)
+		if len(batch) < opts.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return stats, fmt.Errorf("export: %w", err)
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+
+	if stats.SourceCount, err = src.Count(ctx); err != nil {
+		return stats, fmt.Errorf("count source: %w", err)
+	}
+	if stats.DestCount, err = dst.Count(ctx); err != nil {
+		return stats, fmt.Errorf("count dest: %w", err)
+	}
+	// в приемнике могли быть свои записи, поэтому больше - можно, меньше - нет
+	if stats.DestCount < stats.SourceCount {
+		return stats, fmt.Errorf("%w: source has %d, dest has %d", ErrCountMismatch, stats.SourceCount, stats.DestCount)
+	}
+
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// ResumeAfter с какого short_id продолжит Copy по этому checkpoint, "" - с начала
+func ResumeAfter(path string) (string, error) {
+	cp, err := loadCheckpoint(path)
+	if err != nil || cp == nil {
+		return "", err
+	}
+	return cp.Last, nil
+}
+
+// loadCheckpoint nil - файла нет, начинаем с начала
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("corrupted checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint через временный файл и rename, чтобы не оставить половину json
+func saveCheckpoint(path string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkukarin01/snort/internal/storage"
+)
+
+// seedFile файловое хранилище с n ссылками, каждая третья удалена
+func seedFile(t *testing.T, n int) *storage.FileStorage {
+	ctx := context.Background()
+	fs, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("id%03d", i)
+		require.NoError(t, fs.SaveUserURL(ctx, fmt.Sprintf("u%d", i%2), id, fmt.Sprintf("http://example.com/%d", i)))
+		if i%3 == 0 {
+			require.NoError(t, fs.MarkUserURLsDeleted(ctx, fmt.Sprintf("u%d", i%2), []string{id}))
+		}
+	}
+	return fs
+}
+
+// failingImporter падает на заданной по счету пачке
+type failingImporter struct {
+	storage.Importer
+	failOn int
+	calls  int
+}
+
+func (f *failingImporter) Import(ctx context.Context, records []storage.Record) error {
+	f.calls++
+	if f.calls == f.failOn {
+		return errors.New("boom")
+	}
+	return f.Importer.Import(ctx, records)
+}
+
+// TestCopy_FileToMem - переносятся все поля, включая флаг удаления
+func TestCopy_FileToMem(t *testing.T) {
+	ctx := context.Background()
+	src := seedFile(t, 10)
+	dst := storage.NewMemoryStorage()
+
+	stats, err := Copy(ctx, src, dst, Options{BatchSize: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), stats.Copied)
+	assert.Equal(t, int64(10), stats.DestCount)
+
+	url, err := dst.Load(ctx, "id001")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url)
+	_, err = dst.Load(ctx, "id003")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+
+	urls, err := dst.GetUserURLs(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 3) // 1,5,7, а 3 и 9 удалены
+}
+
+// TestCopy_Resume - после падения продолжаем с checkpoint, а не с начала
+func TestCopy_Resume(t *testing.T) {
+	ctx := context.Background()
+	src := seedFile(t, 10)
+	dst := storage.NewMemoryStorage()
+	checkpoint := filepath.Join(t.TempDir(), "cp.json")
+	opts := Options{BatchSize: 3, Checkpoint: checkpoint, Source: "src", Dest: "dst"}
+
+	_, err := Copy(ctx, src, &failingImporter{Importer: dst, failOn: 3}, opts)
+	require.Error(t, err)
+	after, err := ResumeAfter(checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "id005", after)
+
+	// чужой checkpoint не подхватываем
+	_, err = Copy(ctx, src, dst, Options{Checkpoint: checkpoint, Source: "other", Dest: "dst"})
+	assert.ErrorContains(t, err, "belongs to src -> dst")
+
+	counting := &failingImporter{Importer: dst}
+	stats, err := Copy(ctx, src, counting, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "id005", stats.ResumedAfter)
+	assert.Equal(t, 2, counting.calls)
+	assert.Equal(t, int64(10), stats.Copied)
+	assert.NoFileExists(t, checkpoint)
+}
+
+// TestCopy_CountMismatch - в приемнике не хватает записей
+func TestCopy_CountMismatch(t *testing.T) {
+	ctx := context.Background()
+	src := seedFile(t, 5)
+
+	_, err := Copy(ctx, src, &lossyImporter{MemoryStorage: storage.NewMemoryStorage()}, Options{})
+	assert.ErrorIs(t, err, ErrCountMismatch)
+}
+
+// lossyImporter теряет последнюю запись каждой пачки
+type lossyImporter struct {
+	*storage.MemoryStorage
+}
+
+func (l *lossyImporter) Import(ctx context.Context, records []storage.Record) error {
+	return l.MemoryStorage.Import(ctx, records[:len(records)-1])
+}